@@ -16,9 +16,11 @@ package config
 
 import (
 	"os"
+	"path"
 	"strings"
 	"time"
 
+	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/redis"
 	lksdk "github.com/livekit/server-sdk-go/v2"
@@ -36,15 +38,27 @@ type BaseConfig struct {
 	WsUrl     string             `yaml:"ws_url"`     // (env LIVEKIT_WS_URL)
 
 	// optional
-	Logging                      *logger.Config `yaml:"logging"`                          // logging config
-	TemplateBase                 string         `yaml:"template_base"`                    // custom template base url
-	ClusterID                    string         `yaml:"cluster_id"`                       // cluster this instance belongs to
-	EnableChromeSandbox          bool           `yaml:"enable_chrome_sandbox"`            // enable Chrome sandbox, requires extra docker configuration
-	MaxUploadQueue               int            `yaml:"max_upload_queue"`                 // maximum upload queue size, in minutes
-	DisallowLocalStorage         bool           `yaml:"disallow_local_storage"`           // require an upload config for all requests
-	EnableRoomCompositeSDKSource bool           `yaml:"enable_room_composite_sdk_source"` // attempt to render supported audio only room composite use cases using the SDK source instead of Chrome. This option will be removed when this becomes the default behavior eventually.
-	IOCreateTimeout              time.Duration  `yaml:"io_create_timeout"`                // timeout for CreateEgress calls
-	IOUpdateTimeout              time.Duration  `yaml:"io_update_timeout"`                // timeout for UpdateEgress calls
+	Logging                      *logger.Config           `yaml:"logging"`                          // logging config
+	TemplateBase                 string                   `yaml:"template_base"`                    // custom template base url
+	ClusterID                    string                   `yaml:"cluster_id"`                       // cluster this instance belongs to
+	EnableChromeSandbox          bool                     `yaml:"enable_chrome_sandbox"`            // enable Chrome sandbox, requires extra docker configuration
+	MaxUploadQueue               int                      `yaml:"max_upload_queue"`                 // maximum upload queue size, in minutes
+	DisallowLocalStorage         bool                     `yaml:"disallow_local_storage"`           // require an upload config for all requests
+	EnableRoomCompositeSDKSource bool                     `yaml:"enable_room_composite_sdk_source"` // attempt to render supported audio only room composite use cases using the SDK source instead of Chrome. This option will be removed when this becomes the default behavior eventually.
+	IOCreateTimeout              time.Duration            `yaml:"io_create_timeout"`                // timeout for CreateEgress calls
+	IOUpdateTimeout              time.Duration            `yaml:"io_update_timeout"`                // timeout for UpdateEgress calls
+	SegmentProtocol              types.SegmentProtocol    `yaml:"segment_protocol"`                 // segmented output format, hls (mpeg-ts, default), cmaf (fragmented mp4) or dash
+	SegmentRenditions            []*RenditionConfig       `yaml:"segment_renditions,omitempty"`     // adaptive bitrate ladder for segmented video outputs
+	LowLatencyHLS                *LowLatencyHLSConfig     `yaml:"low_latency_hls,omitempty"`        // partial segments for live hls playlists
+	LivePlaylistWindow           *LiveWindowConfig        `yaml:"live_playlist_window,omitempty"`   // segments listed in live playlists
+	SegmentEncryption            *SegmentEncryptionConfig `yaml:"segment_encryption,omitempty"`     // hls segment encryption
+	ResumeSegments               bool                     `yaml:"resume_segments"`                  // append to the playlist left by a previous egress with the same output, if it did not finish
+	TimedMetadata                *TimedMetadataConfig     `yaml:"timed_metadata,omitempty"`         // markers inserted into hls playlists
+	PlaylistUpload               *PlaylistUploadConfig    `yaml:"playlist_upload,omitempty"`        // how often full segment playlists are uploaded
+	Thumbnails                   *ThumbnailsConfig        `yaml:"thumbnails,omitempty"`             // scrub-bar preview sprite sheets for file and segment recordings
+	HTTPIngest                   *HTTPIngestConfig        `yaml:"http_ingest,omitempty"`            // hls and dash stream outputs, pushed to http ingest endpoints
+	StreamReconnect              *StreamReconnectConfig   `yaml:"stream_reconnect,omitempty"`       // rtmp and srt reconnect policy and stream health reporting
+	RTSPServer                   *RTSPServerConfig        `yaml:"rtsp_server,omitempty"`            // rtsp stream urls with mode=server, hosted by the egress. Disabled unless set
 
 	SessionLimits `yaml:"session_limits"` // session duration limits
 	StorageConfig *StorageConfig          `yaml:"storage,omitempty"` // storage config
@@ -60,6 +74,80 @@ type DebugConfig struct {
 	StorageConfig   `yaml:",inline"` // upload config (S3, Azure, GCP, or AliOSS)
 }
 
+type LowLatencyHLSConfig struct {
+	PartDuration float64 `yaml:"part_duration"` // partial segment duration, in seconds (default 1)
+	Port         int     `yaml:"port"`          // serves local storage outputs with blocking playlist reload, under /<egress_id>/ (default 0, disabled)
+}
+
+// PartServerSocket is where a handler serves blocking playlist reloads for low-latency hls outputs
+func PartServerSocket(egressID string) string {
+	return path.Join(TmpDir, egressID, "part_server.sock")
+}
+
+type LiveWindowConfig struct {
+	Segments      int           `yaml:"segments"`       // segment count (default 5)
+	Duration      time.Duration `yaml:"duration"`       // total segment duration, e.g. 30m for a dvr window. Replaces segments
+	DeleteExpired bool          `yaml:"delete_expired"` // delete segments from storage once they leave the window. Only applies to outputs with a live playlist and no playlist name
+}
+
+// SegmentEncryptionConfig encrypts hls segments before upload. Exactly one key source is required.
+type SegmentEncryptionConfig struct {
+	Method           string `yaml:"method"`            // AES-128 (default)
+	Key              string `yaml:"key"`               // base64 encoded 16 byte key
+	KeyURI           string `yaml:"key_uri"`           // key uri listed in playlists, required with a static key
+	KeyServerUrl     string `yaml:"key_server_url"`    // endpoint which returns keys and their uris, instead of a static key
+	RotationSegments int    `yaml:"rotation_segments"` // segments encrypted with each key, key server only (default 0, never rotated)
+}
+
+// PlaylistUploadConfig throttles full playlist uploads, which grow with every segment. Live playlists are uploaded with every segment.
+type PlaylistUploadConfig struct {
+	Policy   string        `yaml:"policy"`   // always, segments, or interval (default uploads less often as the playlist grows, down to once per minute)
+	Segments int           `yaml:"segments"` // segments policy - upload every N segments
+	Interval time.Duration `yaml:"interval"` // interval policy - upload at most once per interval, and within an interval of each segment
+}
+
+type TimedMetadataConfig struct {
+	DataTopic         string   `yaml:"data_topic"`         // room data messages with this topic are inserted, sdk source only
+	AllowedIdentities []string `yaml:"allowed_identities"` // participants whose data messages are inserted. Messages sent by the server api are always inserted
+	ID3               bool     `yaml:"id3"`                // also write markers into mpeg-ts segments as ID3 timed metadata
+}
+
+// HTTPIngestConfig applies to hls+http(s) and dash+http(s) stream urls
+type HTTPIngestConfig struct {
+	Method          string                       `yaml:"method"`           // PUT (default) or POST
+	Headers         map[string]string            `yaml:"headers"`          // sent with every request to every endpoint, so must not hold credentials
+	HostHeaders     map[string]map[string]string `yaml:"host_headers"`     // sent only to endpoints with a matching host name, e.g. a cdn auth token
+	SegmentDuration int                          `yaml:"segment_duration"` // segment duration, in seconds (default 2)
+	PlaylistWindow  int                          `yaml:"playlist_window"`  // segments listed in each playlist (default 5)
+	MaxAttempts     int                          `yaml:"max_attempts"`     // attempts per file before the stream fails (default 3)
+}
+
+// StreamReconnectConfig applies to rtmp and srt stream outputs, which are reconnected after a disconnection
+type StreamReconnectConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`    // attempts per disconnection, 0 for no limit (default 0)
+	Backoff        time.Duration `yaml:"backoff"`         // delay before the first attempt, doubled with each attempt (default 0, immediate)
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // longest delay between attempts (default 10s)
+	Window         time.Duration `yaml:"window"`          // give up this long after the first disconnection (default 30s)
+	HealthInterval time.Duration `yaml:"health_interval"` // how often stream health is sampled and reported (default 10s)
+}
+
+// RTSPServerConfig limits where rtsp streams hosted by the egress may listen
+type RTSPServerConfig struct {
+	ListenHosts    []string      `yaml:"listen_hosts"`     // hosts stream urls may listen on (default 0.0.0.0)
+	PortRangeStart int           `yaml:"port_range_start"` // first port stream urls may listen on
+	PortRangeEnd   int           `yaml:"port_range_end"`   // last port stream urls may listen on
+	SessionTimeout time.Duration `yaml:"session_timeout"`  // players are disconnected after this long without a request or rtcp (default 60s)
+}
+
+// ThumbnailsConfig captures frames into sprite sheets, listed in a WebVTT thumbnail track
+type ThumbnailsConfig struct {
+	Interval uint32 `yaml:"interval"` // seconds between thumbnails (default 5)
+	Width    int32  `yaml:"width"`    // thumbnail width (default 160)
+	Height   int32  `yaml:"height"`   // thumbnail height (default 90)
+	Columns  int    `yaml:"columns"`  // thumbnails per sheet row (default 5)
+	Rows     int    `yaml:"rows"`     // thumbnail rows per sheet (default 5)
+}
+
 type ProxyConfig struct {
 	Url      string `yaml:"url"`
 	Username string `yaml:"username"`
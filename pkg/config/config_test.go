@@ -17,9 +17,11 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/livekit"
 )
 
@@ -92,3 +94,474 @@ func TestSegmentNaming(t *testing.T) {
 		require.Equal(t, test.expectedSegmentPrefix, o.SegmentPrefix)
 	}
 }
+
+func TestSegmentProtocol(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{FilenamePrefix: "filename"}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, types.OutputTypeTS, o.SegmentOutputType)
+	require.Empty(t, o.InitSegmentFilename)
+
+	p.SegmentProtocol = types.SegmentProtocolCMAF
+	o, err = p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, types.OutputTypeHLS, o.OutputType)
+	require.Equal(t, types.OutputTypeM4S, o.SegmentOutputType)
+	require.Equal(t, "filename_init.mp4", o.InitSegmentFilename)
+
+	p.SegmentProtocol = types.SegmentProtocolDASH
+	o, err = p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, types.OutputTypeDASH, o.OutputType)
+	require.Equal(t, types.OutputTypeM4S, o.SegmentOutputType)
+	require.Equal(t, "filename.mpd", o.PlaylistFilename)
+	require.Equal(t, "filename_init.mp4", o.InitSegmentFilename)
+
+	p.SegmentProtocol = "smooth"
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestSegmentRenditions(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix:   "filename",
+		PlaylistName:     "playlist.m3u8",
+		LivePlaylistName: "live.m3u8",
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	p.VideoEnabled = true
+	p.SegmentRenditions = []*RenditionConfig{
+		{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 3000},
+		{Name: "360p", Width: 640, Height: 360, VideoBitrate: 800},
+	}
+
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, "playlist.m3u8", o.PlaylistFilename)
+	require.Len(t, o.Renditions, 2)
+
+	r := o.Renditions[1]
+	require.Equal(t, "filename_360p", r.SegmentPrefix)
+	require.Equal(t, "playlist_360p.m3u8", r.PlaylistFilename)
+	require.Equal(t, "live_360p.m3u8", r.LivePlaylistFilename)
+	require.Empty(t, r.Renditions)
+	require.Equal(t, 30, H264Level(r.Rendition.Width, r.Rendition.Height, 30))
+	require.Equal(t, 31, H264Level(1280, 720, 30))
+	require.Equal(t, 40, H264Level(1920, 1080, 30))
+
+	p.SegmentRenditions = append(p.SegmentRenditions, &RenditionConfig{Name: "360p", Width: 640, Height: 360, VideoBitrate: 800})
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	p.SegmentRenditions = p.SegmentRenditions[:2]
+	p.SegmentProtocol = types.SegmentProtocolDASH
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestLowLatencyHLS(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix:   "filename",
+		LivePlaylistName: "live.m3u8",
+		SegmentDuration:  4,
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	p.LowLatencyHLS = &LowLatencyHLSConfig{Port: 8080}
+
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), o.PartDuration)
+	require.Equal(t, PartServerSocket("egress_ID"), o.PartServerSocket)
+	require.Equal(t, "filename_part00003.ts", o.PartFilename(3))
+	require.Equal(t, "filename_00003.ts", o.SegmentFilename(3, time.Now()))
+
+	// blocking reload is only served for local storage
+	p.StorageConfig = &StorageConfig{S3: &S3Config{Bucket: "bucket"}}
+	o, err = p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Empty(t, o.PartServerSocket)
+
+	// parts are only listed in live playlists
+	o, err = p.getSegmentConfig(&livekit.SegmentedFileOutput{FilenamePrefix: "filename"})
+	require.NoError(t, err)
+	require.Zero(t, o.PartDuration)
+
+	p.LowLatencyHLS.PartDuration = 4
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	p.LowLatencyHLS.PartDuration = 0.5
+	p.SegmentProtocol = types.SegmentProtocolDASH
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestLiveWindow(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix:   "filename",
+		PlaylistName:     "playlist.m3u8",
+		LivePlaylistName: "live.m3u8",
+		SegmentDuration:  6,
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, 5, o.LiveWindowSegments)
+	require.Zero(t, o.LiveWindowDuration)
+
+	p.LivePlaylistWindow = &LiveWindowConfig{Segments: 10, Duration: 30 * time.Minute, DeleteExpired: true}
+	o, err = p.getSegmentConfig(&livekit.SegmentedFileOutput{FilenamePrefix: "filename", LivePlaylistName: "live.m3u8"})
+	require.NoError(t, err)
+	require.Zero(t, o.LiveWindowSegments)
+	require.Equal(t, 30*time.Minute, o.LiveWindowDuration)
+	require.True(t, o.DeleteExpiredSegments)
+	require.Empty(t, o.PlaylistFilename)
+	require.Equal(t, "live.m3u8", o.LivePlaylistFilename)
+
+	// requested playlists are always written, so their segments are kept
+	o, err = p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.False(t, o.DeleteExpiredSegments)
+	require.Equal(t, "playlist.m3u8", o.PlaylistFilename)
+
+	o, err = p.getSegmentConfig(&livekit.SegmentedFileOutput{FilenamePrefix: "filename", PlaylistName: "playlist.m3u8"})
+	require.NoError(t, err)
+	require.False(t, o.DeleteExpiredSegments)
+
+	p.LivePlaylistWindow = &LiveWindowConfig{Segments: -1}
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestSegmentEncryption(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix:   "filename",
+		PlaylistName:     "playlist.m3u8",
+		LivePlaylistName: "live.m3u8",
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	p.SegmentEncryption = &SegmentEncryptionConfig{
+		Key:    "AAECAwQFBgcICQoLDA0ODw==",
+		KeyURI: "https://keys.example.com/key",
+	}
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, SegmentEncryptionAES128, o.Encryption.Method)
+	require.Empty(t, p.SegmentEncryption.Method)
+
+	// keys are only rotated by a key server
+	p.SegmentEncryption.RotationSegments = 10
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	p.SegmentEncryption = &SegmentEncryptionConfig{KeyServerUrl: "http://localhost:8080", RotationSegments: 10}
+	o, err = p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, 10, o.Encryption.RotationSegments)
+
+	p.SegmentEncryption.Method = "SAMPLE-AES"
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	p.SegmentEncryption = &SegmentEncryptionConfig{Key: "AAECAw==", KeyURI: "https://keys.example.com/key"}
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	p.SegmentEncryption = &SegmentEncryptionConfig{KeyServerUrl: "http://localhost:8080"}
+	p.LowLatencyHLS = &LowLatencyHLSConfig{}
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestResumeSegments(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix:   "filename",
+		PlaylistName:     "playlist.m3u8",
+		LivePlaylistName: "live.m3u8",
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	p.ResumeSegments = true
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.True(t, o.Resume)
+
+	// numbering continues from the existing playlist
+	o.FirstSegmentIndex = 12
+	require.Equal(t, "filename_00012.ts", o.SegmentFilename(o.FirstSegmentIndex, time.Now()))
+
+	// only event playlists are resumed
+	p.LivePlaylistWindow = &LiveWindowConfig{DeleteExpired: true}
+	o, err = p.getSegmentConfig(&livekit.SegmentedFileOutput{FilenamePrefix: "filename", LivePlaylistName: "live.m3u8"})
+	require.NoError(t, err)
+	require.False(t, o.Resume)
+	p.LivePlaylistWindow = nil
+
+	p.SegmentProtocol = types.SegmentProtocolCMAF
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestTimedMetadata(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix: "filename",
+		PlaylistName:   "playlist.m3u8",
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	p.TimedMetadata = &TimedMetadataConfig{ID3: true}
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.True(t, o.ID3Metadata)
+
+	// id3 is only written into mpeg-ts segments
+	p.SegmentProtocol = types.SegmentProtocolCMAF
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	m := &TimedMetadata{
+		ID:         "ad-1",
+		Cue:        TimedMetadataCueOut,
+		Attributes: map[string]string{"X-AD-ID": "42"},
+	}
+	require.NoError(t, m.Validate())
+	require.False(t, m.StartDate.IsZero())
+
+	m.Attributes = map[string]string{"AD-ID": "42"}
+	require.Error(t, m.Validate())
+
+	m.Attributes = nil
+	m.Cue = "CUE-OUT-CONT"
+	require.Error(t, m.Validate())
+
+	m.Cue = ""
+	m.ID = ""
+	require.Error(t, m.Validate())
+}
+
+func TestPlaylistUpload(t *testing.T) {
+	segments := &livekit.SegmentedFileOutput{
+		FilenamePrefix: "filename",
+		PlaylistName:   "playlist.m3u8",
+	}
+
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	o, err := p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Nil(t, o.PlaylistUpload)
+
+	p.PlaylistUpload = &PlaylistUploadConfig{Policy: PlaylistUploadInterval, Interval: time.Second * 30}
+	o, err = p.getSegmentConfig(segments)
+	require.NoError(t, err)
+	require.Equal(t, time.Second*30, o.PlaylistUpload.Interval)
+
+	p.PlaylistUpload = &PlaylistUploadConfig{Policy: PlaylistUploadSegments}
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+
+	p.PlaylistUpload = &PlaylistUploadConfig{Policy: "never"}
+	_, err = p.getSegmentConfig(segments)
+	require.Error(t, err)
+}
+
+func TestThumbnails(t *testing.T) {
+	p := &PipelineConfig{
+		TmpDir:  t.TempDir(),
+		Outputs: make(map[types.EgressType][]OutputConfig),
+		Info:    &livekit.EgressInfo{EgressId: "egress_ID"},
+	}
+	p.Thumbnails = &ThumbnailsConfig{Columns: 4}
+
+	file, err := p.getEncodedFileConfig(&livekit.EncodedFileOutput{
+		FileType: livekit.EncodedFileType_MP4,
+		Filepath: "recordings/room.mp4",
+	})
+	require.NoError(t, err)
+	p.Outputs[types.EgressTypeFile] = []OutputConfig{file}
+
+	o, err := p.getThumbnailConfig()
+	require.NoError(t, err)
+	require.Equal(t, "recordings/", o.StorageDir)
+	require.Equal(t, "room_thumbnails", o.Prefix)
+	require.Equal(t, "room_thumbnails.vtt", o.TrackFilename)
+	require.Equal(t, uint32(5), o.Interval)
+	require.Equal(t, 4, o.Columns)
+	require.Equal(t, 5, o.Rows)
+
+	segments, err := p.getSegmentConfig(&livekit.SegmentedFileOutput{
+		FilenamePrefix: "segments/room",
+		PlaylistName:   "playlist.m3u8",
+	})
+	require.NoError(t, err)
+	p.Outputs[types.EgressTypeSegments] = []OutputConfig{segments}
+	o.updatePrefix(p)
+	require.Equal(t, "segments/", o.StorageDir)
+	require.Equal(t, "playlist_thumbnails", o.Prefix)
+
+	// thumbnails don't depend on the manifest
+	segments.DisableManifest = true
+	o, err = p.getThumbnailConfig()
+	require.NoError(t, err)
+	require.Equal(t, "playlist_thumbnails.vtt", o.TrackFilename)
+
+	p.Thumbnails.Rows = -1
+	_, err = p.getThumbnailConfig()
+	require.Error(t, err)
+}
+
+func TestStreamReconnect(t *testing.T) {
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	o, err := p.getStreamConfig(types.OutputTypeRTMP, []string{"rtmp://localhost/live/key"})
+	require.NoError(t, err)
+	require.Equal(t, time.Second*30, o.Reconnect.Window)
+	require.Equal(t, time.Duration(0), o.Reconnect.ReconnectDelay(3))
+
+	p.StreamReconnect = &StreamReconnectConfig{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: time.Second * 5}
+	o, err = p.getStreamConfig(types.OutputTypeRTMP, []string{"rtmp://localhost/live/key"})
+	require.NoError(t, err)
+	require.Equal(t, 5, o.Reconnect.MaxAttempts)
+	require.Equal(t, time.Second*10, o.Reconnect.HealthInterval)
+	require.Equal(t, time.Second, o.Reconnect.ReconnectDelay(0))
+	require.Equal(t, time.Second*4, o.Reconnect.ReconnectDelay(2))
+	require.Equal(t, time.Second*5, o.Reconnect.ReconnectDelay(3))
+
+	o, err = p.getStreamConfig(types.OutputTypeSRT, []string{"srt://localhost:8888"})
+	require.NoError(t, err)
+	require.Equal(t, 5, o.Reconnect.MaxAttempts)
+
+	o, err = p.getStreamConfig(types.OutputTypeRTSP, []string{"rtsp://localhost:8554/live"})
+	require.NoError(t, err)
+	require.Nil(t, o.Reconnect)
+
+	p.StreamReconnect = &StreamReconnectConfig{Window: -time.Second}
+	_, err = p.getStreamConfig(types.OutputTypeRTMP, []string{"rtmp://localhost/live/key"})
+	require.Error(t, err)
+}
+
+func TestRTSPServer(t *testing.T) {
+	p := &PipelineConfig{Info: &livekit.EgressInfo{EgressId: "egress_ID"}}
+	_, err := p.getStreamConfig(types.OutputTypeRTSP, []string{"rtsp://0.0.0.0:8554/live?mode=server"})
+	require.Error(t, err)
+
+	p.RTSPServer = &RTSPServerConfig{PortRangeStart: 8554, PortRangeEnd: 8555}
+	o, err := p.getStreamConfig(types.OutputTypeRTSP, []string{"rtsp://0.0.0.0:8554/live?mode=server"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.0.0.0"}, o.RTSPServer.ListenHosts)
+	require.Equal(t, time.Second*60, o.RTSPServer.SessionTimeout)
+
+	p.RTSPServer = &RTSPServerConfig{PortRangeStart: 8555, PortRangeEnd: 8554}
+	_, err = p.getStreamConfig(types.OutputTypeRTSP, []string{"rtsp://localhost:8554/live"})
+	require.Error(t, err)
+}
+
+func TestMirrorOutputs(t *testing.T) {
+	newPipelineConfig := func() *PipelineConfig {
+		return &PipelineConfig{
+			TmpDir:  t.TempDir(),
+			Outputs: make(map[types.EgressType][]OutputConfig),
+			Info:    &livekit.EgressInfo{EgressId: "egress_ID"},
+		}
+	}
+	file := func(bucket string) *livekit.EncodedFileOutput {
+		return &livekit.EncodedFileOutput{
+			FileType: livekit.EncodedFileType_MP4,
+			Filepath: "recordings/room.mp4",
+			Output:   &livekit.EncodedFileOutput_S3{S3: &livekit.S3Upload{Bucket: bucket}},
+		}
+	}
+
+	// outputs which only differ in storage are mirrors of the first
+	p := newPipelineConfig()
+	require.NoError(t, p.updateEncodedOutputs(&livekit.RoomCompositeEgressRequest{
+		FileOutputs: []*livekit.EncodedFileOutput{file("primary"), file("mirror")},
+	}))
+	o := p.GetFileConfig()
+	require.Equal(t, "primary", o.StorageConfig.S3.Bucket)
+	require.Len(t, o.MirrorConfigs, 1)
+	require.Equal(t, "mirror", o.MirrorConfigs[0].S3.Bucket)
+	require.Len(t, p.Info.FileResults, 2)
+
+	o.FileInfo.Filename = "recordings/room.mp4"
+	o.FileInfo.Location = "primary/recordings/room.mp4"
+	o.MirrorInfos[0].Location = "mirror/recordings/room.mp4"
+	p.UpdateMirrorInfo()
+	require.Equal(t, "recordings/room.mp4", p.Info.FileResults[1].Filename)
+	require.Equal(t, "mirror/recordings/room.mp4", p.Info.FileResults[1].Location)
+
+	// other differences are still rejected
+	other := file("mirror")
+	other.Filepath = "recordings/other.mp4"
+	p = newPipelineConfig()
+	require.Error(t, p.updateEncodedOutputs(&livekit.RoomCompositeEgressRequest{
+		FileOutputs: []*livekit.EncodedFileOutput{file("primary"), other},
+	}))
+
+	// images are grouped with the earlier output they mirror
+	p = newPipelineConfig()
+	p.VideoEnabled = true
+	image := func(prefix, bucket string) *livekit.ImageOutput {
+		return &livekit.ImageOutput{
+			CaptureInterval: 5,
+			FilenamePrefix:  prefix,
+			Output:          &livekit.ImageOutput_S3{S3: &livekit.S3Upload{Bucket: bucket}},
+		}
+	}
+	require.NoError(t, p.updateEncodedOutputs(&livekit.RoomCompositeEgressRequest{
+		ImageOutputs: []*livekit.ImageOutput{image("a", "primary"), image("b", "primary"), image("a", "mirror")},
+	}))
+	images := p.GetImageConfigs()
+	require.Len(t, images, 2)
+	require.Len(t, images[0].MirrorConfigs, 1)
+	require.Empty(t, images[1].MirrorConfigs)
+}
+
+func TestUpdateInfoFromSDK(t *testing.T) {
+	p := &PipelineConfig{
+		TmpDir:  t.TempDir(),
+		Outputs: make(map[types.EgressType][]OutputConfig),
+		Info:    &livekit.EgressInfo{EgressId: "egress_ID"},
+	}
+	p.SegmentProtocol = types.SegmentProtocolCMAF
+
+	o, err := p.getSegmentConfig(&livekit.SegmentedFileOutput{
+		FilenamePrefix: "{publisher_identity}/{track_id}",
+		PlaylistName:   "{publisher_identity}/playlist.m3u8",
+	})
+	require.NoError(t, err)
+	p.Outputs[types.EgressTypeSegments] = []OutputConfig{o}
+
+	require.NoError(t, p.UpdateInfoFromSDK("TR_1", map[string]string{
+		"{publisher_identity}": "alice",
+		"{track_id}":           "TR_1",
+	}, 0, 0))
+	require.Equal(t, "alice/", o.StorageDir)
+	require.Equal(t, "TR_1", o.SegmentPrefix)
+	require.Equal(t, "TR_1_init.mp4", o.InitSegmentFilename)
+
+	// renditions are updated along with their output
+	p.Outputs = make(map[types.EgressType][]OutputConfig)
+	p.VideoEnabled = true
+	p.SegmentRenditions = []*RenditionConfig{{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 3000}}
+	o, err = p.getSegmentConfig(&livekit.SegmentedFileOutput{
+		FilenamePrefix: "{publisher_identity}/{track_id}",
+		PlaylistName:   "{publisher_identity}/playlist.m3u8",
+	})
+	require.NoError(t, err)
+	p.Outputs[types.EgressTypeSegments] = []OutputConfig{o}
+
+	require.NoError(t, p.UpdateInfoFromSDK("TR_1", map[string]string{
+		"{publisher_identity}": "alice",
+		"{track_id}":           "TR_1",
+	}, 0, 0))
+	r := o.Renditions[0]
+	require.Equal(t, "alice/", r.StorageDir)
+	require.Equal(t, "TR_1_720p", r.SegmentPrefix)
+	require.Equal(t, "TR_1_720p_init.mp4", r.InitSegmentFilename)
+	require.Equal(t, "alice/playlist_720p.m3u8", r.SegmentsInfo.PlaylistName)
+}
@@ -35,32 +35,98 @@ type Manifest struct {
 	AudioTrackID      string `json:"audio_track_id,omitempty"`
 	VideoTrackID      string `json:"video_track_id,omitempty"`
 
-	mu        sync.Mutex
-	Files     []*File     `json:"files,omitempty"`
-	Playlists []*Playlist `json:"playlists,omitempty"`
-	Images    []*Image    `json:"images,omitempty"`
+	mu         sync.Mutex
+	Files      []*File       `json:"files,omitempty"`
+	Playlists  []*Playlist   `json:"playlists,omitempty"`
+	Images     []*Image      `json:"images,omitempty"`
+	Thumbnails []*Thumbnails `json:"thumbnails,omitempty"`
 }
 
 type File struct {
 	Filename string `json:"filename,omitempty"`
 	Location string `json:"location,omitempty"`
+	UploadInfo
+	Encryption *Encryption `json:"encryption,omitempty"`
 }
 
 type Playlist struct {
-	mu       sync.Mutex
-	Location string     `json:"location,omitempty"`
-	Segments []*Segment `json:"segments,omitempty"`
+	mu          sync.Mutex
+	Filename    string             `json:"filename,omitempty"`
+	Location    string             `json:"location,omitempty"`
+	Master      bool               `json:"master,omitempty"`       // lists each rendition's media playlist
+	Rendition   *PlaylistRendition `json:"rendition,omitempty"`    // set for media playlists of multi-rendition outputs
+	InitSegment *Segment           `json:"init_segment,omitempty"` // cmaf only
+	Segments    []*Segment         `json:"segments,omitempty"`
+	Encryption  *Encryption        `json:"encryption,omitempty"` // applies to the playlist and its segments
+	Keys        []*SegmentKey      `json:"keys,omitempty"`       // hls segment encryption keys, in order of use
+}
+
+// SegmentKey is an hls segment encryption key. Segments use their media sequence number as the IV.
+type SegmentKey struct {
+	Method        string `json:"method,omitempty"`
+	URI           string `json:"uri,omitempty"`
+	MediaSequence int    `json:"media_sequence"` // first segment encrypted with this key
+}
+
+type PlaylistRendition struct {
+	Name      string `json:"name,omitempty"`
+	Width     int32  `json:"width,omitempty"`
+	Height    int32  `json:"height,omitempty"`
+	Bandwidth int    `json:"bandwidth,omitempty"` // bits per second
 }
 
 type Segment struct {
 	Filename string `json:"filename,omitempty"`
 	Location string `json:"location,omitempty"`
+	UploadInfo
+	DeletedAt int64 `json:"deleted_at,omitempty"` // set once deleted from storage after leaving the live window
 }
 
 type Image struct {
 	Filename  string    `json:"filename,omitempty"`
 	Timestamp time.Time `json:"timestamp,omitempty"`
 	Location  string    `json:"location,omitempty"`
+	UploadInfo
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// Thumbnails are sprite sheets of scrub-bar previews, and the WebVTT track which points into them.
+// Thumbnail failures do not fail the egress.
+type Thumbnails struct {
+	mu            sync.Mutex
+	Track         *File       `json:"track,omitempty"`
+	Sheets        []*File     `json:"sheets,omitempty"`
+	Encryption    *Encryption `json:"encryption,omitempty"`
+	DroppedFrames int64       `json:"dropped_frames,omitempty"` // frames dropped while sheets were being uploaded
+	Error         string      `json:"error,omitempty"`          // set if thumbnails stopped early, in which case the track is not uploaded
+}
+
+// UploadInfo describes how a file was stored
+type UploadInfo struct {
+	Checksums
+	Backup  bool            `json:"backup,omitempty"`  // uploaded to backup storage
+	Mirrors []*MirrorUpload `json:"mirrors,omitempty"` // one per mirror destination, in config order
+}
+
+// MirrorUpload is the result of uploading a file to a mirror destination. Mirror failures do not fail the egress.
+type MirrorUpload struct {
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Checksums are hex encoded digests of the local file, verified against storage where the backend supports it
+type Checksums struct {
+	SHA256 string `json:"sha256,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+}
+
+// Encryption describes how uploaded files were encrypted. Checksums are of the encrypted files.
+type Encryption struct {
+	Algorithm  string `json:"algorithm,omitempty"`
+	ChunkSize  int    `json:"chunk_size,omitempty"`
+	KeySource  string `json:"key_source,omitempty"`  // static, file or kms
+	KeyID      string `json:"key_id,omitempty"`      // identifies the key used to wrap the data key
+	WrappedKey string `json:"wrapped_key,omitempty"` // base64 encoded, encrypted data key
 }
 
 func (p *PipelineConfig) initManifest() {
@@ -85,31 +151,33 @@ func (p *PipelineConfig) shouldCreateManifest() bool {
 	if p.BackupConfig != nil {
 		return true
 	}
-	if fc := p.GetFileConfig(); fc != nil && !fc.DisableManifest {
+	if fc := p.GetFileConfig(); fc != nil && (!fc.DisableManifest || len(fc.MirrorConfigs) > 0) {
 		return true
 	}
-	if sc := p.GetSegmentConfig(); sc != nil && !sc.DisableManifest {
+	if sc := p.GetSegmentConfig(); sc != nil && (!sc.DisableManifest || len(sc.MirrorConfigs) > 0) {
 		return true
 	}
 	for _, ic := range p.GetImageConfigs() {
-		if !ic.DisableManifest {
+		if !ic.DisableManifest || len(ic.MirrorConfigs) > 0 {
 			return true
 		}
 	}
 	return false
 }
 
-func (m *Manifest) AddFile(filename, location string) {
+func (m *Manifest) AddFile(filename, location string, info UploadInfo, encryption *Encryption) {
 	m.mu.Lock()
 	m.Files = append(m.Files, &File{
-		Filename: filename,
-		Location: location,
+		Filename:   filename,
+		Location:   location,
+		UploadInfo: info,
+		Encryption: encryption,
 	})
 	m.mu.Unlock()
 }
 
-func (m *Manifest) AddPlaylist() *Playlist {
-	p := &Playlist{}
+func (m *Manifest) AddPlaylist(encryption *Encryption) *Playlist {
+	p := &Playlist{Encryption: encryption}
 
 	m.mu.Lock()
 	m.Playlists = append(m.Playlists, p)
@@ -118,31 +186,107 @@ func (m *Manifest) AddPlaylist() *Playlist {
 	return p
 }
 
-func (p *Playlist) UpdateLocation(location string) {
+func (p *Playlist) UpdateLocation(filename, location string) {
 	p.mu.Lock()
+	p.Filename = filename
 	p.Location = location
 	p.mu.Unlock()
 }
 
-func (p *Playlist) AddSegment(filename, location string) {
+func (p *Playlist) SetInitSegment(filename, location string, info UploadInfo) {
+	p.mu.Lock()
+	p.InitSegment = &Segment{
+		Filename:   filename,
+		Location:   location,
+		UploadInfo: info,
+	}
+	p.mu.Unlock()
+}
+
+func (p *Playlist) AddSegment(filename, location string, info UploadInfo) {
 	p.mu.Lock()
 	p.Segments = append(p.Segments, &Segment{
-		Filename: filename,
-		Location: location,
+		Filename:   filename,
+		Location:   location,
+		UploadInfo: info,
 	})
 	p.mu.Unlock()
 }
 
-func (m *Manifest) AddImage(filename string, ts time.Time, location string) {
+func (p *Playlist) AddKey(method, uri string, mediaSequence int) {
+	p.mu.Lock()
+	p.Keys = append(p.Keys, &SegmentKey{
+		Method:        method,
+		URI:           uri,
+		MediaSequence: mediaSequence,
+	})
+	p.mu.Unlock()
+}
+
+// SetDeleted records that a segment has been deleted from storage
+func (p *Playlist) SetDeleted(filename string, deletedAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.Segments {
+		if s.Filename == filename {
+			s.DeletedAt = deletedAt.UnixNano()
+			return
+		}
+	}
+}
+
+func (m *Manifest) AddImage(filename string, ts time.Time, location string, info UploadInfo, encryption *Encryption) {
 	m.mu.Lock()
 	m.Images = append(m.Images, &Image{
-		Filename:  filename,
-		Timestamp: ts,
-		Location:  location,
+		Filename:   filename,
+		Timestamp:  ts,
+		Location:   location,
+		UploadInfo: info,
+		Encryption: encryption,
 	})
 	m.mu.Unlock()
 }
 
+func (m *Manifest) AddThumbnails(encryption *Encryption) *Thumbnails {
+	t := &Thumbnails{Encryption: encryption}
+
+	m.mu.Lock()
+	m.Thumbnails = append(m.Thumbnails, t)
+	m.mu.Unlock()
+
+	return t
+}
+
+func (t *Thumbnails) AddSheet(filename, location string, info UploadInfo) {
+	t.mu.Lock()
+	t.Sheets = append(t.Sheets, &File{
+		Filename:   filename,
+		Location:   location,
+		UploadInfo: info,
+	})
+	t.mu.Unlock()
+}
+
+func (t *Thumbnails) SetTrack(filename, location string, info UploadInfo) {
+	t.mu.Lock()
+	t.Track = &File{
+		Filename:   filename,
+		Location:   location,
+		UploadInfo: info,
+	}
+	t.mu.Unlock()
+}
+
+func (t *Thumbnails) SetResult(dropped int64, err error) {
+	t.mu.Lock()
+	t.DroppedFrames = dropped
+	if err != nil {
+		t.Error = err.Error()
+	}
+	t.mu.Unlock()
+}
+
 func (m *Manifest) Close(endedAt int64) ([]byte, error) {
 	m.EndedAt = endedAt
 
@@ -155,3 +299,44 @@ func (m *Manifest) Close(endedAt int64) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// Filenames returns the storage path of every file listed by a manifest read back from storage.
+// Segments which have been deleted are left out.
+func (m *Manifest) Filenames() map[string]bool {
+	filenames := make(map[string]bool)
+	add := func(f *File) {
+		if f != nil && f.Filename != "" {
+			filenames[f.Filename] = true
+		}
+	}
+	addSegment := func(s *Segment) {
+		if s != nil && s.Filename != "" && s.DeletedAt == 0 {
+			filenames[s.Filename] = true
+		}
+	}
+
+	for _, f := range m.Files {
+		add(f)
+	}
+	for _, p := range m.Playlists {
+		if p.Filename != "" {
+			filenames[p.Filename] = true
+		}
+		addSegment(p.InitSegment)
+		for _, s := range p.Segments {
+			addSegment(s)
+		}
+	}
+	for _, i := range m.Images {
+		if i.Filename != "" {
+			filenames[i.Filename] = true
+		}
+	}
+	for _, t := range m.Thumbnails {
+		add(t.Track)
+		for _, s := range t.Sheets {
+			add(s)
+		}
+	}
+	return filenames
+}
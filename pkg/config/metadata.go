@@ -0,0 +1,70 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/errors"
+)
+
+const (
+	TimedMetadataCueOut = "CUE-OUT"
+	TimedMetadataCueIn  = "CUE-IN"
+)
+
+var clientAttributeRegexp = regexp.MustCompile("^X-[A-Z0-9-]+$")
+
+// TimedMetadata is a marker inserted into segmented outputs, such as a chapter or an ad break.
+// It is received through the handler, or as a room data message with the configured topic.
+type TimedMetadata struct {
+	ID              string            `json:"id"`
+	Class           string            `json:"class,omitempty"`
+	StartDate       time.Time         `json:"start_date,omitempty"`       // defaults to now
+	Duration        float64           `json:"duration,omitempty"`         // seconds
+	PlannedDuration float64           `json:"planned_duration,omitempty"` // seconds, for markers which may end early
+	Cue             string            `json:"cue,omitempty"`              // CUE-OUT or CUE-IN, for ad breaks
+	SCTE35          []byte            `json:"scte35,omitempty"`           // splice_info_section, base64 encoded in json
+	Attributes      map[string]string `json:"attributes,omitempty"`       // client attributes, named X-<NAME>
+}
+
+func (m *TimedMetadata) Validate() error {
+	if m.ID == "" || strings.ContainsAny(m.ID, "\"\r\n") {
+		return errors.ErrInvalidInput("metadata id")
+	}
+	if strings.ContainsAny(m.Class, "\"\r\n") {
+		return errors.ErrInvalidInput("metadata class")
+	}
+	if m.Duration < 0 || m.PlannedDuration < 0 {
+		return errors.ErrInvalidInput("metadata duration")
+	}
+	switch m.Cue {
+	case "", TimedMetadataCueOut, TimedMetadataCueIn:
+	default:
+		return errors.ErrInvalidInput("metadata cue")
+	}
+	for name, value := range m.Attributes {
+		if !clientAttributeRegexp.MatchString(name) || strings.ContainsAny(value, "\"\r\n") {
+			return errors.ErrInvalidInput("metadata attributes")
+		}
+	}
+
+	if m.StartDate.IsZero() {
+		m.StartDate = time.Now()
+	}
+	return nil
+}
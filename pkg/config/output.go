@@ -17,6 +17,8 @@ package config
 import (
 	"net/url"
 
+	"google.golang.org/protobuf/proto"
+
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/egress"
@@ -50,16 +52,28 @@ func (p *PipelineConfig) updateEncodedOutputs(req egress.EncodedOutput) error {
 		if r, ok := req.(egress.EncodedOutputDeprecated); ok {
 			file = r.GetFile()
 		}
-	case 1:
-		file = files[0]
 	default:
-		return errors.ErrInvalidInput("multiple file outputs")
+		// outputs which only differ from the first in storage are uploaded as its mirrors
+		file = files[0]
+		for _, f := range files[1:] {
+			if !isMirror(file, f) {
+				return errors.ErrInvalidInput("multiple file outputs")
+			}
+		}
 	}
 	if file != nil {
 		conf, err := p.getEncodedFileConfig(file)
 		if err != nil {
 			return err
 		}
+		if len(files) > 1 {
+			if conf.MirrorConfigs, err = getMirrorConfigs(p, files[1:]); err != nil {
+				return err
+			}
+			for range conf.MirrorConfigs {
+				conf.MirrorInfos = append(conf.MirrorInfos, &livekit.FileInfo{})
+			}
+		}
 
 		p.Outputs[types.EgressTypeFile] = []OutputConfig{conf}
 		p.OutputCount.Inc()
@@ -68,7 +82,7 @@ func (p *PipelineConfig) updateEncodedOutputs(req egress.EncodedOutput) error {
 			p.VideoEncoding = true
 		}
 
-		p.Info.FileResults = []*livekit.FileInfo{conf.FileInfo}
+		p.Info.FileResults = append([]*livekit.FileInfo{conf.FileInfo}, conf.MirrorInfos...)
 		if len(streams)+len(segments)+len(images) == 0 {
 			p.Info.Result = &livekit.EgressInfo_File{File: conf.FileInfo}
 		}
@@ -147,25 +161,38 @@ func (p *PipelineConfig) updateEncodedOutputs(req egress.EncodedOutput) error {
 		if r, ok := req.(egress.EncodedOutputDeprecated); ok {
 			segment = r.GetSegments()
 		}
-	case 1:
-		segment = segments[0]
 	default:
-		return errors.ErrInvalidInput("multiple segmented file outputs")
+		// outputs which only differ from the first in storage are uploaded as its mirrors
+		segment = segments[0]
+		for _, s := range segments[1:] {
+			if !isMirror(segment, s) {
+				return errors.ErrInvalidInput("multiple segmented file outputs")
+			}
+		}
 	}
 	if segment != nil {
 		conf, err := p.getSegmentConfig(segment)
 		if err != nil {
 			return err
 		}
+		if len(segments) > 1 {
+			if conf.MirrorConfigs, err = getMirrorConfigs(p, segments[1:]); err != nil {
+				return err
+			}
+			for range conf.MirrorConfigs {
+				conf.MirrorInfos = append(conf.MirrorInfos, &livekit.SegmentsInfo{})
+			}
+		}
 
 		p.Outputs[types.EgressTypeSegments] = []OutputConfig{conf}
 		p.OutputCount.Inc()
 		p.FinalizationRequired = true
-		if p.VideoEnabled {
+		if p.VideoEnabled && len(conf.Renditions) == 0 {
+			// renditions encode their own video
 			p.VideoEncoding = true
 		}
 
-		p.Info.SegmentResults = []*livekit.SegmentsInfo{conf.SegmentsInfo}
+		p.Info.SegmentResults = append([]*livekit.SegmentsInfo{conf.SegmentsInfo}, conf.MirrorInfos...)
 		if len(streams)+len(files)+len(images) == 0 {
 			p.Info.Result = &livekit.EgressInfo_Segments{Segments: conf.SegmentsInfo}
 		}
@@ -176,13 +203,29 @@ func (p *PipelineConfig) updateEncodedOutputs(req egress.EncodedOutput) error {
 			// segment duration must match keyframe interval - use the lower of the two
 			conf := segmentConf[0].(*SegmentConfig)
 			conf.SegmentDuration = min(int(p.KeyFrameInterval), conf.SegmentDuration)
+			for _, r := range conf.Renditions {
+				r.SegmentDuration = conf.SegmentDuration
+			}
 		}
 		p.KeyFrameInterval = 0
+	} else if sc := p.GetStreamConfig(); sc != nil && sc.Ingest != nil {
+		// keyframes are requested at each split, like segment outputs
+		p.KeyFrameInterval = 0
 	} else if p.KeyFrameInterval == 0 && p.Outputs[types.EgressTypeStream] != nil {
 		// default 4s for streams
 		p.KeyFrameInterval = StreamKeyframeInterval
 	}
 
+	// thumbnails, for video recordings
+	if p.Thumbnails != nil && p.VideoEnabled && (file != nil || segment != nil) {
+		conf, err := p.getThumbnailConfig()
+		if err != nil {
+			return err
+		}
+
+		p.Outputs[types.EgressTypeThumbnails] = []OutputConfig{conf}
+	}
+
 	// image output
 	if len(images) > 0 {
 		if !p.VideoEnabled {
@@ -196,11 +239,26 @@ func (p *PipelineConfig) updateEncodedOutputs(req egress.EncodedOutput) error {
 			p.AudioTranscoding = false
 		}
 
+		primaries := make(map[*livekit.ImageOutput]*ImageConfig)
+	next:
 		for _, img := range images {
+			// outputs which only differ from an earlier one in storage are uploaded as its mirrors
+			for primary, conf := range primaries {
+				if isMirror(primary, img) {
+					sc, err := p.getStorageConfig(img)
+					if err != nil {
+						return err
+					}
+					conf.MirrorConfigs = append(conf.MirrorConfigs, sc)
+					continue next
+				}
+			}
+
 			conf, err := p.getImageConfig(img)
 			if err != nil {
 				return err
 			}
+			primaries[img] = conf
 
 			p.Outputs[types.EgressTypeImages] = append(p.Outputs[types.EgressTypeImages], conf)
 			p.OutputCount.Inc()
@@ -217,6 +275,54 @@ func (p *PipelineConfig) updateEncodedOutputs(req egress.EncodedOutput) error {
 	return nil
 }
 
+// UpdateMirrorInfo copies the final details of file and segment outputs to their mirrors, which only
+// keep their own locations
+func (p *PipelineConfig) UpdateMirrorInfo() {
+	if o := p.GetFileConfig(); o != nil {
+		for _, info := range o.MirrorInfos {
+			location := info.Location
+			proto.Reset(info)
+			proto.Merge(info, o.FileInfo)
+			info.Location = location
+		}
+	}
+	if o := p.GetSegmentConfig(); o != nil {
+		for _, info := range o.MirrorInfos {
+			playlistLocation, livePlaylistLocation := info.PlaylistLocation, info.LivePlaylistLocation
+			proto.Reset(info)
+			proto.Merge(info, o.SegmentsInfo)
+			info.PlaylistLocation, info.LivePlaylistLocation = playlistLocation, livePlaylistLocation
+		}
+	}
+}
+
+// isMirror returns true if an output is identical to the primary apart from its storage
+func isMirror(primary, output proto.Message) bool {
+	return proto.Equal(withoutStorage(primary), withoutStorage(output))
+}
+
+func withoutStorage(output proto.Message) proto.Message {
+	m := proto.Clone(output).ProtoReflect()
+	if oneof := m.Descriptor().Oneofs().ByName("output"); oneof != nil {
+		if field := m.WhichOneof(oneof); field != nil {
+			m.Clear(field)
+		}
+	}
+	return m.Interface()
+}
+
+func getMirrorConfigs[T egress.UploadRequest](p *PipelineConfig, outputs []T) ([]*StorageConfig, error) {
+	mirrors := make([]*StorageConfig, 0, len(outputs))
+	for _, o := range outputs {
+		sc, err := p.getStorageConfig(o)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, sc)
+	}
+	return mirrors, nil
+}
+
 func (p *PipelineConfig) updateDirectOutput(req *livekit.TrackEgressRequest) error {
 	switch o := req.Output.(type) {
 	case *livekit.TrackEgressRequest_File:
@@ -34,6 +34,8 @@ type FileConfig struct {
 
 	DisableManifest bool
 	StorageConfig   *StorageConfig
+	MirrorConfigs   []*StorageConfig
+	MirrorInfos     []*livekit.FileInfo // one per mirror, reported alongside FileInfo
 }
 
 func (p *PipelineConfig) GetFileConfig() *FileConfig {
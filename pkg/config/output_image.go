@@ -40,6 +40,7 @@ type ImageConfig struct {
 
 	DisableManifest bool
 	StorageConfig   *StorageConfig
+	MirrorConfigs   []*StorageConfig
 
 	CaptureInterval uint32
 	Width           int32
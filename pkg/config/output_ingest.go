@@ -0,0 +1,107 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/livekit"
+)
+
+const (
+	defaultIngestSegmentDuration = 2
+	defaultIngestMaxAttempts     = 3
+)
+
+// IngestConfig is used by hls and dash stream outputs. The stream is segmented once,
+// and segments and a live playlist are pushed to every stream url.
+type IngestConfig struct {
+	Segments *SegmentConfig
+
+	Method      string
+	Headers     map[string]string            // sent to every endpoint
+	HostHeaders map[string]map[string]string // host name -> headers sent only to that host
+	MaxAttempts int
+}
+
+func (p *PipelineConfig) getIngestConfig(outputType types.OutputType) (*IngestConfig, error) {
+	var conf HTTPIngestConfig
+	if p.HTTPIngest != nil {
+		conf = *p.HTTPIngest
+	}
+
+	ingest := &IngestConfig{
+		Method:      strings.ToUpper(conf.Method),
+		Headers:     conf.Headers,
+		HostHeaders: conf.HostHeaders,
+		MaxAttempts: conf.MaxAttempts,
+	}
+	switch ingest.Method {
+	case "":
+		ingest.Method = http.MethodPut
+	case http.MethodPut, http.MethodPost:
+	default:
+		return nil, errors.ErrInvalidInput("http_ingest method")
+	}
+	if ingest.MaxAttempts == 0 {
+		ingest.MaxAttempts = defaultIngestMaxAttempts
+	}
+	if conf.SegmentDuration < 0 || conf.PlaylistWindow < 0 || ingest.MaxAttempts < 0 {
+		return nil, errors.ErrInvalidInput("http_ingest")
+	}
+
+	o := &SegmentConfig{
+		outputConfig:       outputConfig{OutputType: outputType},
+		SegmentsInfo:       &livekit.SegmentsInfo{},
+		LocalDir:           path.Join(p.TmpDir, "ingest"),
+		SegmentPrefix:      "segment",
+		SegmentDuration:    conf.SegmentDuration,
+		LiveWindowSegments: conf.PlaylistWindow,
+		DisableManifest:    true,
+	}
+	if o.SegmentDuration == 0 {
+		o.SegmentDuration = defaultIngestSegmentDuration
+	}
+	if p.KeyFrameInterval > 0 {
+		// segments are split on keyframes
+		o.SegmentDuration = max(min(int(p.KeyFrameInterval), o.SegmentDuration), 1)
+	}
+	if o.LiveWindowSegments == 0 {
+		o.LiveWindowSegments = defaultLivePlaylistWindow
+	}
+
+	// each destination has its own playlist, since destinations can be added at any time
+	ext := types.FileExtensionForOutputType[outputType]
+	o.LivePlaylistFilename = "index" + string(ext)
+	switch outputType {
+	case types.OutputTypeHLS:
+		o.SegmentOutputType = types.OutputTypeTS
+	case types.OutputTypeDASH:
+		o.SegmentOutputType = types.OutputTypeM4S
+		o.InitSegmentFilename = o.SegmentPrefix + "_init" + string(types.FileExtensionMP4)
+	}
+
+	if err := os.MkdirAll(o.LocalDir, 0755); err != nil {
+		return nil, err
+	}
+
+	ingest.Segments = o
+	return ingest, nil
+}
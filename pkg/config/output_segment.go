@@ -15,6 +15,7 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
 	"path"
@@ -26,6 +27,16 @@ import (
 	"github.com/livekit/protocol/livekit"
 )
 
+const (
+	defaultLivePlaylistWindow = 5
+
+	SegmentEncryptionAES128 = "AES-128"
+
+	PlaylistUploadAlways   = "always"
+	PlaylistUploadSegments = "segments"
+	PlaylistUploadInterval = "interval"
+)
+
 type SegmentConfig struct {
 	outputConfig
 
@@ -37,9 +48,41 @@ type SegmentConfig struct {
 	SegmentPrefix        string
 	SegmentSuffix        livekit.SegmentedFileSuffix
 	SegmentDuration      int
+	SegmentOutputType    types.OutputType // video/mp2t, or video/iso.segment for cmaf and dash
+	InitSegmentFilename  string           // cmaf and dash only, relative to LocalDir and StorageDir
+	PartDuration         float64          // low-latency hls only, in seconds. Parts are listed in the live playlist
+	PartServerSocket     string           // low-latency hls with local storage only, serves blocking playlist reloads to the service
+
+	LiveWindowSegments    int           // segments listed in the live playlist, 0 for no limit
+	LiveWindowDuration    time.Duration // total duration listed in the live playlist, 0 for no limit
+	DeleteExpiredSegments bool          // live playlist only outputs, where PlaylistFilename is empty
+
+	PlaylistUpload *PlaylistUploadConfig // full playlist upload policy, nil for the default
+
+	Encryption  *SegmentEncryptionConfig // hls only, segments are encrypted before upload
+	ID3Metadata bool                     // mpeg-ts only, timed metadata is also written into segments
+
+	// resumed outputs append to an existing event playlist, and continue its segment numbering
+	Resume            bool
+	FirstSegmentIndex int // set by the sink once the existing playlist has been read
+
+	// multi-rendition outputs write a master playlist to PlaylistFilename, and
+	// each rendition writes its own segments and media playlists
+	Rendition  *RenditionConfig // set on rendition configs
+	Renditions []*SegmentConfig
 
 	DisableManifest bool
 	StorageConfig   *StorageConfig
+	MirrorConfigs   []*StorageConfig
+	MirrorInfos     []*livekit.SegmentsInfo // one per mirror, reported alongside SegmentsInfo
+}
+
+// RenditionConfig is one step of an adaptive bitrate ladder
+type RenditionConfig struct {
+	Name         string `yaml:"name"`          // appended to playlist and segment names, e.g. 720p
+	Width        int32  `yaml:"width"`         // video width
+	Height       int32  `yaml:"height"`        // video height
+	VideoBitrate int32  `yaml:"video_bitrate"` // video bitrate, in kbps
 }
 
 func (p *PipelineConfig) GetSegmentConfig() *SegmentConfig {
@@ -78,14 +121,312 @@ func (p *PipelineConfig) getSegmentConfig(segments *livekit.SegmentedFileOutput)
 		conf.OutputType = types.OutputTypeHLS
 	}
 
+	// the request protocol has no cmaf option, so segment format is set by the service config
+	switch p.SegmentProtocol {
+	case "", types.SegmentProtocolHLS:
+		conf.SegmentOutputType = types.OutputTypeTS
+	case types.SegmentProtocolCMAF:
+		conf.SegmentOutputType = types.OutputTypeM4S
+	case types.SegmentProtocolDASH:
+		conf.OutputType = types.OutputTypeDASH
+		conf.SegmentOutputType = types.OutputTypeM4S
+	default:
+		return nil, errors.ErrInvalidInput("segment_protocol")
+	}
+
 	// filename
 	if err = conf.updatePrefixAndPlaylist(p); err != nil {
 		return nil, err
 	}
 
+	if err = conf.updateLiveWindow(p.LivePlaylistWindow, segments.PlaylistName != ""); err != nil {
+		return nil, err
+	}
+
+	// parts are only listed in live playlists
+	if p.LowLatencyHLS != nil && conf.LivePlaylistFilename != "" {
+		if err = conf.updateLowLatency(p.LowLatencyHLS, p.Info.EgressId); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.SegmentEncryption != nil {
+		if err = conf.updateEncryption(p.SegmentEncryption); err != nil {
+			return nil, err
+		}
+	}
+
+	// the existing playlist is read back from storage
+	if p.ResumeSegments && conf.PlaylistFilename != "" {
+		if err = conf.updateResume(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.PlaylistUpload != nil {
+		if err = conf.updatePlaylistUpload(p.PlaylistUpload); err != nil {
+			return nil, err
+		}
+	}
+
+	// markers are always listed in hls playlists, and can also be written into segments
+	if p.TimedMetadata != nil && p.TimedMetadata.ID3 {
+		if err = conf.updateID3(); err != nil {
+			return nil, err
+		}
+	}
+
+	// renditions are only encoded for video
+	if p.VideoEnabled && len(p.SegmentRenditions) > 0 {
+		if err = conf.updateRenditions(p.SegmentRenditions); err != nil {
+			return nil, err
+		}
+	}
+
 	return conf, nil
 }
 
+func (o *SegmentConfig) updateLiveWindow(w *LiveWindowConfig, playlistRequested bool) error {
+	o.LiveWindowSegments = defaultLivePlaylistWindow
+	if w == nil {
+		return nil
+	}
+	if w.Segments < 0 || w.Duration < 0 {
+		return errors.ErrInvalidInput("live_playlist_window")
+	}
+
+	switch {
+	case w.Duration > 0:
+		o.LiveWindowSegments = 0
+		o.LiveWindowDuration = w.Duration
+	case w.Segments > 0:
+		o.LiveWindowSegments = w.Segments
+	}
+
+	// segments are only kept while listed, so this only applies to outputs which did not request a full playlist
+	if w.DeleteExpired && !playlistRequested && o.LivePlaylistFilename != "" {
+		o.DeleteExpiredSegments = true
+		o.PlaylistFilename = ""
+		o.SegmentsInfo.PlaylistName = ""
+	}
+
+	return nil
+}
+
+func (o *SegmentConfig) updateLowLatency(ll *LowLatencyHLSConfig, egressID string) error {
+	if o.OutputType != types.OutputTypeHLS {
+		return errors.ErrNotSupported("low_latency_hls with dash")
+	}
+
+	o.PartDuration = ll.PartDuration
+	if o.PartDuration == 0 {
+		o.PartDuration = 1
+	}
+	if o.PartDuration < 0 || o.PartDuration >= float64(o.SegmentDuration) {
+		return errors.ErrInvalidInput("part_duration")
+	}
+
+	// blocking reload can only be served for files stored on this instance.
+	// The service listens on the port, and forwards requests to each handler by egress id.
+	if ll.Port > 0 && (o.StorageConfig == nil || o.StorageConfig.IsLocal()) {
+		o.PartServerSocket = PartServerSocket(egressID)
+	}
+
+	return nil
+}
+
+func (o *SegmentConfig) updateEncryption(e *SegmentEncryptionConfig) error {
+	if o.OutputType != types.OutputTypeHLS {
+		return errors.ErrNotSupported("segment_encryption with dash")
+	}
+	if o.PartDuration > 0 {
+		// parts would each need their own iv
+		return errors.ErrNotSupported("segment_encryption with low_latency_hls")
+	}
+
+	conf := *e
+	switch conf.Method {
+	case "":
+		conf.Method = SegmentEncryptionAES128
+	case SegmentEncryptionAES128:
+	case "SAMPLE-AES":
+		return errors.ErrNotSupported("SAMPLE-AES segment encryption")
+	default:
+		return errors.ErrInvalidInput("segment_encryption method")
+	}
+
+	switch {
+	case conf.Key != "" && conf.KeyServerUrl == "":
+		if key, err := base64.StdEncoding.DecodeString(conf.Key); err != nil || len(key) != 16 {
+			return errors.ErrInvalidInput("segment_encryption key")
+		}
+		if conf.KeyURI == "" {
+			return errors.ErrInvalidInput("key_uri required with a static key")
+		}
+		if conf.RotationSegments != 0 {
+			return errors.ErrInvalidInput("rotation_segments requires a key server")
+		}
+	case conf.KeyServerUrl != "" && conf.Key == "":
+		if conf.RotationSegments < 0 {
+			return errors.ErrInvalidInput("rotation_segments")
+		}
+	default:
+		return errors.ErrInvalidInput("exactly one of key or key_server_url is required")
+	}
+
+	o.Encryption = &conf
+	return nil
+}
+
+func (o *SegmentConfig) updateResume() error {
+	switch {
+	case o.OutputType != types.OutputTypeHLS:
+		return errors.ErrNotSupported("resume_segments with dash")
+	case o.SegmentOutputType != types.OutputTypeTS:
+		// a new init segment would replace the one used by earlier segments
+		return errors.ErrNotSupported("resume_segments with cmaf")
+	case o.PartDuration > 0:
+		return errors.ErrNotSupported("resume_segments with low_latency_hls")
+	case o.StorageConfig != nil && o.StorageConfig.Encryption != nil:
+		return errors.ErrNotSupported("resume_segments with storage encryption")
+	}
+
+	o.Resume = true
+	return nil
+}
+
+func (o *SegmentConfig) updatePlaylistUpload(u *PlaylistUploadConfig) error {
+	switch u.Policy {
+	case "":
+		return nil
+	case PlaylistUploadAlways:
+	case PlaylistUploadSegments:
+		if u.Segments < 1 {
+			return errors.ErrInvalidInput("playlist_upload segments")
+		}
+	case PlaylistUploadInterval:
+		if u.Interval <= 0 {
+			return errors.ErrInvalidInput("playlist_upload interval")
+		}
+	default:
+		return errors.ErrInvalidInput("playlist_upload policy")
+	}
+
+	conf := *u
+	o.PlaylistUpload = &conf
+	return nil
+}
+
+func (o *SegmentConfig) updateID3() error {
+	switch {
+	case o.OutputType != types.OutputTypeHLS:
+		return errors.ErrNotSupported("id3 timed metadata with dash")
+	case o.SegmentOutputType != types.OutputTypeTS:
+		return errors.ErrNotSupported("id3 timed metadata with cmaf")
+	}
+
+	o.ID3Metadata = true
+	return nil
+}
+
+// SegmentFilename returns the name of a segment, using its index or start time depending on the suffix
+func (o *SegmentConfig) SegmentFilename(index int, startTime time.Time) string {
+	ext := types.FileExtensionForOutputType[o.SegmentOutputType]
+	switch o.SegmentSuffix {
+	case livekit.SegmentedFileSuffix_TIMESTAMP:
+		return fmt.Sprintf("%s_%s%03d%s", o.SegmentPrefix, startTime.Format("20060102150405"), startTime.UnixMilli()%1000, ext)
+	default:
+		return fmt.Sprintf("%s_%05d%s", o.SegmentPrefix, index, ext)
+	}
+}
+
+// PartFilename returns the name of a low-latency part, which is concatenated into a segment once closed
+func (o *SegmentConfig) PartFilename(index int) string {
+	return fmt.Sprintf("%s_part%05d%s", o.SegmentPrefix, index, types.FileExtensionForOutputType[o.SegmentOutputType])
+}
+
+// updateFromSDK applies filename replacements which are only known once the sdk source has started.
+// Renditions were copied from the output, so they need to be updated too.
+func (o *SegmentConfig) updateFromSDK(replacements map[string]string) {
+	o.LocalDir = stringReplace(o.LocalDir, replacements)
+	o.StorageDir = stringReplace(o.StorageDir, replacements)
+	o.PlaylistFilename = stringReplace(o.PlaylistFilename, replacements)
+	o.LivePlaylistFilename = stringReplace(o.LivePlaylistFilename, replacements)
+	o.SegmentPrefix = stringReplace(o.SegmentPrefix, replacements)
+	o.updateInitSegmentFilename()
+	o.SegmentsInfo.PlaylistName = stringReplace(o.SegmentsInfo.PlaylistName, replacements)
+	o.SegmentsInfo.LivePlaylistName = stringReplace(o.SegmentsInfo.LivePlaylistName, replacements)
+
+	for _, r := range o.Renditions {
+		r.updateFromSDK(replacements)
+	}
+}
+
+// updateInitSegmentFilename names the init segment after the segment prefix, and needs to be called again if it changes
+func (o *SegmentConfig) updateInitSegmentFilename() {
+	if o.SegmentOutputType == types.OutputTypeM4S {
+		o.InitSegmentFilename = fmt.Sprintf("%s_init%s", o.SegmentPrefix, types.FileExtensionMP4)
+	}
+}
+
+func (o *SegmentConfig) updateRenditions(renditions []*RenditionConfig) error {
+	if o.OutputType != types.OutputTypeHLS {
+		return errors.ErrNotSupported("segment_renditions with dash")
+	}
+
+	names := make(map[string]bool)
+	for _, r := range renditions {
+		if r.Name == "" || r.Width <= 0 || r.Height <= 0 || r.VideoBitrate <= 0 || names[r.Name] {
+			return errors.ErrInvalidInput("segment_renditions")
+		}
+		names[r.Name] = true
+
+		ext := string(types.FileExtensionForOutputType[o.OutputType])
+		rc := *o
+		rc.Rendition = r
+		rc.Renditions = nil
+		rc.SegmentsInfo = &livekit.SegmentsInfo{}
+		rc.SegmentPrefix = fmt.Sprintf("%s_%s", o.SegmentPrefix, r.Name)
+		if o.PlaylistFilename != "" {
+			rc.PlaylistFilename = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(o.PlaylistFilename, ext), r.Name, ext)
+			rc.SegmentsInfo.PlaylistName = path.Join(rc.StorageDir, rc.PlaylistFilename)
+		}
+		if o.LivePlaylistFilename != "" {
+			rc.LivePlaylistFilename = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(o.LivePlaylistFilename, ext), r.Name, ext)
+			rc.SegmentsInfo.LivePlaylistName = path.Join(rc.StorageDir, rc.LivePlaylistFilename)
+		}
+		rc.updateInitSegmentFilename()
+		o.Renditions = append(o.Renditions, &rc)
+	}
+
+	return nil
+}
+
+// H264Level returns the lowest h264 level_idc (31 for level 3.1) supporting the given resolution and framerate
+func H264Level(width, height, framerate int32) int {
+	frameSize := int64((width+15)/16) * int64((height+15)/16)
+	rate := frameSize * int64(framerate)
+
+	for _, l := range []struct {
+		idc          int
+		maxFrameSize int64 // macroblocks
+		maxRate      int64 // macroblocks per second
+	}{
+		{30, 1620, 40500},
+		{31, 3600, 108000},
+		{32, 5120, 216000},
+		{40, 8192, 245760},
+		{42, 8704, 522240},
+		{50, 22080, 589824},
+		{51, 36864, 983040},
+	} {
+		if frameSize <= l.maxFrameSize && rate <= l.maxRate {
+			return l.idc
+		}
+	}
+	return 52
+}
+
 func removeKnownExtension(filename string) string {
 	if extIdx := strings.LastIndex(filename, "."); extIdx > -1 {
 		existingExt := types.FileExtension(filename[extIdx:])
@@ -153,6 +494,7 @@ func (o *SegmentConfig) updatePrefixAndPlaylist(p *PipelineConfig) error {
 		o.LivePlaylistFilename = fmt.Sprintf("%s%s", livePlaylistName, ext)
 	}
 	o.SegmentPrefix = fmt.Sprintf("%s%s", segmentDir, segmentPrefix)
+	o.updateInitSegmentFilename()
 
 	if o.PlaylistFilename == o.LivePlaylistFilename {
 		return errors.ErrInvalidInput("live_playlist_name cannot be identical to playlist_name")
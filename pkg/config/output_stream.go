@@ -16,18 +16,38 @@ package config
 
 import (
 	"sync"
+	"time"
 
+	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 )
 
+const (
+	defaultReconnectMaxBackoff  = time.Second * 10
+	defaultReconnectWindow      = time.Second * 30
+	defaultStreamHealthInterval = time.Second * 10
+	defaultRTSPListenHost       = "0.0.0.0"
+	defaultRTSPPort             = 554
+	defaultRTSPSessionTimeout   = time.Second * 60
+)
+
 type StreamConfig struct {
 	outputConfig
 
 	// url -> Stream
 	Streams sync.Map
 
+	// hls and dash only
+	Ingest *IngestConfig
+
+	// rtmp and srt only
+	Reconnect *StreamReconnectConfig
+
+	// rtsp only, nil unless server mode is enabled
+	RTSPServer *RTSPServerConfig
+
 	twitchTemplate string
 }
 
@@ -36,9 +56,18 @@ type Stream struct {
 	ParsedUrl   string // parsed/validated url
 	RedactedUrl string // url with stream key removed
 	StreamID    string // stream ID used by rtmpconnection
+	Listen      bool   // rtsp only, served by the egress for players to pull instead of pushed
+	SRT         *SRTOptions
 	StreamInfo  *livekit.StreamInfo
 }
 
+// SRTOptions are parsed from srt url query parameters. Latency, passphrase and pbkeylen are validated,
+// but left in the url for srtsink, so that the passphrase is not kept.
+type SRTOptions struct {
+	Mode     string // caller (default), listener, or rendezvous
+	StreamID string // caller only
+}
+
 func (p *PipelineConfig) GetStreamConfig() *StreamConfig {
 	o, ok := p.Outputs[types.EgressTypeStream]
 	if !ok || len(o) == 0 {
@@ -59,6 +88,13 @@ func (p *PipelineConfig) getStreamConfig(outputType types.OutputType, urls []str
 	conf := &StreamConfig{
 		outputConfig: outputConfig{OutputType: outputType},
 	}
+	if outputType == types.OutputTypeRTSP {
+		// required to validate server mode urls
+		var err error
+		if conf.RTSPServer, err = p.getRTSPServerConfig(); err != nil {
+			return nil, err
+		}
+	}
 
 	for _, rawUrl := range urls {
 		_, err := conf.AddStream(rawUrl, outputType)
@@ -72,10 +108,37 @@ func (p *PipelineConfig) getStreamConfig(outputType types.OutputType, urls []str
 		p.AudioOutCodec = types.MimeTypeAAC
 		p.VideoOutCodec = types.MimeTypeH264
 
+		var err error
+		if conf.Reconnect, err = p.getReconnectConfig(); err != nil {
+			return nil, err
+		}
+
 	case types.OutputTypeSRT:
 		p.AudioOutCodec = types.MimeTypeAAC
 		p.VideoOutCodec = types.MimeTypeH264
 
+		var err error
+		if conf.Reconnect, err = p.getReconnectConfig(); err != nil {
+			return nil, err
+		}
+
+	case types.OutputTypeRTSP:
+		p.AudioOutCodec = types.MimeTypeAAC
+		p.VideoOutCodec = types.MimeTypeH264
+
+	case types.OutputTypeWHIP:
+		p.AudioOutCodec = types.MimeTypeOpus
+		p.VideoOutCodec = types.MimeTypeH264
+
+	case types.OutputTypeHLS, types.OutputTypeDASH:
+		p.AudioOutCodec = types.MimeTypeAAC
+		p.VideoOutCodec = types.MimeTypeH264
+
+		var err error
+		if conf.Ingest, err = p.getIngestConfig(outputType); err != nil {
+			return nil, err
+		}
+
 	case types.OutputTypeRaw:
 		p.AudioOutCodec = types.MimeTypeRawAudio
 	}
@@ -94,3 +157,54 @@ func (s *Stream) UpdateEndTime(endedAt int64) {
 		s.StreamInfo.Duration = endedAt - s.StreamInfo.StartedAt
 	}
 }
+
+// getReconnectConfig applies defaults to the reconnect policy. Without one, streams get 30s to reconnect.
+func (p *PipelineConfig) getReconnectConfig() (*StreamReconnectConfig, error) {
+	var conf StreamReconnectConfig
+	if p.StreamReconnect != nil {
+		conf = *p.StreamReconnect
+	}
+
+	if conf.MaxAttempts < 0 || conf.Backoff < 0 || conf.MaxBackoff < 0 || conf.Window < 0 || conf.HealthInterval < 0 {
+		return nil, errors.ErrInvalidInput("stream_reconnect")
+	}
+	if conf.MaxBackoff == 0 {
+		conf.MaxBackoff = defaultReconnectMaxBackoff
+	}
+	if conf.Window == 0 {
+		conf.Window = defaultReconnectWindow
+	}
+	if conf.HealthInterval == 0 {
+		conf.HealthInterval = defaultStreamHealthInterval
+	}
+
+	return &conf, nil
+}
+
+func (p *PipelineConfig) getRTSPServerConfig() (*RTSPServerConfig, error) {
+	if p.RTSPServer == nil {
+		return nil, nil
+	}
+	conf := *p.RTSPServer
+
+	if conf.PortRangeStart <= 0 || conf.PortRangeEnd < conf.PortRangeStart || conf.PortRangeEnd > 65535 || conf.SessionTimeout < 0 {
+		return nil, errors.ErrInvalidInput("rtsp_server")
+	}
+	if len(conf.ListenHosts) == 0 {
+		conf.ListenHosts = []string{defaultRTSPListenHost}
+	}
+	if conf.SessionTimeout == 0 {
+		conf.SessionTimeout = defaultRTSPSessionTimeout
+	}
+
+	return &conf, nil
+}
+
+// ReconnectDelay returns the delay before a reconnect attempt, starting at 0
+func (c *StreamReconnectConfig) ReconnectDelay(attempt int) time.Duration {
+	delay := c.Backoff
+	for i := 0; i < attempt && delay < c.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, c.MaxBackoff)
+}
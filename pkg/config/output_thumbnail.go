@@ -0,0 +1,124 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/types"
+)
+
+// ThumbnailConfig captures frames from a recording into sprite sheets, stored next to the recording
+// along with a WebVTT track pointing into them
+type ThumbnailConfig struct {
+	outputConfig
+
+	LocalDir      string
+	StorageDir    string
+	Prefix        string // sheets are named <prefix>_<index>.jpeg
+	TrackFilename string
+
+	StorageConfig *StorageConfig
+	MirrorConfigs []*StorageConfig
+
+	Interval uint32 // seconds
+	Width    int32
+	Height   int32
+	Columns  int
+	Rows     int
+}
+
+func (p *PipelineConfig) GetThumbnailConfig() *ThumbnailConfig {
+	o, ok := p.Outputs[types.EgressTypeThumbnails]
+	if !ok || len(o) == 0 {
+		return nil
+	}
+	return o[0].(*ThumbnailConfig)
+}
+
+// getThumbnailConfig is called once file and segment outputs have been added, since thumbnails are named after them
+func (p *PipelineConfig) getThumbnailConfig() (*ThumbnailConfig, error) {
+	t := p.Thumbnails
+	if t.Width < 0 || t.Height < 0 || t.Columns < 0 || t.Rows < 0 {
+		return nil, errors.ErrInvalidInput("thumbnails")
+	}
+
+	conf := &ThumbnailConfig{
+		outputConfig: outputConfig{OutputType: types.OutputTypeJPEG},
+		LocalDir:     path.Join(p.TmpDir, "thumbnails"),
+		Interval:     t.Interval,
+		Width:        t.Width,
+		Height:       t.Height,
+		Columns:      t.Columns,
+		Rows:         t.Rows,
+	}
+	if conf.Interval == 0 {
+		conf.Interval = 5
+	}
+	if conf.Width == 0 {
+		conf.Width = 160
+	}
+	if conf.Height == 0 {
+		conf.Height = 90
+	}
+	if conf.Columns == 0 {
+		conf.Columns = 5
+	}
+	if conf.Rows == 0 {
+		conf.Rows = 5
+	}
+
+	// thumbnails follow the storage settings of their recording, preferring segments.
+	// The track is stored next to the recording, and also listed in the manifest when there is one.
+	if o := p.GetSegmentConfig(); o != nil {
+		conf.StorageConfig = o.StorageConfig
+		conf.MirrorConfigs = o.MirrorConfigs
+	} else if o := p.GetFileConfig(); o != nil {
+		conf.StorageConfig = o.StorageConfig
+		conf.MirrorConfigs = o.MirrorConfigs
+	} else {
+		return nil, errors.ErrInvalidInput("thumbnails without a file or segment output")
+	}
+	conf.updatePrefix(p)
+
+	if err := os.MkdirAll(conf.LocalDir, 0755); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// updatePrefix names thumbnails after the recording, and needs to be called again if its filename changes
+func (o *ThumbnailConfig) updatePrefix(p *PipelineConfig) {
+	var recording string
+	if sc := p.GetSegmentConfig(); sc != nil {
+		playlist := sc.PlaylistFilename
+		if playlist == "" {
+			playlist = sc.LivePlaylistFilename
+		}
+		recording = path.Join(sc.StorageDir, playlist)
+	} else if fc := p.GetFileConfig(); fc != nil {
+		recording = fc.StorageFilepath
+	}
+
+	dir, filename := path.Split(recording)
+	o.StorageDir = dir
+	o.Prefix = fmt.Sprintf("%s_thumbnails", strings.TrimSuffix(filename, path.Ext(filename)))
+	o.TrackFilename = o.Prefix + types.FileExtensionVTT
+}
@@ -589,17 +589,12 @@ func (p *PipelineConfig) UpdateInfoFromSDK(identifier string, replacements map[s
 		}
 		switch egressType {
 		case types.EgressTypeFile:
-			return c[0].(*FileConfig).updateFilepath(p, identifier, replacements)
+			if err := c[0].(*FileConfig).updateFilepath(p, identifier, replacements); err != nil {
+				return err
+			}
 
 		case types.EgressTypeSegments:
-			o := c[0].(*SegmentConfig)
-			o.LocalDir = stringReplace(o.LocalDir, replacements)
-			o.StorageDir = stringReplace(o.StorageDir, replacements)
-			o.PlaylistFilename = stringReplace(o.PlaylistFilename, replacements)
-			o.LivePlaylistFilename = stringReplace(o.LivePlaylistFilename, replacements)
-			o.SegmentPrefix = stringReplace(o.SegmentPrefix, replacements)
-			o.SegmentsInfo.PlaylistName = stringReplace(o.SegmentsInfo.PlaylistName, replacements)
-			o.SegmentsInfo.LivePlaylistName = stringReplace(o.SegmentsInfo.LivePlaylistName, replacements)
+			c[0].(*SegmentConfig).updateFromSDK(replacements)
 
 		case types.EgressTypeImages:
 			for _, ci := range c {
@@ -626,6 +621,11 @@ func (p *PipelineConfig) UpdateInfoFromSDK(identifier string, replacements map[s
 		}
 	}
 
+	// thumbnails are named after the updated recording
+	if o := p.GetThumbnailConfig(); o != nil {
+		o.updatePrefix(p)
+	}
+
 	return nil
 }
 
@@ -639,6 +639,24 @@ func (p *PipelineConfig) GetEncodedOutputs() []OutputConfig {
 	return ret
 }
 
+// GetEncodedSinkCount returns the number of sink bins receiving encoded audio. Multi-rendition segment outputs use one per rendition.
+func (p *PipelineConfig) GetEncodedSinkCount() int {
+	count := len(p.GetEncodedOutputs())
+	if o := p.GetSegmentConfig(); o != nil && len(o.Renditions) > 0 {
+		count += len(o.Renditions) - 1
+	}
+	return count
+}
+
+// GetEncodedVideoSinkCount returns the number of sink bins receiving encoded video. Renditions are fed raw video instead.
+func (p *PipelineConfig) GetEncodedVideoSinkCount() int {
+	count := len(p.GetEncodedOutputs())
+	if o := p.GetSegmentConfig(); o != nil && len(o.Renditions) > 0 {
+		count--
+	}
+	return count
+}
+
 func stringReplace(s string, replacements map[string]string) string {
 	for template, value := range replacements {
 		s = strings.Replace(s, template, value, -1)
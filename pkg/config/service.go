@@ -52,6 +52,8 @@ type ServiceConfig struct {
 	TemplatePort     int `yaml:"template_port"`      // room composite template server port
 	PrometheusPort   int `yaml:"prometheus_port"`    // prometheus handler port
 	DebugHandlerPort int `yaml:"debug_handler_port"` // egress debug handler port
+	PresignPort      int `yaml:"presign_port"`       // handler which re-signs storage urls
+	MetadataPort     int `yaml:"metadata_port"`      // handler which inserts timed metadata into segmented outputs
 
 	*CPUCostConfig `yaml:"cpu_cost"` // CPU costs for the different egress types
 }
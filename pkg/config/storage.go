@@ -22,18 +22,69 @@ import (
 )
 
 type StorageConfig struct {
-	PathPrefix           string `yaml:"prefix"` // prefix applied to all filenames
-	GeneratePresignedUrl bool   `yaml:"generate_presigned_url"`
+	PathPrefix           string        `yaml:"prefix"` // prefix applied to all filenames
+	GeneratePresignedUrl bool          `yaml:"generate_presigned_url"`
+	PresignedUrlExpiry   time.Duration `yaml:"presigned_url_expiry"` // lifetime of presigned urls (default 15m)
 
 	S3     *S3Config     `yaml:"s3"`     // upload to s3
 	Azure  *AzureConfig  `yaml:"azure"`  // upload to azure
 	GCP    *GCPConfig    `yaml:"gcp"`    // upload to gcp
 	AliOSS *S3Config     `yaml:"alioss"` // upload to aliyun
-	Graham *GrahamConfig `yaml:"graham"`
+	Graham *GrahamConfig `yaml:"graham"` // upload to siloo, using graham for credentials
+	WebDAV *WebDAVConfig `yaml:"webdav"` // upload to a webdav server
+	SFTP   *SFTPConfig   `yaml:"sftp"`   // upload to an sftp server
+
+	Encryption *EncryptionConfig `yaml:"encryption"` // encrypt files before uploading
+	Failover   *FailoverConfig   `yaml:"failover"`   // backup only, controls when the primary is bypassed
+}
+
+// FailoverConfig sets the circuit breaker used to route uploads to backup storage.
+// A file whose primary upload fails is always retried on the backup. Once the primary's failure rate
+// within the window reaches the threshold, it is bypassed until the cooldown has passed, after which
+// a single upload probes it again.
+type FailoverConfig struct {
+	Window      time.Duration `yaml:"window"`       // period over which primary failures are counted (default 1m)
+	MinUploads  int           `yaml:"min_uploads"`  // primary uploads within the window required to trip the breaker (default 3)
+	FailureRate float64       `yaml:"failure_rate"` // fraction of failed primary uploads which trips the breaker (default 0.5)
+	Cooldown    time.Duration `yaml:"cooldown"`     // time before the primary is probed again (default 30s)
+}
+
+// EncryptionConfig sets the key used to wrap each uploader's data key. Exactly one key source is required.
+type EncryptionConfig struct {
+	Key     string `yaml:"key"`      // base64 encoded AES key
+	KeyFile string `yaml:"key_file"` // path to a file containing a base64 encoded AES key
+	KMSUrl  string `yaml:"kms_url"`  // endpoint which encrypts data keys
+	KeyID   string `yaml:"key_id"`   // sent to the kms, and recorded in the manifest to identify the key
 }
 
 type GrahamConfig struct {
-	Address string `yaml:"address"`
+	Address string `yaml:"address"` // graham endpoint used to request upload urls
+
+	MaxAttempts   int           `yaml:"max_attempts"`    // attempts per request, including the first (default 5)
+	MinRetryDelay time.Duration `yaml:"min_retry_delay"` // backoff after the first failure, doubled for each retry (default 100ms)
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay"` // maximum backoff between attempts (default 5s)
+	UploadTimeout time.Duration `yaml:"upload_timeout"`  // deadline for a single file upload, including retries (default none)
+
+	PartConcurrency int `yaml:"part_concurrency"` // parts sent in parallel when graham returns part urls (default 4)
+
+	PresignAddress string `yaml:"presign_address"` // graham endpoint used to request temporary read urls
+}
+
+type WebDAVConfig struct {
+	Url      string `yaml:"url"`      // collection files are uploaded under, e.g. https://dav.example.com/recordings
+	Username string `yaml:"username"` // basic auth
+	Password string `yaml:"password"` // basic auth
+	Token    string `yaml:"token"`    // bearer auth, instead of username and password
+}
+
+type SFTPConfig struct {
+	Address               string `yaml:"address"` // host:port (default port 22)
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`                 // password auth
+	PrivateKey            string `yaml:"private_key"`              // PEM encoded private key, instead of a password
+	PrivateKeyPassphrase  string `yaml:"private_key_passphrase"`   // for encrypted private keys
+	HostKey               string `yaml:"host_key"`                 // expected host key, in authorized_keys format
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"` // skip host key verification when no host key is set
 }
 
 type S3Config struct {
@@ -68,15 +119,12 @@ type GCPConfig struct {
 }
 
 func (p *PipelineConfig) getStorageConfig(req egress.UploadRequest) (*StorageConfig, error) {
-	if p.BaseConfig.StorageConfig.Graham != nil {
-		return &StorageConfig{
-			Graham: p.BaseConfig.StorageConfig.Graham,
-		}, nil
-	}
 	sc := &StorageConfig{}
 	if p.StorageConfig != nil {
 		sc.PathPrefix = p.StorageConfig.PathPrefix
 		sc.GeneratePresignedUrl = p.StorageConfig.GeneratePresignedUrl
+		sc.PresignedUrlExpiry = p.StorageConfig.PresignedUrlExpiry
+		sc.Encryption = p.StorageConfig.Encryption
 	}
 
 	if s3 := req.GetS3(); s3 != nil {
@@ -160,5 +208,6 @@ func (p *PipelineConfig) getStorageConfig(req egress.UploadRequest) (*StorageCon
 }
 
 func (c *StorageConfig) IsLocal() bool {
-	return c.S3 == nil && c.GCP == nil && c.Azure == nil && c.AliOSS == nil
+	return c.S3 == nil && c.GCP == nil && c.Azure == nil && c.AliOSS == nil && c.Graham == nil &&
+		c.WebDAV == nil && c.SFTP == nil
 }
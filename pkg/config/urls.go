@@ -19,6 +19,8 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,7 +39,7 @@ var (
 )
 
 func (o *StreamConfig) AddStream(rawUrl string, outputType types.OutputType) (*Stream, error) {
-	parsed, redacted, streamID, err := o.ValidateUrl(rawUrl, outputType)
+	parsed, redacted, streamID, srt, err := o.validateUrl(rawUrl, outputType)
 	if err != nil {
 		return nil, err
 	}
@@ -46,14 +48,21 @@ func (o *StreamConfig) AddStream(rawUrl string, outputType types.OutputType) (*S
 		ParsedUrl:   parsed,
 		RedactedUrl: redacted,
 		StreamID:    streamID,
+		SRT:         srt,
 		StreamInfo: &livekit.StreamInfo{
 			Url:    redacted,
 			Status: livekit.StreamInfo_ACTIVE,
 		},
 	}
-	if outputType != types.OutputTypeRTMP {
+	switch outputType {
+	case types.OutputTypeRTMP, types.OutputTypeWHIP, types.OutputTypeHLS, types.OutputTypeDASH:
+		// started once connected
+	default:
 		stream.StreamInfo.StartedAt = time.Now().UnixNano()
 	}
+	if outputType == types.OutputTypeRTSP {
+		stream.Listen = isRTSPServerUrl(parsed)
+	}
 	o.Streams.Store(parsed, stream)
 
 	return stream, nil
@@ -61,6 +70,14 @@ func (o *StreamConfig) AddStream(rawUrl string, outputType types.OutputType) (*S
 
 func (o *StreamConfig) ValidateUrl(rawUrl string, outputType types.OutputType) (
 	parsed string, redacted string, streamID string, err error,
+) {
+	parsed, redacted, streamID, _, err = o.validateUrl(rawUrl, outputType)
+	return
+}
+
+// validateUrl also returns the options parsed from srt urls
+func (o *StreamConfig) validateUrl(rawUrl string, outputType types.OutputType) (
+	parsed string, redacted string, streamID string, srt *SRTOptions, err error,
 ) {
 	parsedUrl, err := url.Parse(rawUrl)
 	if err != nil {
@@ -97,8 +114,51 @@ func (o *StreamConfig) ValidateUrl(rawUrl string, outputType types.OutputType) (
 		return
 
 	case types.OutputTypeSRT:
+		if srt, err = parseSRTOptions(parsedUrl); err != nil {
+			err = errors.ErrInvalidUrl(rawUrl, err.Error())
+			return
+		}
 		parsed = rawUrl
-		redacted = rawUrl
+		redacted, streamID, _ = redactStreamKey(rawUrl)
+		return
+
+	case types.OutputTypeWHIP:
+		if parsedUrl.Host == "" {
+			err = errors.ErrInvalidUrl(rawUrl, "whip urls must be of format whip(s)://({bearer_token}@){host}/{path}")
+			return
+		}
+		parsed = rawUrl
+		redacted = redactBearerToken(parsedUrl, rawUrl)
+		return
+
+	case types.OutputTypeRTSP:
+		if parsedUrl.Host == "" {
+			err = errors.ErrInvalidUrl(rawUrl, "rtsp urls must be of format rtsp(s)://({username}:{password}@){host}(:{port})/{path}")
+			return
+		}
+		if isRTSPServerUrl(rawUrl) {
+			if err = o.validateRTSPServerUrl(parsedUrl); err != nil {
+				err = errors.ErrInvalidUrl(redactPassword(parsedUrl, rawUrl), err.Error())
+				return
+			}
+		}
+		parsed = rawUrl
+		redacted = redactPassword(parsedUrl, rawUrl)
+		return
+
+	case types.OutputTypeHLS, types.OutputTypeDASH:
+		if parsedUrl.Host == "" {
+			err = errors.ErrInvalidUrl(rawUrl, "ingest urls must be of format {hls|dash}+http(s)://({credentials}@){host}/{path}")
+			return
+		}
+		parsed = rawUrl
+		// user info is either basic auth credentials or a bearer token
+		if _, ok := parsedUrl.User.Password(); ok {
+			redacted = redactPassword(parsedUrl, rawUrl)
+		} else {
+			redacted = redactBearerToken(parsedUrl, rawUrl)
+		}
+		redacted = redactQuery(parsedUrl, redacted)
 		return
 
 	case types.OutputTypeRaw:
@@ -187,6 +247,10 @@ func (o *StreamConfig) updateTwitchTemplate() error {
 }
 
 func redactStreamKey(url string) (string, string, bool) {
+	if strings.HasPrefix(url, "srt://") {
+		return redactSRTSecrets(url)
+	}
+
 	match := rtmpRegexp.FindStringSubmatch(url)
 	if len(match) != 6 {
 		return url, "", false
@@ -196,3 +260,160 @@ func redactStreamKey(url string) (string, string, bool) {
 	match[4] = utils.RedactIdentifier(match[4])
 	return strings.Join(match[1:], ""), streamID, true
 }
+
+const (
+	SRTModeCaller     = "caller"
+	SRTModeListener   = "listener"
+	SRTModeRendezvous = "rendezvous"
+)
+
+// parseSRTOptions validates srt urls of format srt://{host}:{port}(?mode=&latency=&passphrase=&pbkeylen=&streamid=).
+// Other query parameters are left to srtsink.
+func parseSRTOptions(parsedUrl *url.URL) (*SRTOptions, error) {
+	query := parsedUrl.Query()
+	opts := &SRTOptions{
+		Mode:     query.Get("mode"),
+		StreamID: query.Get("streamid"),
+	}
+
+	switch opts.Mode {
+	case "":
+		opts.Mode = SRTModeCaller
+	case SRTModeCaller, SRTModeListener, SRTModeRendezvous:
+	default:
+		return nil, fmt.Errorf("invalid mode %s, must be caller, listener or rendezvous", opts.Mode)
+	}
+
+	if parsedUrl.Port() == "" {
+		return nil, errors.New("srt urls must be of format srt://{host}:{port}")
+	}
+	if parsedUrl.Hostname() == "" && opts.Mode != SRTModeListener {
+		return nil, errors.New("host is required in caller and rendezvous modes")
+	}
+	if opts.StreamID != "" && opts.Mode != SRTModeCaller {
+		return nil, errors.New("streamid is only supported in caller mode")
+	}
+
+	if latency := query.Get("latency"); latency != "" {
+		if l, err := strconv.Atoi(latency); err != nil || l < 0 {
+			return nil, errors.New("latency must be a non-negative number of milliseconds")
+		}
+	}
+
+	passphrase := query.Get("passphrase")
+	if passphrase != "" && (len(passphrase) < 10 || len(passphrase) > 79) {
+		return nil, errors.New("passphrase must be 10 to 79 characters")
+	}
+	if pbKeyLen := query.Get("pbkeylen"); pbKeyLen != "" {
+		switch pbKeyLen {
+		case "16", "24", "32":
+		default:
+			return nil, errors.New("pbkeylen must be 16, 24 or 32")
+		}
+		if passphrase == "" {
+			return nil, errors.New("pbkeylen requires a passphrase")
+		}
+	}
+
+	return opts, nil
+}
+
+// redactSRTSecrets redacts the passphrase and stream id, which often holds the stream key.
+// The stream id is returned.
+func redactSRTSecrets(rawUrl string) (string, string, bool) {
+	base, query, ok := strings.Cut(rawUrl, "?")
+	if !ok {
+		return rawUrl, "", true
+	}
+
+	var streamID string
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		k, v, ok := strings.Cut(param, "=")
+		if !ok || v == "" {
+			continue
+		}
+		switch k {
+		case "streamid":
+			streamID, _ = url.QueryUnescape(v)
+			params[i] = fmt.Sprintf("%s=%s", k, utils.RedactIdentifier(v))
+		case "passphrase":
+			params[i] = fmt.Sprintf("%s=%s", k, utils.RedactIdentifier(v))
+		}
+	}
+	return fmt.Sprintf("%s?%s", base, strings.Join(params, "&")), streamID, true
+}
+
+// validateRTSPServerUrl checks that the listen address is allowed by the rtsp server config
+func (o *StreamConfig) validateRTSPServerUrl(parsedUrl *url.URL) error {
+	if o.RTSPServer == nil {
+		return errors.New("server mode is not enabled")
+	}
+	if parsedUrl.Scheme == "rtsps" {
+		return errors.New("rtsps is not supported in server mode")
+	}
+	if !slices.Contains(o.RTSPServer.ListenHosts, parsedUrl.Hostname()) {
+		return errors.New("listen host is not allowed")
+	}
+	port := defaultRTSPPort
+	if p := parsedUrl.Port(); p != "" {
+		var err error
+		if port, err = strconv.Atoi(p); err != nil {
+			return err
+		}
+	}
+	if port < o.RTSPServer.PortRangeStart || port > o.RTSPServer.PortRangeEnd {
+		return fmt.Errorf("listen port must be between %d and %d", o.RTSPServer.PortRangeStart, o.RTSPServer.PortRangeEnd)
+	}
+	return nil
+}
+
+// isRTSPServerUrl returns true for rtsp urls with mode=server, which are hosted by the egress
+// at the given address and path, instead of being pushed to a remote server
+func isRTSPServerUrl(rawUrl string) bool {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	return parsedUrl.Query().Get("mode") == "server"
+}
+
+// redactPassword redacts the password given as part of the url's user info
+func redactPassword(parsedUrl *url.URL, rawUrl string) string {
+	if parsedUrl.User == nil {
+		return rawUrl
+	}
+	password, ok := parsedUrl.User.Password()
+	if !ok {
+		return rawUrl
+	}
+
+	_, host, _ := strings.Cut(rawUrl, "@")
+	return fmt.Sprintf("%s://%s:%s@%s", parsedUrl.Scheme, parsedUrl.User.Username(), utils.RedactIdentifier(password), host)
+}
+
+// redactQuery redacts query values, which often hold stream keys for ingest endpoints
+func redactQuery(parsedUrl *url.URL, redacted string) string {
+	if parsedUrl.RawQuery == "" {
+		return redacted
+	}
+
+	params := strings.Split(parsedUrl.RawQuery, "&")
+	for i, param := range params {
+		if k, v, ok := strings.Cut(param, "="); ok && v != "" {
+			params[i] = fmt.Sprintf("%s=%s", k, utils.RedactIdentifier(v))
+		}
+	}
+	base, _, _ := strings.Cut(redacted, "?")
+	return fmt.Sprintf("%s?%s", base, strings.Join(params, "&"))
+}
+
+// redactBearerToken redacts the token sent with whip requests, given as the url's user info
+func redactBearerToken(parsedUrl *url.URL, rawUrl string) string {
+	if parsedUrl.User == nil {
+		return rawUrl
+	}
+
+	_, host, _ := strings.Cut(rawUrl, "@")
+	return fmt.Sprintf("%s://%s@%s", parsedUrl.Scheme, utils.RedactIdentifier(parsedUrl.User.Username()), host)
+}
@@ -96,10 +96,15 @@ func ErrChromeFailedToStart(err error) error {
 	return psrpc.NewError(psrpc.Internal, err)
 }
 
+func ErrHandlerExited(recovered, pending int) error {
+	return psrpc.NewErrorf(psrpc.Internal, "handler exited unexpectedly, recovered %d of %d pending uploads", recovered, pending)
+}
+
 // other errors
 
 var (
 	ErrNonStreamingPipeline       = psrpc.NewErrorf(psrpc.InvalidArgument, "UpdateStream called on non-streaming egress")
+	ErrNonSegmentedPipeline       = psrpc.NewErrorf(psrpc.InvalidArgument, "InsertMetadata called on non-segmented egress")
 	ErrNoCompatibleCodec          = psrpc.NewErrorf(psrpc.InvalidArgument, "no supported codec is compatible with all outputs")
 	ErrNoCompatibleFileOutputType = psrpc.NewErrorf(psrpc.InvalidArgument, "no supported file output type is compatible with the selected codecs")
 	ErrEgressNotFound             = psrpc.NewErrorf(psrpc.NotFound, "egress not found")
@@ -133,6 +138,10 @@ func ErrInvalidUrl(url string, reason string) error {
 	return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid url %s: %s", url, reason)
 }
 
+func ErrInvalidStorage(backend string, err error) error {
+	return psrpc.NewErrorf(psrpc.InvalidArgument, "invalid %s storage config: %v", backend, err)
+}
+
 func ErrUploadFailed(location string, err error) error {
 	return psrpc.NewErrorf(psrpc.InvalidArgument, "%s upload failed: %v", location, err)
 }
@@ -38,6 +38,11 @@ type Callbacks struct {
 	onTrackUnmuted []func(string)
 	onTrackRemoved []func(string)
 	onEOSSent      func()
+	onMetadata     func(*config.TimedMetadata)
+
+	// stream callbacks, for outputs which connect outside of gstreamer
+	onStreamStarted func(string)
+	onStreamFailed  func(string, error)
 
 	// internal
 	addBin    func(bin *gst.Bin)
@@ -157,3 +162,51 @@ func (c *Callbacks) OnEOSSent() {
 		onEOSSent()
 	}
 }
+
+func (c *Callbacks) SetOnMetadata(f func(*config.TimedMetadata)) {
+	c.mu.Lock()
+	c.onMetadata = f
+	c.mu.Unlock()
+}
+
+func (c *Callbacks) OnMetadata(m *config.TimedMetadata) {
+	c.mu.RLock()
+	onMetadata := c.onMetadata
+	c.mu.RUnlock()
+
+	if onMetadata != nil {
+		onMetadata(m)
+	}
+}
+
+func (c *Callbacks) SetOnStreamStarted(f func(string)) {
+	c.mu.Lock()
+	c.onStreamStarted = f
+	c.mu.Unlock()
+}
+
+func (c *Callbacks) OnStreamStarted(name string) {
+	c.mu.RLock()
+	onStreamStarted := c.onStreamStarted
+	c.mu.RUnlock()
+
+	if onStreamStarted != nil {
+		onStreamStarted(name)
+	}
+}
+
+func (c *Callbacks) SetOnStreamFailed(f func(string, error)) {
+	c.mu.Lock()
+	c.onStreamFailed = f
+	c.mu.Unlock()
+}
+
+func (c *Callbacks) OnStreamFailed(name string, err error) {
+	c.mu.RLock()
+	onStreamFailed := c.onStreamFailed
+	c.mu.RUnlock()
+
+	if onStreamFailed != nil {
+		onStreamFailed(name, err)
+	}
+}
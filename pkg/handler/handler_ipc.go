@@ -24,7 +24,10 @@ import (
 	"github.com/prometheus/common/expfmt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/ipc"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/pprof"
@@ -69,6 +72,33 @@ func (h *Handler) GetPProf(ctx context.Context, req *ipc.PProfRequest) (*ipc.PPr
 	}, nil
 }
 
+func (h *Handler) InsertMetadata(ctx context.Context, req *ipc.InsertMetadataRequest) (*emptypb.Empty, error) {
+	ctx, span := tracer.Start(ctx, "Handler.InsertMetadata")
+	defer span.End()
+
+	<-h.initialized.Watch()
+	if h.controller == nil {
+		return nil, errors.ErrEgressNotFound
+	}
+
+	m := &config.TimedMetadata{
+		ID:              req.Id,
+		Class:           req.Class,
+		Duration:        req.Duration,
+		PlannedDuration: req.PlannedDuration,
+		Cue:             req.Cue,
+		SCTE35:          req.Scte35,
+		Attributes:      req.Attributes,
+	}
+	if req.StartDate != 0 {
+		m.StartDate = time.Unix(0, req.StartDate)
+	}
+	if err := h.controller.InsertMetadata(ctx, m); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
 // GetMetrics implement the handler-side gathering of metrics to return over IPC
 func (h *Handler) GetMetrics(ctx context.Context, _ *ipc.MetricsRequest) (*ipc.MetricsResponse, error) {
 	ctx, span := tracer.Start(ctx, "Handler.GetMetrics")
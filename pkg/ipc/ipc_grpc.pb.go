@@ -202,6 +202,7 @@ const (
 	EgressHandler_GetPipelineDot_FullMethodName = "/ipc.EgressHandler/GetPipelineDot"
 	EgressHandler_GetPProf_FullMethodName       = "/ipc.EgressHandler/GetPProf"
 	EgressHandler_GetMetrics_FullMethodName     = "/ipc.EgressHandler/GetMetrics"
+	EgressHandler_InsertMetadata_FullMethodName = "/ipc.EgressHandler/InsertMetadata"
 )
 
 // EgressHandlerClient is the client API for EgressHandler service.
@@ -211,6 +212,7 @@ type EgressHandlerClient interface {
 	GetPipelineDot(ctx context.Context, in *GstPipelineDebugDotRequest, opts ...grpc.CallOption) (*GstPipelineDebugDotResponse, error)
 	GetPProf(ctx context.Context, in *PProfRequest, opts ...grpc.CallOption) (*PProfResponse, error)
 	GetMetrics(ctx context.Context, in *MetricsRequest, opts ...grpc.CallOption) (*MetricsResponse, error)
+	InsertMetadata(ctx context.Context, in *InsertMetadataRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
 
 type egressHandlerClient struct {
@@ -248,6 +250,15 @@ func (c *egressHandlerClient) GetMetrics(ctx context.Context, in *MetricsRequest
 	return out, nil
 }
 
+func (c *egressHandlerClient) InsertMetadata(ctx context.Context, in *InsertMetadataRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, EgressHandler_InsertMetadata_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // EgressHandlerServer is the server API for EgressHandler service.
 // All implementations must embed UnimplementedEgressHandlerServer
 // for forward compatibility
@@ -255,6 +266,7 @@ type EgressHandlerServer interface {
 	GetPipelineDot(context.Context, *GstPipelineDebugDotRequest) (*GstPipelineDebugDotResponse, error)
 	GetPProf(context.Context, *PProfRequest) (*PProfResponse, error)
 	GetMetrics(context.Context, *MetricsRequest) (*MetricsResponse, error)
+	InsertMetadata(context.Context, *InsertMetadataRequest) (*emptypb.Empty, error)
 	mustEmbedUnimplementedEgressHandlerServer()
 }
 
@@ -271,6 +283,9 @@ func (UnimplementedEgressHandlerServer) GetPProf(context.Context, *PProfRequest)
 func (UnimplementedEgressHandlerServer) GetMetrics(context.Context, *MetricsRequest) (*MetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetMetrics not implemented")
 }
+func (UnimplementedEgressHandlerServer) InsertMetadata(context.Context, *InsertMetadataRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InsertMetadata not implemented")
+}
 func (UnimplementedEgressHandlerServer) mustEmbedUnimplementedEgressHandlerServer() {}
 
 // UnsafeEgressHandlerServer may be embedded to opt out of forward compatibility for this service.
@@ -338,6 +353,24 @@ func _EgressHandler_GetMetrics_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _EgressHandler_InsertMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EgressHandlerServer).InsertMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EgressHandler_InsertMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EgressHandlerServer).InsertMetadata(ctx, req.(*InsertMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // EgressHandler_ServiceDesc is the grpc.ServiceDesc for EgressHandler service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -357,6 +390,10 @@ var EgressHandler_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetMetrics",
 			Handler:    _EgressHandler_GetMetrics_Handler,
 		},
+		{
+			MethodName: "InsertMetadata",
+			Handler:    _EgressHandler_InsertMetadata_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "ipc.proto",
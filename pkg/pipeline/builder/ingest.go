@@ -0,0 +1,73 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/ingest"
+)
+
+// ingestBinName names the segment bin of hls and dash stream outputs, and its splitmuxsink
+const ingestBinName = "ingest"
+
+// BuildIngestStreamBin segments hls and dash stream outputs once. Segments are pushed to every stream url
+// by the publisher, outside of gstreamer.
+func BuildIngestStreamBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig, publisher *ingest.Publisher) (*StreamBin, *gstreamer.Bin, error) {
+	o := p.GetStreamConfig()
+
+	b, err := buildSegmentBin(pipeline, p, o.Ingest.Segments, ingestBinName, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sb := &StreamBin{
+		b:          b,
+		outputType: o.OutputType,
+		sinks:      make(map[string]*StreamSink),
+		ingest:     publisher,
+	}
+
+	o.Streams.Range(func(_, stream any) bool {
+		err = sb.AddStream(stream.(*config.Stream))
+		return err == nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sb, b, nil
+}
+
+// IsIngestSegmentSink returns true for the splitmuxsink of hls and dash stream outputs
+func IsIngestSegmentSink(name string) bool {
+	return name == splitMuxSinkPrefix+ingestBinName
+}
+
+// addIngestStream starts pushing to the url with the next segment. The stream starts once its first segment is sent.
+func (sb *StreamBin) addIngestStream(stream *config.Stream) error {
+	if err := sb.ingest.AddDestination(stream.Name, stream.ParsedUrl); err != nil {
+		return err
+	}
+
+	sb.mu.Lock()
+	sb.sinks[stream.Name] = &StreamSink{
+		stream: stream,
+		ingest: true,
+	}
+	sb.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/rtsp"
+)
+
+// addRTSPServerStream hosts the stream for players to pull. The mpegts stream is payloaded and
+// pulled into an app sink, which hands packets to every playing session.
+func (sb *StreamBin) addRTSPServerStream(stream *config.Stream) error {
+	server, err := rtsp.NewServer(stream.ParsedUrl, sb.rtspServer.SessionTimeout)
+	if err != nil {
+		return err
+	}
+
+	b := sb.b.NewBin(stream.Name)
+	queue, err := gstreamer.BuildQueue(fmt.Sprintf("queue_%s", stream.Name), config.Latency, true)
+	if err != nil {
+		_ = server.Close()
+		return errors.ErrGstPipelineError(err)
+	}
+	pay, err := gst.NewElementWithName("rtpmp2tpay", fmt.Sprintf("rtpmp2tpay_%s", stream.Name))
+	if err != nil {
+		_ = server.Close()
+		return errors.ErrGstPipelineError(err)
+	}
+	elem, err := gst.NewElementWithName("appsink", fmt.Sprintf("appsink_%s", stream.Name))
+	if err != nil {
+		_ = server.Close()
+		return errors.ErrGstPipelineError(err)
+	}
+	appSink := app.SinkFromElement(elem)
+	if err = appSink.SetProperty("sync", false); err != nil {
+		_ = server.Close()
+		return errors.ErrGstPipelineError(err)
+	}
+	appSink.SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(appSink *app.Sink) gst.FlowReturn {
+			sample := appSink.PullSample()
+			if sample == nil {
+				return gst.FlowOK
+			}
+			if buffer := sample.GetBuffer(); buffer != nil {
+				server.WriteRTP(buffer.Bytes())
+			}
+			return gst.FlowOK
+		},
+	})
+
+	if err = b.AddElements(queue, pay, appSink.Element); err != nil {
+		_ = server.Close()
+		return err
+	}
+
+	sb.mu.Lock()
+	sb.sinks[stream.Name] = &StreamSink{
+		stream: stream,
+		bin:    b,
+		sink:   appSink.Element,
+		rtsp:   server,
+	}
+	sb.mu.Unlock()
+
+	return sb.b.AddSinkBin(b)
+}
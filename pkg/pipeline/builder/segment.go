@@ -20,24 +20,90 @@ import (
 	"time"
 
 	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
 
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/gstreamer"
-	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/logger"
 )
 
+const (
+	// rendition bins are fed raw video by the video bin
+	renditionBinPrefix = "segment_rendition_"
+	// splitmuxsinks are named after their bin, so their messages can be routed
+	splitMuxSinkPrefix = "splitmuxsink_"
+)
+
 type FirstSampleMetadata struct {
 	StartDate int64 // Real time date of the first media sample
 }
 
-func BuildSegmentBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*gstreamer.Bin, error) {
-	b := pipeline.NewBin("segment")
+// ID3Src writes ID3 timed metadata into the mpeg-ts segments of each rendition
+type ID3Src struct {
+	srcs []*app.Source
+}
+
+// Push writes an ID3 tag at the current running time
+func (s *ID3Src) Push(tag []byte) {
+	for _, src := range s.srcs {
+		if flow := src.PushBuffer(gst.NewBufferFromBytes(tag)); flow != gst.FlowOK {
+			logger.Infow("unexpected flow return", "flow", flow)
+		}
+	}
+}
+
+// EndStream sends EOS. The sources are not upstream of the pipeline's other sources, so this is not done by the pipeline.
+func (s *ID3Src) EndStream() {
+	for _, src := range s.srcs {
+		src.EndStream()
+	}
+}
+
+// BuildSegmentBins returns one bin per rendition for multi-rendition outputs, otherwise a single segment bin.
+// An ID3Src is also returned when timed metadata is written into segments.
+func BuildSegmentBins(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) ([]*gstreamer.Bin, *ID3Src, error) {
 	o := p.GetSegmentConfig()
 
-	var h264parse *gst.Element
+	var id3Src *ID3Src
+	if o.ID3Metadata {
+		id3Src = &ID3Src{}
+	}
+
+	if len(o.Renditions) == 0 {
+		b, err := buildSegmentBin(pipeline, p, o, "segment", id3Src)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*gstreamer.Bin{b}, id3Src, nil
+	}
+
+	bins := make([]*gstreamer.Bin, 0, len(o.Renditions))
+	for _, r := range o.Renditions {
+		b, err := buildSegmentBin(pipeline, p, r, renditionBinPrefix+r.Rendition.Name, id3Src)
+		if err != nil {
+			return nil, nil, err
+		}
+		bins = append(bins, b)
+	}
+	return bins, id3Src, nil
+}
+
+func buildSegmentBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig, o *config.SegmentConfig, name string, id3Src *ID3Src) (*gstreamer.Bin, error) {
+	b := pipeline.NewBin(name)
+
+	var videoSink *gst.Element
 	var err error
+	if o.Rendition != nil {
+		// scale and encode the raw video for this rendition
+		videoSink, err = addRenditionEncoder(b, name, p, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var h264parse *gst.Element
 	if p.VideoEnabled {
 		h264parse, err = gst.NewElement("h264parse")
 		if err != nil {
@@ -47,20 +113,49 @@ func BuildSegmentBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*g
 		if err = b.AddElements(h264parse); err != nil {
 			return nil, errors.ErrGstPipelineError(err)
 		}
+		if videoSink == nil {
+			videoSink = h264parse
+		}
 	}
 
-	sink, err := gst.NewElement("splitmuxsink")
+	sink, err := gst.NewElementWithName("splitmuxsink", splitMuxSinkPrefix+name)
 	if err != nil {
 		return nil, errors.ErrGstPipelineError(err)
 	}
-	if err = sink.SetProperty("max-size-time", uint64(time.Duration(o.SegmentDuration)*time.Second)); err != nil {
+	// low-latency outputs are split into parts, which the sink concatenates into segments.
+	// splitmuxsink requests a keyframe at each split, so every part is independent
+	fragmentDuration := time.Duration(o.SegmentDuration) * time.Second
+	if o.PartDuration > 0 {
+		fragmentDuration = time.Duration(o.PartDuration * float64(time.Second))
+	}
+	if err = sink.SetProperty("max-size-time", uint64(fragmentDuration)); err != nil {
 		return nil, errors.ErrGstPipelineError(err)
 	}
 	if err = sink.SetProperty("send-keyframe-requests", true); err != nil {
 		return nil, errors.ErrGstPipelineError(err)
 	}
-	if err = sink.SetProperty("muxer-factory", "mpegtsmux"); err != nil {
-		return nil, errors.ErrGstPipelineError(err)
+
+	switch o.SegmentOutputType {
+	case types.OutputTypeM4S:
+		// one fragment per segment or part. The muxer is not reset between segments, so the
+		// header (ftyp and moov) is only written to the first one, and split out by the sink
+		mux, err := gst.NewElement("isofmp4mux")
+		if err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
+		if err = mux.SetProperty("fragment-duration", uint64(fragmentDuration)); err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
+		if err = sink.SetProperty("muxer", mux); err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
+		if err = sink.SetProperty("reset-muxer", false); err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
+	default:
+		if err = sink.SetProperty("muxer-factory", "mpegtsmux"); err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
 	}
 
 	var startDate time.Time
@@ -85,15 +180,10 @@ func BuildSegmentBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*g
 			sink.GetBus().Post(msg)
 		}
 
-		var segmentName string
-		switch o.SegmentSuffix {
-		case livekit.SegmentedFileSuffix_TIMESTAMP:
-			ts := startDate.Add(pts)
-			segmentName = fmt.Sprintf("%s_%s%03d.ts", o.SegmentPrefix, ts.Format("20060102150405"), ts.UnixMilli()%1000)
-		default:
-			segmentName = fmt.Sprintf("%s_%05d.ts", o.SegmentPrefix, fragmentId)
+		if o.PartDuration > 0 {
+			return path.Join(o.LocalDir, o.PartFilename(int(fragmentId)))
 		}
-		return path.Join(o.LocalDir, segmentName)
+		return path.Join(o.LocalDir, o.SegmentFilename(o.FirstSegmentIndex+int(fragmentId), startDate.Add(pts)))
 	})
 	if err != nil {
 		return nil, errors.ErrGstPipelineError(err)
@@ -103,11 +193,22 @@ func BuildSegmentBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*g
 		return nil, errors.ErrGstPipelineError(err)
 	}
 
+	var id3BinName string
+	if id3Src != nil {
+		id3BinName = fmt.Sprintf("%s_id3", name)
+		if err = addID3Bin(b, id3BinName, id3Src); err != nil {
+			return nil, err
+		}
+	}
+
 	b.SetGetSrcPad(func(name string) *gst.Pad {
 		if name == "audio" {
 			return sink.GetRequestPad("audio_%u")
-		} else if h264parse != nil {
-			return h264parse.GetStaticPad("sink")
+		} else if id3BinName != "" && name == id3BinName {
+			// muxed as a timed metadata stream
+			return sink.GetRequestPad("subtitle_%u")
+		} else if videoSink != nil {
+			return videoSink.GetStaticPad("sink")
 		} else {
 			// Should never happen
 			return nil
@@ -116,3 +217,79 @@ func BuildSegmentBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*g
 
 	return b, nil
 }
+
+// addID3Bin adds a live source of ID3 tags to the segment bin, timestamped with the running time they are pushed at
+func addID3Bin(b *gstreamer.Bin, name string, id3Src *ID3Src) error {
+	id3Bin := b.NewBin(name)
+
+	src, err := app.NewAppSrc()
+	if err != nil {
+		return errors.ErrGstPipelineError(err)
+	}
+	src.Element.SetArg("format", "time")
+	if err = src.Element.SetProperty("is-live", true); err != nil {
+		return errors.ErrGstPipelineError(err)
+	}
+	if err = src.Element.SetProperty("do-timestamp", true); err != nil {
+		return errors.ErrGstPipelineError(err)
+	}
+	src.SetCaps(gst.NewCapsFromString("meta/x-id3"))
+
+	if err = id3Bin.AddElement(src.Element); err != nil {
+		return err
+	}
+	id3Bin.SetGetSinkPad(func(string) *gst.Pad {
+		return src.GetStaticPad("src")
+	})
+	if err = b.AddSourceBin(id3Bin); err != nil {
+		return err
+	}
+
+	id3Src.srcs = append(id3Src.srcs, src)
+	return nil
+}
+
+// addRenditionEncoder adds a scaler and encoder for the rendition's resolution and bitrate, returning the first element
+func addRenditionEncoder(b *gstreamer.Bin, name string, p *config.PipelineConfig, o *config.SegmentConfig) (*gst.Element, error) {
+	r := o.Rendition
+
+	queue, err := gstreamer.BuildQueue(fmt.Sprintf("%s_queue", name), config.Latency, false)
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	videoScale, err := gst.NewElement("videoscale")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	caps, err := gst.NewElement("capsfilter")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+	if err = caps.SetProperty("caps", gst.NewCapsFromString(fmt.Sprintf(
+		"video/x-raw,format=I420,width=%d,height=%d,colorimetry=bt709,chroma-site=mpeg2,pixel-aspect-ratio=1/1",
+		r.Width, r.Height,
+	))); err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	encoder, err := buildH264Encoder(p, r.VideoBitrate, h264LevelString(config.H264Level(r.Width, r.Height, p.Framerate)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.AddElements(append([]*gst.Element{queue, videoScale, caps}, encoder...)...); err != nil {
+		return nil, err
+	}
+
+	return queue, nil
+}
+
+// h264LevelString formats a level_idc as used in h264 caps, e.g. 4 or 3.1
+func h264LevelString(idc int) string {
+	if idc%10 == 0 {
+		return fmt.Sprintf("%d", idc/10)
+	}
+	return fmt.Sprintf("%d.%d", idc/10, idc%10)
+}
@@ -20,10 +20,14 @@ import (
 	"time"
 
 	"github.com/go-gst/go-gst/gst"
+	"go.uber.org/atomic"
 
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/ingest"
+	"github.com/livekit/egress/pkg/pipeline/sink/rtsp"
+	"github.com/livekit/egress/pkg/pipeline/sink/whip"
 	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/logger"
 	"github.com/livekit/protocol/utils"
@@ -35,15 +39,47 @@ type StreamBin struct {
 	b          *gstreamer.Bin
 	outputType types.OutputType
 	sinks      map[string]*StreamSink
+
+	// whip only
+	audioEnabled bool
+	videoEnabled bool
+
+	// hls and dash only
+	ingest *ingest.Publisher
+
+	// rtmp only
+	reconnect *config.StreamReconnectConfig
+
+	// rtsp only
+	rtspServer *config.RTSPServerConfig
 }
 
 type StreamSink struct {
 	stream         *config.Stream
 	bin            *gstreamer.Bin
 	sink           *gst.Element
+	whip           *whip.Client // whip streams are sent outside of gstreamer, and have no bin
+	ingest         bool         // hls and dash streams are sent by the ingest publisher, and have no bin
+	rtsp           *rtsp.Server // rtsp streams in server mode, pulled by players
 	reconnections  int
 	disconnectedAt time.Time
-	failed         bool
+	failed         atomic.Bool // srt and rtsp only, set by the proxy pad after a flow error
+
+	// rtmp and srt health, sampled from the sink's stats
+	totalReconnects int   // since the stream was added
+	lastError       error // cleared once the stream recovers
+	bytesAcked      uint64
+	sampledAt       time.Time
+}
+
+// StreamHealth is sampled periodically from rtmp and srt streams. Known limitation: StreamInfo has no fields for
+// acked bytes, bitrate or reconnects, so only LastError is listed there - the rest are published to prometheus only.
+type StreamHealth struct {
+	Stream     *config.Stream
+	BytesAcked uint64 // acknowledged by the rtmp server, or sent to the srt peer, since the stream last connected
+	Bitrate    uint64 // acknowledged bits per second since the last sample
+	Reconnects int    // since the stream was added
+	LastError  error  // last disconnection, if the stream has not recovered
 }
 
 func BuildStreamBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*StreamBin, *gstreamer.Bin, error) {
@@ -73,12 +109,15 @@ func BuildStreamBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*St
 			return mux.GetRequestPad(name)
 		})
 
-	case types.OutputTypeSRT:
+	case types.OutputTypeSRT, types.OutputTypeRTSP:
 		mux, err = gst.NewElement("mpegtsmux")
 		if err != nil {
 			return nil, nil, errors.ErrGstPipelineError(err)
 		}
 
+	case types.OutputTypeWHIP:
+		return buildWHIPStreamBin(pipeline, p, b)
+
 	default:
 		err = errors.ErrInvalidInput("output type")
 	}
@@ -102,6 +141,23 @@ func BuildStreamBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*St
 		b:          b,
 		outputType: o.OutputType,
 		sinks:      make(map[string]*StreamSink),
+		reconnect:  o.Reconnect,
+		rtspServer: o.RTSPServer,
+	}
+
+	if o.OutputType == types.OutputTypeRTSP {
+		// players are disconnected once the pipeline stops
+		pipeline.AddOnStop(func() error {
+			sb.mu.Lock()
+			defer sb.mu.Unlock()
+
+			for _, sink := range sb.sinks {
+				if sink.rtsp != nil {
+					_ = sink.rtsp.Close()
+				}
+			}
+			return nil
+		})
 	}
 
 	o.Streams.Range(func(_, stream any) bool {
@@ -117,6 +173,15 @@ func BuildStreamBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) (*St
 
 func (sb *StreamBin) AddStream(stream *config.Stream) error {
 	stream.Name = utils.NewGuid("")
+	switch {
+	case sb.outputType == types.OutputTypeWHIP:
+		return sb.addWHIPStream(stream)
+	case sb.ingest != nil:
+		return sb.addIngestStream(stream)
+	case sb.outputType == types.OutputTypeRTSP && stream.Listen:
+		return sb.addRTSPServerStream(stream)
+	}
+
 	b := sb.b.NewBin(stream.Name)
 
 	queue, err := gstreamer.BuildQueue(fmt.Sprintf("queue_%s", stream.Name), config.Latency, true)
@@ -143,6 +208,7 @@ func (sb *StreamBin) AddStream(stream *config.Stream) error {
 		if err != nil {
 			return errors.ErrGstPipelineError(err)
 		}
+		// mode, latency, passphrase, pbkeylen and streamid are read from the uri, and validated with the url
 		if err = sink.SetProperty("uri", stream.ParsedUrl); err != nil {
 			return errors.ErrGstPipelineError(err)
 		}
@@ -150,16 +216,31 @@ func (sb *StreamBin) AddStream(stream *config.Stream) error {
 			return errors.ErrGstPipelineError(err)
 		}
 
+	case types.OutputTypeRTSP:
+		// pushes using ANNOUNCE and RECORD, payloading the mpegts stream
+		sink, err = gst.NewElementWithName("rtspclientsink", fmt.Sprintf("rtspclientsink_%s", stream.Name))
+		if err != nil {
+			return errors.ErrGstPipelineError(err)
+		}
+		if err = sink.SetProperty("location", stream.ParsedUrl); err != nil {
+			return errors.ErrGstPipelineError(err)
+		}
+		if err = sink.SetProperty("latency", uint(config.Latency/uint64(time.Millisecond))); err != nil {
+			return errors.ErrGstPipelineError(err)
+		}
+
 	default:
 		return errors.ErrInvalidInput("output type")
 	}
 
-	// GstBaseSink properties
-	if err = sink.SetProperty("async", false); err != nil {
-		return errors.ErrGstPipelineError(err)
-	}
-	if err = sink.SetProperty("sync", false); err != nil {
-		return errors.ErrGstPipelineError(err)
+	// GstBaseSink properties (rtspclientsink is a bin)
+	if sb.outputType != types.OutputTypeRTSP {
+		if err = sink.SetProperty("async", false); err != nil {
+			return errors.ErrGstPipelineError(err)
+		}
+		if err = sink.SetProperty("sync", false); err != nil {
+			return errors.ErrGstPipelineError(err)
+		}
 	}
 	if err = b.AddElements(queue, sink); err != nil {
 		return err
@@ -173,7 +254,11 @@ func (sb *StreamBin) AddStream(stream *config.Stream) error {
 
 	// add a proxy pad between the queue and sink to prevent errors from propagating upstream
 	b.SetLinkFunc(func() error {
-		proxy := gst.NewGhostPad(fmt.Sprintf("proxy_%s", stream.Name), sink.GetStaticPad("sink"))
+		sinkPad := sink.GetStaticPad("sink")
+		if sb.outputType == types.OutputTypeRTSP {
+			sinkPad = sink.GetRequestPad("sink_%u")
+		}
+		proxy := gst.NewGhostPad(fmt.Sprintf("proxy_%s", stream.Name), sinkPad)
 		proxy.Ref()
 		proxy.ActivateMode(gst.PadModePush, true)
 
@@ -191,10 +276,10 @@ func (sb *StreamBin) AddStream(stream *config.Stream) error {
 					return gst.FlowOK
 				}
 			})
-		case types.OutputTypeSRT:
+		case types.OutputTypeSRT, types.OutputTypeRTSP:
 			proxy.SetChainListFunction(func(self *gst.Pad, _ *gst.Object, list *gst.BufferList) gst.FlowReturn {
 				list.Ref()
-				if ss.failed {
+				if ss.failed.Load() {
 					return gst.FlowOK
 				}
 				links, _ := self.GetInternalLinks()
@@ -205,7 +290,7 @@ func (sb *StreamBin) AddStream(stream *config.Stream) error {
 				case gst.FlowEOS:
 					return gst.FlowEOS
 				case gst.FlowError:
-					ss.failed = true
+					ss.failed.Store(true)
 				}
 				return gst.FlowOK
 			})
@@ -236,6 +321,9 @@ func (sb *StreamBin) GetStream(name string) (*config.Stream, error) {
 	return sink.stream, nil
 }
 
+// MaybeResetStream reconnects an rtmp or srt stream, following the reconnect policy. Streams which never
+// delivered any data are not reconnected, since the url or stream key is probably wrong. Srt listeners wait
+// for callers instead of connecting, so they are not reconnected either.
 func (sb *StreamBin) MaybeResetStream(stream *config.Stream, streamErr error) (bool, error) {
 	sb.mu.Lock()
 	sink, ok := sb.sinks[stream.Name]
@@ -244,42 +332,155 @@ func (sb *StreamBin) MaybeResetStream(stream *config.Stream, streamErr error) (b
 		return false, errors.ErrStreamNotFound(stream.Name)
 	}
 
-	s, err := sink.sink.GetProperty("stats")
+	if stream.SRT != nil && stream.SRT.Mode == config.SRTModeListener {
+		return false, nil
+	}
+
+	outBytes, err := sb.getBytesSent(sink.sink)
 	if err != nil {
 		return false, err
 	}
-	values := s.(*gst.Structure).Values()
-	outBytes := values["out-bytes-acked"].(uint64)
+
+	// reconnect state is shared with SampleHealth
+	sb.mu.Lock()
+	delay, reset := sb.updateReconnectState(sink, outBytes, streamErr)
+	attempt := sink.reconnections
+	sb.mu.Unlock()
+	if !reset {
+		return false, nil
+	}
+
+	logger.Warnw("resetting stream", streamErr,
+		"url", sink.stream.RedactedUrl,
+		"attempt", attempt,
+		"delay", delay,
+	)
+
+	if err = sink.bin.SetState(gst.StateNull); err != nil {
+		return false, err
+	}
+	// srt proxies stop pushing after a flow error
+	sink.failed.Store(false)
+	if delay == 0 {
+		if err = sink.bin.SetState(gst.StatePlaying); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	// buffers are dropped by the proxy pad until the sink is playing again
+	time.AfterFunc(delay, func() {
+		sb.mu.RLock()
+		current, ok := sb.sinks[stream.Name]
+		sb.mu.RUnlock()
+		if !ok || current != sink {
+			// removed while waiting
+			return
+		}
+		if err := sink.bin.SetState(gst.StatePlaying); err != nil {
+			sb.b.OnStreamFailed(stream.Name, err)
+		}
+	})
+	return true, nil
+}
+
+// updateReconnectState records a disconnection, and returns the delay before the next attempt if the
+// stream should be reset. sb.mu must be held.
+func (sb *StreamBin) updateReconnectState(sink *StreamSink, outBytes uint64, streamErr error) (time.Duration, bool) {
+	sink.lastError = streamErr
 
 	if sink.reconnections == 0 && outBytes == 0 {
 		// unable to connect, probably a bad stream key or url
-		return false, nil
+		return 0, false
 	}
 
 	if outBytes > 0 {
-		// first disconnection
+		// first disconnection, or the last reconnection succeeded
 		sink.disconnectedAt = time.Now()
 		sink.reconnections = 0
-	} else if time.Since(sink.disconnectedAt) > time.Second*30 {
-		return false, nil
+	}
+	switch {
+	case sb.reconnect.MaxAttempts > 0 && sink.reconnections >= sb.reconnect.MaxAttempts:
+		return 0, false
+	case time.Since(sink.disconnectedAt) > sb.reconnect.Window:
+		return 0, false
 	}
 
+	delay := sb.reconnect.ReconnectDelay(sink.reconnections)
 	sink.reconnections++
-	logger.Warnw("resetting stream", streamErr, "url", sink.stream.RedactedUrl)
+	sink.totalReconnects++
+	sink.bytesAcked = 0
+	return delay, true
+}
 
-	if err = sink.bin.SetState(gst.StateNull); err != nil {
-		return false, err
+// SampleHealth returns the health of each rtmp and srt stream
+func (sb *StreamBin) SampleHealth() []*StreamHealth {
+	if sb.reconnect == nil {
+		return nil
 	}
-	if err = sink.bin.SetState(gst.StatePlaying); err != nil {
-		return false, err
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	now := time.Now()
+	health := make([]*StreamHealth, 0, len(sb.sinks))
+	for _, sink := range sb.sinks {
+		outBytes, err := sb.getBytesSent(sink.sink)
+		if err != nil {
+			logger.Debugw("failed to sample stream stats", "error", err, "url", sink.stream.RedactedUrl)
+			continue
+		}
+
+		var bitrate uint64
+		if !sink.sampledAt.IsZero() && outBytes >= sink.bytesAcked {
+			bitrate = uint64(float64(outBytes-sink.bytesAcked) * 8 / now.Sub(sink.sampledAt).Seconds())
+		}
+		if outBytes > sink.bytesAcked && sink.reconnections > 0 {
+			// recovered
+			sink.lastError = nil
+		}
+		sink.bytesAcked = outBytes
+		sink.sampledAt = now
+
+		health = append(health, &StreamHealth{
+			Stream:     sink.stream,
+			BytesAcked: outBytes,
+			Bitrate:    bitrate,
+			Reconnects: sink.totalReconnects,
+			LastError:  sink.lastError,
+		})
 	}
+	return health
+}
 
-	return true, nil
+// getBytesSent returns bytes acknowledged by the rtmp server, or sent to the srt peer, since the sink connected
+func (sb *StreamBin) getBytesSent(sink *gst.Element) (uint64, error) {
+	s, err := sink.GetProperty("stats")
+	if err != nil {
+		return 0, err
+	}
+	stats, ok := s.(*gst.Structure)
+	if !ok || stats == nil {
+		return 0, nil
+	}
+
+	field := "out-bytes-acked"
+	if sb.outputType == types.OutputTypeSRT {
+		field = "bytes-sent"
+	}
+	switch v := stats.Values()[field].(type) {
+	case uint64:
+		return v, nil
+	case int64:
+		return uint64(max(v, 0)), nil
+	default:
+		return 0, nil
+	}
 }
 
 func (sb *StreamBin) RemoveStream(stream *config.Stream) error {
 	sb.mu.Lock()
-	_, ok := sb.sinks[stream.Name]
+	sink, ok := sb.sinks[stream.Name]
 	if !ok {
 		sb.mu.Unlock()
 		return errors.ErrStreamNotFound(stream.RedactedUrl)
@@ -287,5 +488,16 @@ func (sb *StreamBin) RemoveStream(stream *config.Stream) error {
 	delete(sb.sinks, stream.Name)
 	sb.mu.Unlock()
 
+	if sink.whip != nil {
+		return sink.whip.Close()
+	}
+	if sink.ingest {
+		return sb.ingest.RemoveDestination(stream.Name)
+	}
+	if sink.rtsp != nil {
+		if err := sink.rtsp.Close(); err != nil {
+			logger.Warnw("failed to close rtsp server", err, "url", stream.RedactedUrl)
+		}
+	}
 	return sb.b.RemoveSinkBin(stream.Name)
 }
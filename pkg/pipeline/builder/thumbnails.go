@@ -0,0 +1,86 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/gstreamer"
+)
+
+const thumbnailBinName = "thumbnails"
+
+// BuildThumbnailBin captures scaled rgba frames from the raw video tee, which the thumbnail sink composes into sheets
+func BuildThumbnailBin(pipeline *gstreamer.Pipeline, c *config.ThumbnailConfig, appSinkCallbacks *app.SinkCallbacks) (*gstreamer.Bin, error) {
+	b := pipeline.NewBin(thumbnailBinName)
+
+	queue, err := gstreamer.BuildQueue("thumbnail_queue", imageQueueLatency, true)
+	if err != nil {
+		return nil, err
+	}
+
+	videoRate, err := gst.NewElement("videorate")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+	if err = videoRate.SetProperty("skip-to-first", true); err != nil {
+		return nil, err
+	}
+
+	videoScale, err := gst.NewElement("videoscale")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	videoConvert, err := gst.NewElement("videoconvert")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	caps, err := gst.NewElement("capsfilter")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+	err = caps.SetProperty("caps", gst.NewCapsFromString(fmt.Sprintf(
+		"video/x-raw,framerate=1/%d,format=RGBA,width=%d,height=%d,pixel-aspect-ratio=1/1",
+		c.Interval, c.Width, c.Height)))
+	if err != nil {
+		return nil, err
+	}
+
+	appSink, err := app.NewAppSink()
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+	appSink.SetCallbacks(appSinkCallbacks)
+
+	if err = b.AddElements(queue, videoRate, videoScale, videoConvert, caps, appSink.Element); err != nil {
+		return nil, err
+	}
+
+	b.SetGetSrcPad(func(name string) *gst.Pad {
+		return queue.GetStaticPad("sink")
+	})
+	b.SetShouldLink(func(srcBin string) bool {
+		return srcBin != "audio"
+	})
+
+	return b, nil
+}
@@ -72,7 +72,7 @@ func BuildVideoBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) error
 	}
 
 	var getPad func() *gst.Pad
-	if len(p.GetEncodedOutputs()) > 1 {
+	if p.GetEncodedVideoSinkCount() > 1 {
 		tee, err := gst.NewElementWithName("tee", "video_tee")
 		if err != nil {
 			return errors.ErrGstPipelineError(err)
@@ -85,7 +85,7 @@ func BuildVideoBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) error
 		getPad = func() *gst.Pad {
 			return tee.GetRequestPad("src_%u")
 		}
-	} else if len(p.GetEncodedOutputs()) > 0 {
+	} else if p.GetEncodedVideoSinkCount() > 0 {
 		queue, err := gstreamer.BuildQueue("video_queue", config.Latency, true)
 		if err != nil {
 			return errors.ErrGstPipelineError(err)
@@ -100,7 +100,8 @@ func BuildVideoBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig) error
 	}
 
 	b.bin.SetGetSinkPad(func(name string) *gst.Pad {
-		if strings.HasPrefix(name, "image") {
+		if strings.HasPrefix(name, "image") || strings.HasPrefix(name, thumbnailBinName) || strings.HasPrefix(name, renditionBinPrefix) {
+			// images, thumbnails and renditions do their own scaling and encoding
 			return b.rawVideoTee.GetRequestPad("src_%u")
 		} else if getPad != nil {
 			return getPad()
@@ -532,63 +533,11 @@ func (b *VideoBin) addEncoder() error {
 	switch b.conf.VideoOutCodec {
 	// we only encode h264, the rest are too slow
 	case types.MimeTypeH264:
-		x264Enc, err := gst.NewElement("x264enc")
+		elements, err := buildH264Encoder(b.conf, b.conf.VideoBitrate, "")
 		if err != nil {
-			return errors.ErrGstPipelineError(err)
-		}
-
-		x264Enc.SetArg("speed-preset", "veryfast")
-		if b.conf.KeyFrameInterval != 0 {
-			keyframeInterval := uint(b.conf.KeyFrameInterval * float64(b.conf.Framerate))
-			if err = x264Enc.SetProperty("key-int-max", keyframeInterval); err != nil {
-				return errors.ErrGstPipelineError(err)
-			}
-		}
-
-		var options []string
-		bufCapacity := uint(2000) // 2s
-		if b.conf.GetSegmentConfig() != nil {
-			// avoid key frames other than at segments boundaries as splitmuxsink can become inconsistent otherwise
-			options = append(options, "scenecut=0")
-			bufCapacity = uint(time.Duration(b.conf.GetSegmentConfig().SegmentDuration) * (time.Second / time.Millisecond))
-		}
-		if bufCapacity > 10000 {
-			// Max value allowed by gstreamer
-			bufCapacity = 10000
-		}
-		if err = x264Enc.SetProperty("vbv-buf-capacity", bufCapacity); err != nil {
-			return errors.ErrGstPipelineError(err)
-		}
-
-		if err = x264Enc.SetProperty("bitrate", uint(b.conf.VideoBitrate)); err != nil {
-			return errors.ErrGstPipelineError(err)
-		}
-
-		if sc := b.conf.GetStreamConfig(); sc != nil && sc.OutputType == types.OutputTypeRTMP {
-			options = append(options, "nal-hrd=cbr")
-		}
-		if len(options) > 0 {
-			optionString := strings.Join(options, ":")
-			if err = x264Enc.SetProperty("option-string", optionString); err != nil {
-				return errors.ErrGstPipelineError(err)
-			}
-		}
-
-		caps, err := gst.NewElement("capsfilter")
-		if err != nil {
-			return errors.ErrGstPipelineError(err)
-		}
-		if err = caps.SetProperty("caps", gst.NewCapsFromString(fmt.Sprintf(
-			"video/x-h264,profile=%s",
-			b.conf.VideoProfile,
-		))); err != nil {
-			return errors.ErrGstPipelineError(err)
-		}
-
-		if err = b.bin.AddElements(x264Enc, caps); err != nil {
 			return err
 		}
-		return nil
+		return b.bin.AddElements(elements...)
 
 	case types.MimeTypeVP9:
 		vp9Enc, err := gst.NewElement("vp9enc")
@@ -627,6 +576,68 @@ func (b *VideoBin) addEncoder() error {
 	}
 }
 
+// buildH264Encoder returns an x264enc followed by a capsfilter setting the profile, and the level if given
+func buildH264Encoder(p *config.PipelineConfig, bitrate int32, level string) ([]*gst.Element, error) {
+	x264Enc, err := gst.NewElement("x264enc")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	x264Enc.SetArg("speed-preset", "veryfast")
+	if p.KeyFrameInterval != 0 {
+		keyframeInterval := uint(p.KeyFrameInterval * float64(p.Framerate))
+		if err = x264Enc.SetProperty("key-int-max", keyframeInterval); err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
+	}
+
+	var options []string
+	bufCapacity := uint(2000) // 2s
+	if p.GetSegmentConfig() != nil {
+		// avoid key frames other than at segments boundaries as splitmuxsink can become inconsistent otherwise
+		options = append(options, "scenecut=0")
+		bufCapacity = uint(time.Duration(p.GetSegmentConfig().SegmentDuration) * (time.Second / time.Millisecond))
+	}
+	if bufCapacity > 10000 {
+		// Max value allowed by gstreamer
+		bufCapacity = 10000
+	}
+	if err = x264Enc.SetProperty("vbv-buf-capacity", bufCapacity); err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	if err = x264Enc.SetProperty("bitrate", uint(bitrate)); err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	if sc := p.GetStreamConfig(); sc != nil && sc.OutputType == types.OutputTypeRTMP {
+		options = append(options, "nal-hrd=cbr")
+	} else if sc != nil && sc.OutputType == types.OutputTypeWHIP {
+		// webrtc receivers do not support b-frames
+		x264Enc.SetArg("tune", "zerolatency")
+	}
+	if len(options) > 0 {
+		optionString := strings.Join(options, ":")
+		if err = x264Enc.SetProperty("option-string", optionString); err != nil {
+			return nil, errors.ErrGstPipelineError(err)
+		}
+	}
+
+	capsStr := fmt.Sprintf("video/x-h264,profile=%s", p.VideoProfile)
+	if level != "" {
+		capsStr = fmt.Sprintf("%s,level=(string)%s", capsStr, level)
+	}
+	caps, err := gst.NewElement("capsfilter")
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+	if err = caps.SetProperty("caps", gst.NewCapsFromString(capsStr)); err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	return []*gst.Element{x264Enc, caps}, nil
+}
+
 func (b *VideoBin) addDecodedVideoSink() error {
 	var err error
 	b.rawVideoTee, err = gst.NewElement("tee")
@@ -0,0 +1,210 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/whip"
+	"github.com/livekit/protocol/logger"
+)
+
+const opusFrameDuration = time.Millisecond * 20
+
+// buildWHIPStreamBin pulls encoded audio and video into app sinks, which are sent to every whip stream.
+// WebRTC sends each track separately, so there is no mux.
+func buildWHIPStreamBin(pipeline *gstreamer.Pipeline, p *config.PipelineConfig, b *gstreamer.Bin) (*StreamBin, *gstreamer.Bin, error) {
+	sb := &StreamBin{
+		b:            b,
+		outputType:   p.GetStreamConfig().OutputType,
+		sinks:        make(map[string]*StreamSink),
+		audioEnabled: p.AudioEnabled,
+		videoEnabled: p.VideoEnabled,
+	}
+
+	var audioChain, videoChain []*gst.Element
+	if p.AudioEnabled {
+		queue, err := gstreamer.BuildQueue("whip_audio_queue", config.Latency, true)
+		if err != nil {
+			return nil, nil, errors.ErrGstPipelineError(err)
+		}
+		appSink, err := sb.buildWHIPAppSink("whip_audio_appsink", opusFrameDuration, (*whip.Client).WriteAudio)
+		if err != nil {
+			return nil, nil, err
+		}
+		audioChain = []*gst.Element{queue, appSink.Element}
+	}
+
+	if p.VideoEnabled {
+		queue, err := gstreamer.BuildQueue("whip_video_queue", config.Latency, true)
+		if err != nil {
+			return nil, nil, errors.ErrGstPipelineError(err)
+		}
+
+		// resend sps and pps with every keyframe, so the receiver can decode from any keyframe
+		h264Parse, err := gst.NewElement("h264parse")
+		if err != nil {
+			return nil, nil, errors.ErrGstPipelineError(err)
+		}
+		if err = h264Parse.SetProperty("config-interval", -1); err != nil {
+			return nil, nil, errors.ErrGstPipelineError(err)
+		}
+
+		caps, err := gst.NewElement("capsfilter")
+		if err != nil {
+			return nil, nil, errors.ErrGstPipelineError(err)
+		}
+		if err = caps.SetProperty("caps", gst.NewCapsFromString(
+			"video/x-h264,stream-format=byte-stream,alignment=au",
+		)); err != nil {
+			return nil, nil, errors.ErrGstPipelineError(err)
+		}
+
+		frameDuration := time.Second / time.Duration(max(p.Framerate, int32(1)))
+		appSink, err := sb.buildWHIPAppSink("whip_video_appsink", frameDuration, (*whip.Client).WriteVideo)
+		if err != nil {
+			return nil, nil, err
+		}
+		videoChain = []*gst.Element{queue, h264Parse, caps, appSink.Element}
+	}
+
+	if err := b.AddElements(append(audioChain, videoChain...)...); err != nil {
+		return nil, nil, err
+	}
+
+	b.SetLinkFunc(func() error {
+		for _, chain := range [][]*gst.Element{audioChain, videoChain} {
+			if len(chain) > 1 {
+				if err := gst.ElementLinkMany(chain...); err != nil {
+					return errors.ErrGstPipelineError(err)
+				}
+			}
+		}
+		return nil
+	})
+	b.SetGetSrcPad(func(name string) *gst.Pad {
+		switch {
+		case name == "audio" && len(audioChain) > 0:
+			return audioChain[0].GetStaticPad("sink")
+		case name == "video" && len(videoChain) > 0:
+			return videoChain[0].GetStaticPad("sink")
+		default:
+			return nil
+		}
+	})
+
+	// whip sessions are deleted once the pipeline stops
+	pipeline.AddOnStop(func() error {
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+
+		for _, sink := range sb.sinks {
+			if err := sink.whip.Close(); err != nil {
+				logger.Warnw("failed to close whip stream", err, "url", sink.stream.RedactedUrl)
+			}
+		}
+		return nil
+	})
+
+	var err error
+	p.GetStreamConfig().Streams.Range(func(_, stream any) bool {
+		err = sb.AddStream(stream.(*config.Stream))
+		return err == nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sb, b, nil
+}
+
+func (sb *StreamBin) buildWHIPAppSink(
+	name string,
+	defaultDuration time.Duration,
+	write func(*whip.Client, []byte, time.Duration) error,
+) (*app.Sink, error) {
+	elem, err := gst.NewElementWithName("appsink", name)
+	if err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+	appSink := app.SinkFromElement(elem)
+	if err = appSink.SetProperty("sync", false); err != nil {
+		return nil, errors.ErrGstPipelineError(err)
+	}
+
+	appSink.SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(appSink *app.Sink) gst.FlowReturn {
+			sample := appSink.PullSample()
+			if sample == nil {
+				return gst.FlowOK
+			}
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowOK
+			}
+
+			duration := defaultDuration
+			if d := buffer.Duration().AsDuration(); d != nil && *d > 0 {
+				duration = *d
+			}
+			data := buffer.Bytes()
+
+			sb.mu.RLock()
+			defer sb.mu.RUnlock()
+			for _, sink := range sb.sinks {
+				if err := write(sink.whip, data, duration); err != nil {
+					logger.Debugw("failed to write whip sample", "error", err, "url", sink.stream.RedactedUrl)
+				}
+			}
+			return gst.FlowOK
+		},
+	})
+
+	return appSink, nil
+}
+
+// addWHIPStream negotiates the session in the background. The stream starts once connected.
+func (sb *StreamBin) addWHIPStream(stream *config.Stream) error {
+	name := stream.Name
+	client, err := whip.NewClient(stream.ParsedUrl, sb.audioEnabled, sb.videoEnabled,
+		func() { sb.b.OnStreamStarted(name) },
+		func(err error) { sb.b.OnStreamFailed(name, err) },
+	)
+	if err != nil {
+		return err
+	}
+
+	sb.mu.Lock()
+	sb.sinks[name] = &StreamSink{
+		stream: stream,
+		whip:   client,
+	}
+	sb.mu.Unlock()
+
+	go func() {
+		if err := client.Connect(context.Background()); err != nil {
+			sb.b.OnStreamFailed(name, err)
+		}
+	}()
+
+	return nil
+}
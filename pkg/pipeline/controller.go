@@ -32,6 +32,7 @@ import (
 	"github.com/livekit/egress/pkg/ipc"
 	"github.com/livekit/egress/pkg/pipeline/builder"
 	"github.com/livekit/egress/pkg/pipeline/sink"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
 	"github.com/livekit/egress/pkg/pipeline/source"
 	"github.com/livekit/egress/pkg/stats"
 	"github.com/livekit/egress/pkg/types"
@@ -43,6 +44,9 @@ import (
 
 const (
 	pipelineName = "pipeline"
+
+	// uploads in progress when stopping are aborted if they have not finished within this time
+	uploadInterruptDelay = time.Second * 10
 )
 
 type Controller struct {
@@ -55,7 +59,9 @@ type Controller struct {
 	p         *gstreamer.Pipeline
 	sinks     map[types.EgressType][]sink.Sink
 	streamBin *builder.StreamBin
+	id3Src    *builder.ID3Src
 	callbacks *gstreamer.Callbacks
+	journal   *uploader.Journal
 
 	// internal
 	mu         sync.Mutex
@@ -85,6 +91,9 @@ func New(ctx context.Context, conf *config.PipelineConfig, ipcServiceClient ipc.
 	}
 	c.callbacks.SetOnError(c.OnError)
 	c.callbacks.SetOnEOSSent(c.onEOSSent)
+	c.callbacks.SetOnMetadata(c.onMetadata)
+	c.callbacks.SetOnStreamStarted(c.onStreamStarted)
+	c.callbacks.SetOnStreamFailed(c.onStreamFailed)
 
 	// initialize gst
 	go func() {
@@ -101,8 +110,14 @@ func New(ctx context.Context, conf *config.PipelineConfig, ipcServiceClient ipc.
 		return nil, err
 	}
 
+	// pending uploads are journaled, so that the service can complete them if the handler exits early.
+	// Without a journal, egresses run as before, but pending uploads are lost if the handler crashes.
+	if c.journal, err = uploader.OpenJournal(conf.TmpDir, conf.Info); err != nil {
+		logger.Warnw("failed to open upload journal", err)
+	}
+
 	// create sinks
-	c.sinks, err = sink.CreateSinks(conf, c.callbacks, c.monitor)
+	c.sinks, err = sink.CreateSinks(conf, c.callbacks, c.monitor, c.journal)
 	if err != nil {
 		c.src.Close()
 		return nil, err
@@ -156,13 +171,18 @@ func (c *Controller) BuildPipeline() error {
 			sinkBins = append(sinkBins, sinkBin)
 
 		case types.EgressTypeSegments:
-			var sinkBin *gstreamer.Bin
-			sinkBin, err = builder.BuildSegmentBin(p, c.PipelineConfig)
-			sinkBins = append(sinkBins, sinkBin)
+			var bins []*gstreamer.Bin
+			bins, c.id3Src, err = builder.BuildSegmentBins(p, c.PipelineConfig)
+			sinkBins = append(sinkBins, bins...)
 
 		case types.EgressTypeStream:
 			var sinkBin *gstreamer.Bin
-			c.streamBin, sinkBin, err = builder.BuildStreamBin(p, c.PipelineConfig)
+			if len(c.sinks[egressType]) > 0 {
+				publisher := c.sinks[egressType][0].(*sink.IngestSink).Publisher()
+				c.streamBin, sinkBin, err = builder.BuildIngestStreamBin(p, c.PipelineConfig, publisher)
+			} else {
+				c.streamBin, sinkBin, err = builder.BuildStreamBin(p, c.PipelineConfig)
+			}
 			sinkBins = append(sinkBins, sinkBin)
 
 		case types.EgressTypeWebsocket:
@@ -175,6 +195,12 @@ func (c *Controller) BuildPipeline() error {
 			var bins []*gstreamer.Bin
 			bins, err = builder.BuildImageBins(p, c.PipelineConfig)
 			sinkBins = append(sinkBins, bins...)
+
+		case types.EgressTypeThumbnails:
+			var sinkBin *gstreamer.Bin
+			thumbnails := c.sinks[egressType][0].(*sink.ThumbnailSink)
+			sinkBin, err = builder.BuildThumbnailBin(p, c.GetThumbnailConfig(), thumbnails.SinkCallbacks())
+			sinkBins = append(sinkBins, sinkBin)
 		}
 		if err != nil {
 			return err
@@ -245,6 +271,10 @@ func (c *Controller) Run(ctx context.Context) *livekit.EgressInfo {
 		}
 	}
 
+	if o := c.GetStreamConfig(); o != nil && o.Reconnect != nil {
+		go c.reportStreamHealth(o.Reconnect.HealthInterval)
+	}
+
 	if err := c.p.Run(); err != nil {
 		c.src.Close()
 		c.Info.SetFailed(err)
@@ -324,6 +354,36 @@ func (c *Controller) UpdateStream(ctx context.Context, req *livekit.UpdateStream
 	return errs.ToError()
 }
 
+func (c *Controller) InsertMetadata(ctx context.Context, m *config.TimedMetadata) error {
+	ctx, span := tracer.Start(ctx, "Pipeline.InsertMetadata")
+	defer span.End()
+
+	s := c.getSegmentSink()
+	if s == nil {
+		return errors.ErrNonSegmentedPipeline
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	if err := s.InsertMetadata(m); err != nil {
+		return err
+	}
+	if c.id3Src != nil && c.playing.IsBroken() {
+		c.id3Src.Push(sink.ID3Tag(m))
+	}
+
+	logger.Debugw("metadata inserted", "id", m.ID, "cue", m.Cue)
+	return nil
+}
+
+// onMetadata inserts timed metadata received by the source
+func (c *Controller) onMetadata(m *config.TimedMetadata) {
+	if err := c.InsertMetadata(context.Background(), m); err != nil {
+		logger.Warnw("failed to insert metadata", err, "id", m.ID)
+	}
+}
+
 func (c *Controller) streamFinished(ctx context.Context, stream *config.Stream) error {
 	stream.StreamInfo.Status = livekit.StreamInfo_FINISHED
 	stream.UpdateEndTime(time.Now().UnixNano())
@@ -393,6 +453,7 @@ func (c *Controller) SendEOS(ctx context.Context, reason string) {
 
 		c.Info.SetEndReason(reason)
 		logger.Debugw("stopping pipeline", "reason", reason)
+		c.interruptUploads()
 
 		switch c.Info.Status {
 		case livekit.EgressStatus_EGRESS_STARTING:
@@ -423,11 +484,37 @@ func (c *Controller) SendEOS(ctx context.Context, reason string) {
 	})
 }
 
+// interruptUploads aborts uploads which are still in progress after a grace period, so that stopping is not
+// held up by a failing backend. Uploads started after stopping, such as the final file, are not affected.
+func (c *Controller) interruptUploads() {
+	for _, si := range c.sinks {
+		for _, s := range si {
+			if u, ok := s.(interface{ Interrupt(time.Duration) }); ok {
+				u.Interrupt(uploadInterruptDelay)
+			}
+		}
+	}
+}
+
+// drainUploads waits for mirror uploads to finish
+func (c *Controller) drainUploads() {
+	for _, si := range c.sinks {
+		for _, s := range si {
+			if u, ok := s.(interface{ Drain() }); ok {
+				u.Drain()
+			}
+		}
+	}
+}
+
 func (c *Controller) sendEOS() {
 	c.eosTimer = time.AfterFunc(time.Second*30, func() {
 		c.OnError(errors.ErrPipelineFrozen)
 	})
 	go func() {
+		if c.id3Src != nil {
+			c.id3Src.EndStream()
+		}
 		c.p.SendEOS()
 		logger.Debugw("eos sent")
 	}()
@@ -451,6 +538,10 @@ func (c *Controller) Close() {
 		c.updateEndTime()
 	}
 
+	// mirror results are complete once their uploads have finished
+	c.drainUploads()
+	c.UpdateMirrorInfo()
+
 	// update status
 	if c.Info.Status == livekit.EgressStatus_EGRESS_FAILED {
 		if o := c.GetStreamConfig(); o != nil {
@@ -475,7 +566,10 @@ func (c *Controller) Close() {
 		livekit.EgressStatus_EGRESS_COMPLETE:
 		// upload manifest and add location to egress info
 		c.uploadManifest()
+		c.drainUploads()
 	}
+
+	_ = c.journal.Close()
 }
 
 func (c *Controller) startSessionLimitTimer(ctx context.Context) {
@@ -525,8 +619,9 @@ func (c *Controller) updateStartTime(startedAt int64) {
 		switch egressType {
 		case types.EgressTypeStream, types.EgressTypeWebsocket:
 			streamConfig := o[0].(*config.StreamConfig)
-			if streamConfig.OutputType == types.OutputTypeRTMP {
-				// rtmp has special start time handling
+			switch streamConfig.OutputType {
+			case types.OutputTypeRTMP, types.OutputTypeWHIP, types.OutputTypeHLS, types.OutputTypeDASH:
+				// rtmp and whip streams start once connected, and hls and dash streams once their first segment is sent
 				continue
 			}
 			streamConfig.Streams.Range(func(_, stream any) bool {
@@ -549,6 +644,7 @@ func (c *Controller) updateStartTime(startedAt int64) {
 
 	if c.Info.Status == livekit.EgressStatus_EGRESS_STARTING {
 		c.Info.UpdateStatus(livekit.EgressStatus_EGRESS_ACTIVE)
+		c.journal.SaveInfo(c.Info)
 		_, _ = c.ipcServiceClient.HandlerUpdate(context.Background(), c.Info)
 	}
 }
@@ -568,6 +664,88 @@ func (c *Controller) updateStreamStartTime(streamID string) {
 	}
 }
 
+// onStreamStarted is called by streams which connect outside of gstreamer
+func (c *Controller) onStreamStarted(name string) {
+	stream, err := c.streamBin.GetStream(name)
+	if err != nil || stream.StreamInfo.StartedAt != 0 {
+		return
+	}
+
+	logger.Debugw("stream started", "url", stream.RedactedUrl)
+	stream.StreamInfo.StartedAt = time.Now().UnixNano()
+	c.streamUpdated(context.Background())
+}
+
+// onStreamFailed is called by streams which connect outside of gstreamer
+func (c *Controller) onStreamFailed(name string, streamErr error) {
+	stream, err := c.streamBin.GetStream(name)
+	if err != nil {
+		// already removed
+		return
+	}
+
+	if err = c.streamFailed(context.Background(), stream, streamErr); err != nil {
+		c.OnError(err)
+	}
+}
+
+// reportStreamHealth samples rtmp and srt streams until the pipeline stops. Stats are published to prometheus, and
+// disconnection errors are listed on each stream's info until it recovers.
+func (c *Controller) reportStreamHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := make(map[string]bool)
+	defer func() {
+		for url := range reported {
+			c.monitor.RemoveStreamHealth(url)
+		}
+	}()
+
+	for {
+		select {
+		case <-c.stopped.Watch():
+			return
+		case <-ticker.C:
+		}
+
+		updated := false
+		sampled := make(map[string]bool)
+		for _, h := range c.streamBin.SampleHealth() {
+			stream := h.Stream
+			sampled[stream.RedactedUrl] = true
+			c.monitor.UpdateStreamHealth(stream.RedactedUrl, h.BytesAcked, h.Bitrate, h.Reconnects)
+			logger.Debugw("stream health",
+				"url", stream.RedactedUrl,
+				"bytesAcked", h.BytesAcked,
+				"bitrate", h.Bitrate,
+				"reconnects", h.Reconnects,
+				"lastError", h.LastError,
+			)
+
+			var streamErr string
+			if h.LastError != nil {
+				streamErr = h.LastError.Error()
+			}
+			if stream.StreamInfo.Status == livekit.StreamInfo_ACTIVE && stream.StreamInfo.Error != streamErr {
+				stream.StreamInfo.Error = streamErr
+				updated = true
+			}
+		}
+
+		for url := range reported {
+			if !sampled[url] {
+				c.monitor.RemoveStreamHealth(url)
+			}
+		}
+		reported = sampled
+
+		if updated {
+			c.streamUpdated(context.Background())
+		}
+	}
+}
+
 func (c *Controller) streamUpdated(ctx context.Context) {
 	c.Info.UpdatedAt = time.Now().UnixNano()
 
@@ -0,0 +1,93 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// splitInitSegment removes the leading ftyp and moov boxes from a fragmented mp4 segment.
+// If initFilepath is set, the removed boxes are written to it.
+// Returns the removed header, or nil if the segment does not start with one.
+func splitInitSegment(segmentFilepath, initFilepath string) ([]byte, error) {
+	b, err := os.ReadFile(segmentFilepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var headerSize int
+	for headerSize < len(b) {
+		boxType, boxSize, err := readBoxHeader(b[headerSize:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment %s: %w", segmentFilepath, err)
+		}
+		if boxType != "ftyp" && boxType != "moov" {
+			break
+		}
+		headerSize += boxSize
+	}
+	if headerSize == 0 {
+		return nil, nil
+	}
+
+	if initFilepath != "" {
+		if err = os.WriteFile(initFilepath, b[:headerSize], 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp := segmentFilepath + ".tmp"
+	if err = os.WriteFile(tmp, b[headerSize:], 0644); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp, segmentFilepath); err != nil {
+		return nil, err
+	}
+
+	return b[:headerSize], nil
+}
+
+func readBoxHeader(b []byte) (string, int, error) {
+	if len(b) < 8 {
+		return "", 0, fmt.Errorf("truncated box header")
+	}
+
+	size := uint64(binary.BigEndian.Uint32(b[:4]))
+	boxType := string(b[4:8])
+	switch size {
+	case 0:
+		// box extends to the end of the file
+		size = uint64(len(b))
+	case 1:
+		if len(b) < 16 {
+			return "", 0, fmt.Errorf("truncated box header")
+		}
+		size = binary.BigEndian.Uint64(b[8:16])
+		if size < 16 {
+			return "", 0, fmt.Errorf("invalid %s box size %d", boxType, size)
+		}
+	default:
+		if size < 8 {
+			return "", 0, fmt.Errorf("invalid %s box size %d", boxType, size)
+		}
+	}
+	if size > uint64(len(b)) {
+		return "", 0, fmt.Errorf("truncated %s box", boxType)
+	}
+
+	return boxType, int(size), nil
+}
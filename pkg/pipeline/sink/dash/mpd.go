@@ -0,0 +1,97 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dash
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	Xmlns                     string   `xml:"xmlns,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	AvailabilityStartTime     string   `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime               string   `xml:"publishTime,attr,omitempty"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinimumUpdatePeriod       string   `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth      string   `xml:"timeShiftBufferDepth,attr,omitempty"`
+	Period                    *period  `xml:"Period"`
+}
+
+type period struct {
+	ID            string         `xml:"id,attr"`
+	Start         string         `xml:"start,attr"`
+	AdaptationSet *adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ID               string          `xml:"id,attr"`
+	MimeType         string          `xml:"mimeType,attr"`
+	SegmentAlignment bool            `xml:"segmentAlignment,attr"`
+	StartWithSAP     int             `xml:"startWithSAP,attr"`
+	Representation   *representation `xml:"Representation"`
+}
+
+type representation struct {
+	ID          string       `xml:"id,attr"`
+	Codecs      string       `xml:"codecs,attr,omitempty"`
+	Bandwidth   int          `xml:"bandwidth,attr"`
+	SegmentList *segmentList `xml:"SegmentList"`
+}
+
+type segmentList struct {
+	Timescale       int              `xml:"timescale,attr"`
+	Initialization  *initialization  `xml:"Initialization"`
+	SegmentTimeline *segmentTimeline `xml:"SegmentTimeline"`
+	SegmentURLs     []*segmentURL    `xml:"SegmentURL"`
+}
+
+type initialization struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type segmentTimeline struct {
+	S []*timelineSegment `xml:"S"`
+}
+
+type timelineSegment struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+}
+
+type segmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// Codecs returns the RFC 6381 codecs string for the sample entries found in an init segment
+func Codecs(initSegment []byte) string {
+	var codecs []string
+	// avcC holds the profile, constraint flags and level after its version byte
+	if i := bytes.Index(initSegment, []byte("avcC")); i >= 0 && len(initSegment) >= i+8 {
+		codecs = append(codecs, fmt.Sprintf("avc1.%02x%02x%02x", initSegment[i+5], initSegment[i+6], initSegment[i+7]))
+	}
+	switch {
+	case bytes.Contains(initSegment, []byte("mp4a")):
+		codecs = append(codecs, "mp4a.40.2")
+	case bytes.Contains(initSegment, []byte("Opus")):
+		codecs = append(codecs, "opus")
+	}
+	return strings.Join(codecs, ",")
+}
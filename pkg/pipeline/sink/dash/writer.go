@@ -0,0 +1,227 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dash
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	mpdNamespace   = "urn:mpeg:dash:schema:mpd:2011"
+	mpdProfileLive = "urn:mpeg:dash:profile:isoff-live:2011"
+	mpdTimescale   = 1000
+
+	typeStatic  = "static"
+	typeDynamic = "dynamic"
+)
+
+// Representation describes the single muxed audio/video representation
+type Representation struct {
+	MimeType  string // video/mp4, or audio/mp4 for audio only
+	Bandwidth int    // bits per second
+}
+
+// MPDWriter writes a dynamic MPD while segments are appended, and a static one on Close.
+// Segments are listed individually, since timestamped segment names cannot be templated.
+type MPDWriter struct {
+	filename       string
+	targetDuration int
+	initSegment    string
+	windowSize     int           // 0 for no segment limit
+	windowDuration time.Duration // 0 for no duration limit
+	onExpired      func(filename string)
+	representation Representation
+	codecs         string
+
+	startTime time.Time
+	segments  []*segment
+}
+
+type segment struct {
+	dateTime time.Time
+	duration float64
+	filename string
+}
+
+// NewStaticMPDWriter lists every segment, and becomes static on Close
+func NewStaticMPDWriter(filename string, targetDuration int, initSegment string, representation Representation) (*MPDWriter, error) {
+	return newMPDWriter(filename, targetDuration, initSegment, 0, 0, representation)
+}
+
+// NewDynamicMPDWriter lists the last windowSize segments, and at most windowDuration of segments if set.
+// At least one segment is always listed.
+func NewDynamicMPDWriter(filename string, targetDuration int, initSegment string, windowSize int, windowDuration time.Duration, representation Representation) (*MPDWriter, error) {
+	return newMPDWriter(filename, targetDuration, initSegment, windowSize, windowDuration, representation)
+}
+
+func newMPDWriter(filename string, targetDuration int, initSegment string, windowSize int, windowDuration time.Duration, representation Representation) (*MPDWriter, error) {
+	if initSegment == "" {
+		return nil, fmt.Errorf("init segment required")
+	}
+
+	return &MPDWriter{
+		filename:       filename,
+		targetDuration: targetDuration,
+		initSegment:    initSegment,
+		windowSize:     windowSize,
+		windowDuration: windowDuration,
+		representation: representation,
+	}, nil
+}
+
+// SetCodecs sets the RFC 6381 codecs string, once known from the init segment
+func (w *MPDWriter) SetCodecs(codecs string) {
+	w.codecs = codecs
+}
+
+func (w *MPDWriter) Append(dateTime time.Time, duration float64, filename string) error {
+	if w.startTime.IsZero() {
+		w.startTime = dateTime
+	}
+
+	w.segments = append(w.segments, &segment{
+		dateTime: dateTime,
+		duration: duration,
+		filename: filename,
+	})
+	for w.windowExceeded() {
+		expired := w.segments[0]
+		w.segments = w.segments[1:]
+		if w.onExpired != nil {
+			w.onExpired(expired.filename)
+		}
+	}
+
+	return w.write(typeDynamic)
+}
+
+func (w *MPDWriter) windowExceeded() bool {
+	if len(w.segments) <= 1 {
+		return false
+	}
+	if w.windowSize > 0 && len(w.segments) > w.windowSize {
+		return true
+	}
+	if w.windowDuration > 0 {
+		var duration float64
+		for _, s := range w.segments {
+			duration += s.duration
+		}
+		return duration > w.windowDuration.Seconds()
+	}
+	return false
+}
+
+// OnExpired sets a function called with each segment removed from the window
+func (w *MPDWriter) OnExpired(f func(filename string)) {
+	w.onExpired = f
+}
+
+func (w *MPDWriter) Close() error {
+	return w.write(typeStatic)
+}
+
+func (w *MPDWriter) write(mpdType string) error {
+	b, err := w.generate(mpdType)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(w.filename, b, 0644)
+}
+
+func (w *MPDWriter) generate(mpdType string) ([]byte, error) {
+	target := formatDuration(float64(w.targetDuration))
+	m := &mpd{
+		Xmlns:         mpdNamespace,
+		Profiles:      mpdProfileLive,
+		Type:          mpdType,
+		MinBufferTime: target,
+		Period: &period{
+			ID:    "0",
+			Start: formatDuration(0),
+			AdaptationSet: &adaptationSet{
+				ID:               "0",
+				MimeType:         w.representation.MimeType,
+				SegmentAlignment: true,
+				StartWithSAP:     1,
+				Representation: &representation{
+					ID:        "0",
+					Codecs:    w.codecs,
+					Bandwidth: w.representation.Bandwidth,
+					SegmentList: &segmentList{
+						Timescale:       mpdTimescale,
+						Initialization:  &initialization{SourceURL: w.initSegment},
+						SegmentTimeline: &segmentTimeline{},
+					},
+				},
+			},
+		},
+	}
+
+	if !w.startTime.IsZero() {
+		m.AvailabilityStartTime = formatTime(w.startTime)
+	}
+
+	var end float64
+	list := m.Period.AdaptationSet.Representation.SegmentList
+	for _, s := range w.segments {
+		t := int64(math.Round(float64(s.dateTime.Sub(w.startTime)) / float64(time.Millisecond)))
+		d := int64(math.Round(s.duration * mpdTimescale))
+		list.SegmentTimeline.S = append(list.SegmentTimeline.S, &timelineSegment{T: t, D: d})
+		list.SegmentURLs = append(list.SegmentURLs, &segmentURL{Media: s.filename})
+		end = float64(t+d) / mpdTimescale
+	}
+
+	switch mpdType {
+	case typeDynamic:
+		m.MinimumUpdatePeriod = target
+		if len(w.segments) > 0 {
+			last := w.segments[len(w.segments)-1]
+			m.PublishTime = formatTime(last.dateTime.Add(time.Duration(last.duration * float64(time.Second))))
+		}
+		if w.windowDuration > 0 {
+			m.TimeShiftBufferDepth = formatDuration(w.windowDuration.Seconds())
+		} else if w.windowSize > 0 {
+			m.TimeShiftBufferDepth = formatDuration(float64(w.windowSize * w.targetDuration))
+		}
+	case typeStatic:
+		m.MediaPresentationDuration = formatDuration(end)
+	}
+
+	buf := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// formatDuration formats seconds as an xs:duration, e.g. PT6.5S
+func formatDuration(seconds float64) string {
+	s := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", seconds), "0"), ".")
+	return fmt.Sprintf("PT%sS", s)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.999Z07:00")
+}
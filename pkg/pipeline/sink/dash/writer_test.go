@@ -0,0 +1,122 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dash
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamicMPDWriter(t *testing.T) {
+	mpdName := "live.mpd"
+
+	w, err := NewDynamicMPDWriter(mpdName, 6, "playlist_init.mp4", 2, 0, Representation{MimeType: "video/mp4", Bandwidth: 4628000})
+	require.NoError(t, err)
+	w.SetCodecs("avc1.64001f,mp4a.40.2")
+
+	var expired []string
+	w.OnExpired(func(filename string) {
+		expired = append(expired, filename)
+	})
+
+	t.Cleanup(func() { _ = os.Remove(mpdName) })
+
+	now := time.Unix(0, 1683154504814142000)
+	duration := 5.994
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.Append(now, duration, fmt.Sprintf("playlist_0000%d.m4s", i)))
+		now = now.Add(time.Millisecond * 5994)
+	}
+	require.Equal(t, []string{"playlist_00000.m4s"}, expired)
+
+	b, err := os.ReadFile(mpdName)
+	require.NoError(t, err)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="dynamic" availabilityStartTime="2023-05-03T22:55:04.814Z" publishTime="2023-05-03T22:55:22.796Z" minimumUpdatePeriod="PT6S" minBufferTime="PT6S" timeShiftBufferDepth="PT12S">
+  <Period id="0" start="PT0S">
+    <AdaptationSet id="0" mimeType="video/mp4" segmentAlignment="true" startWithSAP="1">
+      <Representation id="0" codecs="avc1.64001f,mp4a.40.2" bandwidth="4628000">
+        <SegmentList timescale="1000">
+          <Initialization sourceURL="playlist_init.mp4"></Initialization>
+          <SegmentTimeline>
+            <S t="5994" d="5994"></S>
+            <S t="11988" d="5994"></S>
+          </SegmentTimeline>
+          <SegmentURL media="playlist_00001.m4s"></SegmentURL>
+          <SegmentURL media="playlist_00002.m4s"></SegmentURL>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`
+	require.Equal(t, expected, string(b))
+}
+
+func TestStaticMPDWriter(t *testing.T) {
+	mpdName := "playlist.mpd"
+
+	w, err := NewStaticMPDWriter(mpdName, 6, "playlist_init.mp4", Representation{MimeType: "audio/mp4", Bandwidth: 128000})
+	require.NoError(t, err)
+	w.SetCodecs("mp4a.40.2")
+
+	t.Cleanup(func() { _ = os.Remove(mpdName) })
+
+	now := time.Unix(0, 1683154504814142000)
+	duration := 5.994
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, w.Append(now, duration, fmt.Sprintf("playlist_0000%d.m4s", i)))
+		now = now.Add(time.Millisecond * 5994)
+	}
+	require.NoError(t, w.Close())
+
+	b, err := os.ReadFile(mpdName)
+	require.NoError(t, err)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="static" availabilityStartTime="2023-05-03T22:55:04.814Z" mediaPresentationDuration="PT11.988S" minBufferTime="PT6S">
+  <Period id="0" start="PT0S">
+    <AdaptationSet id="0" mimeType="audio/mp4" segmentAlignment="true" startWithSAP="1">
+      <Representation id="0" codecs="mp4a.40.2" bandwidth="128000">
+        <SegmentList timescale="1000">
+          <Initialization sourceURL="playlist_init.mp4"></Initialization>
+          <SegmentTimeline>
+            <S t="0" d="5994"></S>
+            <S t="5994" d="5994"></S>
+          </SegmentTimeline>
+          <SegmentURL media="playlist_00000.m4s"></SegmentURL>
+          <SegmentURL media="playlist_00001.m4s"></SegmentURL>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`
+	require.Equal(t, expected, string(b))
+}
+
+func TestCodecs(t *testing.T) {
+	avcC := append([]byte("avcC"), 0x01, 0x64, 0x00, 0x1f)
+	require.Equal(t, "avc1.64001f,mp4a.40.2", Codecs(append(append([]byte("....avc1...."), avcC...), []byte("mp4a")...)))
+	require.Equal(t, "opus", Codecs([]byte("....Opus....")))
+	require.Equal(t, "", Codecs(nil))
+}
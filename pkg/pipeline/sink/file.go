@@ -42,16 +42,24 @@ func (s *FileSink) Start() error {
 }
 
 func (s *FileSink) Close() error {
-	location, size, err := s.Upload(s.LocalFilepath, s.StorageFilepath, s.OutputType, false)
+	journalID := s.RecordPending(s.LocalFilepath, s.StorageFilepath, s.OutputType, false)
+	location, size, uploadInfo, err := s.Upload(s.LocalFilepath, s.StorageFilepath, s.OutputType, false)
 	if err != nil {
 		return err
 	}
+	s.RecordDone(journalID)
 
 	s.FileInfo.Location = location
 	s.FileInfo.Size = size
 
+	// mirror locations are known once their uploads have finished
+	s.Drain()
+	for i, m := range uploadInfo.Mirrors {
+		s.MirrorInfos[i].Location = m.Location
+	}
+
 	if s.conf.Manifest != nil {
-		s.conf.Manifest.AddFile(s.StorageFilepath, location)
+		s.conf.Manifest.AddFile(s.StorageFilepath, location, uploadInfo, s.Encryption())
 	}
 
 	return nil
@@ -63,7 +71,7 @@ func (s *FileSink) UploadManifest(filepath string) (string, bool, error) {
 	}
 
 	storagePath := path.Join(path.Dir(s.StorageFilepath), path.Base(filepath))
-	location, _, err := s.Upload(filepath, storagePath, types.OutputTypeJSON, false)
+	location, _, _, err := s.Upload(filepath, storagePath, types.OutputTypeJSON, false)
 	if err != nil {
 		return "", false, err
 	}
@@ -50,6 +50,7 @@ type ImageSink struct {
 type imageUpdate struct {
 	timestamp uint64
 	filename  string
+	journalID int64
 }
 
 func newImageSink(u *uploader.Uploader, p *config.PipelineConfig, o *config.ImageConfig, callbacks *gstreamer.Callbacks) (*ImageSink, error) {
@@ -102,17 +103,22 @@ func (s *ImageSink) handleNewImage(update *imageUpdate) error {
 		}
 		filename = newFilename
 		imageLocalPath = newImageLocalPath
+
+		journalID := s.RecordPending(imageLocalPath, path.Join(s.StorageDir, filename), s.OutputType, false)
+		s.RecordDone(update.journalID)
+		update.journalID = journalID
 	}
 
 	imageStoragePath := path.Join(s.StorageDir, filename)
 
-	location, _, err := s.Upload(imageLocalPath, imageStoragePath, s.OutputType, true)
+	location, _, uploadInfo, err := s.Upload(imageLocalPath, imageStoragePath, s.OutputType, true)
 	if err != nil {
 		return err
 	}
+	s.RecordDone(update.journalID)
 
 	if s.conf.Manifest != nil {
-		s.conf.Manifest.AddImage(imageStoragePath, ts, location)
+		s.conf.Manifest.AddImage(imageStoragePath, ts, location, uploadInfo, s.Encryption())
 	}
 
 	return nil
@@ -138,6 +144,7 @@ func (s *ImageSink) NewImage(filepath string, ts uint64) error {
 	s.createdImages <- &imageUpdate{
 		filename:  filename,
 		timestamp: ts,
+		journalID: s.RecordPending(filepath, path.Join(s.StorageDir, filename), s.OutputType, false),
 	}
 
 	return nil
@@ -156,7 +163,7 @@ func (s *ImageSink) UploadManifest(filepath string) (string, bool, error) {
 	}
 
 	storagePath := path.Join(s.StorageDir, path.Base(filepath))
-	location, _, err := s.Upload(filepath, storagePath, types.OutputTypeJSON, false)
+	location, _, _, err := s.Upload(filepath, storagePath, types.OutputTypeJSON, false)
 	if err != nil {
 		return "", false, err
 	}
@@ -0,0 +1,195 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/dash"
+	"github.com/livekit/egress/pkg/pipeline/sink/ingest"
+	"github.com/livekit/protocol/logger"
+)
+
+// IngestSink pushes hls or dash stream outputs to http ingest endpoints.
+// Segments are written once, and published to every stream url.
+type IngestSink struct {
+	*config.SegmentConfig
+	publisher *ingest.Publisher
+	callbacks *gstreamer.Callbacks
+
+	mu                    sync.Mutex
+	initialized           bool
+	startTime             time.Time
+	startRunningTime      uint64
+	openSegmentsStartTime map[string]uint64
+	initSegmentSet        bool
+
+	closedSegments chan SegmentUpdate
+	done           core.Fuse
+}
+
+func newIngestSink(p *config.PipelineConfig, o *config.StreamConfig, callbacks *gstreamer.Callbacks) *IngestSink {
+	maxPending := int(float64(p.MaxUploadQueue*60) / float64(o.Ingest.Segments.SegmentDuration))
+
+	return &IngestSink{
+		SegmentConfig: o.Ingest.Segments,
+		publisher: ingest.NewPublisher(
+			o.Ingest, dashRepresentation(p), callbacks.OnStreamStarted, callbacks.OnStreamFailed,
+		),
+		callbacks:             callbacks,
+		openSegmentsStartTime: make(map[string]uint64),
+		closedSegments:        make(chan SegmentUpdate, maxPending),
+	}
+}
+
+func (s *IngestSink) Publisher() *ingest.Publisher {
+	return s.publisher
+}
+
+func (s *IngestSink) Start() error {
+	go func() {
+		defer s.done.Break()
+		for update := range s.closedSegments {
+			if err := s.publishSegment(update); err != nil {
+				s.callbacks.OnError(err)
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *IngestSink) publishSegment(update SegmentUpdate) error {
+	localPath := path.Join(s.LocalDir, update.filename)
+
+	if s.InitSegmentFilename != "" {
+		if err := s.handleInitSegment(localPath); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	t, ok := s.openSegmentsStartTime[update.filename]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("no open segment with the name %s", update.filename)
+	}
+	delete(s.openSegmentsStartTime, update.filename)
+	segmentStartTime := s.startTime.Add(time.Duration(t - s.startRunningTime))
+	s.mu.Unlock()
+
+	duration := float64(time.Duration(update.endTime-t)) / float64(time.Second)
+	s.publisher.PublishSegment(localPath, update.filename, segmentStartTime, duration)
+	return nil
+}
+
+// handleInitSegment splits the header out of each fragmented mp4 segment, keeping the first as the init segment
+func (s *IngestSink) handleInitSegment(segmentLocalPath string) error {
+	if s.initSegmentSet {
+		_, err := splitInitSegment(segmentLocalPath, "")
+		return err
+	}
+
+	initLocalPath := path.Join(s.LocalDir, s.InitSegmentFilename)
+	header, err := splitInitSegment(segmentLocalPath, initLocalPath)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return errors.New("first segment is missing init segment")
+	}
+
+	s.publisher.SetInitSegment(initLocalPath, dash.Codecs(header))
+	s.initSegmentSet = true
+	return nil
+}
+
+func (s *IngestSink) UpdateStartDate(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.startTime = t
+}
+
+func (s *IngestSink) InsertMetadata(_ *config.TimedMetadata) error {
+	return errors.ErrNotSupported("timed metadata with stream outputs")
+}
+
+func (s *IngestSink) FragmentOpened(filepath string, startTime uint64) error {
+	if !strings.HasPrefix(filepath, s.LocalDir) {
+		return fmt.Errorf("invalid filepath")
+	}
+
+	filename := filepath[len(s.LocalDir)+1:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized {
+		s.initialized = true
+		s.startRunningTime = startTime
+	}
+
+	if _, ok := s.openSegmentsStartTime[filename]; ok {
+		return fmt.Errorf("segment with this name already started")
+	}
+
+	s.openSegmentsStartTime[filename] = startTime
+	return nil
+}
+
+func (s *IngestSink) FragmentClosed(filepath string, endTime uint64) error {
+	if !strings.HasPrefix(filepath, s.LocalDir) {
+		return fmt.Errorf("invalid filepath")
+	}
+
+	update := SegmentUpdate{
+		filename: filepath[len(s.LocalDir)+1:],
+		endTime:  endTime,
+	}
+
+	select {
+	case s.closedSegments <- update:
+		return nil
+
+	default:
+		err := errors.New("ingest job queue is full")
+		logger.Infow("failed to publish segment", "error", err)
+		return errors.ErrUploadFailed(update.filename, err)
+	}
+}
+
+func (s *IngestSink) UploadManifest(_ string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (s *IngestSink) Close() error {
+	// wait for pending segments to be queued
+	close(s.closedSegments)
+	<-s.done.Watch()
+
+	s.publisher.Close()
+	return nil
+}
@@ -0,0 +1,412 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/sink/dash"
+	"github.com/livekit/egress/pkg/pipeline/sink/m3u8"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	requestTimeout = time.Second * 10
+	retryDelay     = time.Millisecond * 500
+	maxPending     = 10 // segments queued per destination before it fails
+)
+
+// Publisher pushes segments and a live playlist to http ingest endpoints, such as YouTube's hls ingestion.
+// Each destination keeps its own playlist, so destinations can be added while the egress is running.
+type Publisher struct {
+	conf           *config.IngestConfig
+	representation dash.Representation
+	onStarted      func(name string)
+	onFailure      func(name string, err error)
+
+	mu           sync.Mutex
+	destinations map[string]*destination
+	initSegment  string
+	codecs       string
+	closed       bool
+	running      sync.WaitGroup
+}
+
+type destination struct {
+	p        *Publisher
+	name     string
+	endpoint *endpoint
+	localDir string
+
+	playlistFilename string
+	playlist         m3u8.PlaylistWriter
+
+	jobs     chan *job
+	initSent bool
+	started  bool
+	failed   core.Fuse
+}
+
+type job struct {
+	localPath string
+	filename  string
+	startTime time.Time
+	duration  float64
+	done      func()
+}
+
+// endpoint is an http(s) url. Filenames are appended to a trailing empty query parameter if there is one,
+// or to the path otherwise.
+type endpoint struct {
+	url      *url.URL
+	username string
+	password string
+}
+
+func NewPublisher(
+	conf *config.IngestConfig,
+	representation dash.Representation,
+	onStarted func(name string),
+	onFailure func(name string, err error),
+) *Publisher {
+	return &Publisher{
+		conf:           conf,
+		representation: representation,
+		onStarted:      onStarted,
+		onFailure:      onFailure,
+		destinations:   make(map[string]*destination),
+	}
+}
+
+// AddDestination starts pushing to a {hls|dash}+http(s) url, beginning with the next segment
+func (p *Publisher) AddDestination(name, rawUrl string) error {
+	e, playlistFilename, err := parseUrl(rawUrl, p.conf.Segments.OutputType)
+	if err != nil {
+		return err
+	}
+	if playlistFilename == "" {
+		playlistFilename = p.conf.Segments.LivePlaylistFilename
+	}
+
+	d := &destination{
+		p:                p,
+		name:             name,
+		endpoint:         e,
+		localDir:         path.Join(p.conf.Segments.LocalDir, name),
+		playlistFilename: playlistFilename,
+		jobs:             make(chan *job, maxPending),
+	}
+	if err = os.MkdirAll(d.localDir, 0755); err != nil {
+		return err
+	}
+	if d.playlist, err = p.newPlaylistWriter(path.Join(d.localDir, playlistFilename)); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errors.New("publisher closed")
+	}
+	if _, ok := p.destinations[name]; ok {
+		return errors.ErrInvalidInput("duplicate stream url")
+	}
+	p.destinations[name] = d
+	p.running.Add(1)
+	go d.run()
+
+	return nil
+}
+
+func (p *Publisher) newPlaylistWriter(filename string) (m3u8.PlaylistWriter, error) {
+	o := p.conf.Segments
+	if o.OutputType == types.OutputTypeDASH {
+		return dash.NewDynamicMPDWriter(filename, o.SegmentDuration, o.InitSegmentFilename, o.LiveWindowSegments, 0, p.representation)
+	}
+	return m3u8.NewLivePlaylistWriter(filename, o.SegmentDuration, m3u8.Window{Segments: o.LiveWindowSegments}, o.InitSegmentFilename)
+}
+
+// RemoveDestination stops pushing to a destination, which receives a final playlist once its queue is drained
+func (p *Publisher) RemoveDestination(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	d, ok := p.destinations[name]
+	if !ok {
+		return errors.ErrStreamNotFound(name)
+	}
+	delete(p.destinations, name)
+	close(d.jobs)
+	return nil
+}
+
+// SetInitSegment sets the fragmented mp4 header, which is sent to each destination before its first segment
+func (p *Publisher) SetInitSegment(localPath, codecs string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.initSegment = localPath
+	p.codecs = codecs
+}
+
+// PublishSegment queues a segment for every destination. The local file is deleted once each has finished with it.
+func (p *Publisher) PublishSegment(localPath, filename string, startTime time.Time, duration float64) {
+	var wg sync.WaitGroup
+
+	p.mu.Lock()
+	for _, d := range p.destinations {
+		if d.failed.IsBroken() {
+			continue
+		}
+
+		wg.Add(1)
+		select {
+		case d.jobs <- &job{
+			localPath: localPath,
+			filename:  filename,
+			startTime: startTime,
+			duration:  duration,
+			done:      wg.Done,
+		}:
+		default:
+			wg.Done()
+			d.fail(errors.New("ingest queue is full"))
+		}
+	}
+	p.mu.Unlock()
+
+	go func() {
+		wg.Wait()
+		_ = os.Remove(localPath)
+	}()
+}
+
+// Close ends every destination's playlist, after pending segments have been sent
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	p.closed = true
+	for name, d := range p.destinations {
+		delete(p.destinations, name)
+		close(d.jobs)
+	}
+	p.mu.Unlock()
+
+	p.running.Wait()
+}
+
+func (d *destination) run() {
+	defer d.p.running.Done()
+	defer func() {
+		_ = os.RemoveAll(d.localDir)
+	}()
+
+	for j := range d.jobs {
+		if !d.failed.IsBroken() {
+			if err := d.publish(j); err != nil {
+				d.fail(err)
+			}
+		}
+		j.done()
+	}
+
+	// players stop at the end of the final playlist
+	if !d.started || d.failed.IsBroken() {
+		return
+	}
+	if err := d.playlist.Close(); err != nil {
+		logger.Warnw("failed to close ingest playlist", err, "destination", d.name)
+		return
+	}
+	if err := d.push(path.Join(d.localDir, d.playlistFilename), d.playlistFilename, d.p.conf.Segments.OutputType); err != nil {
+		logger.Warnw("failed to send final ingest playlist", err, "destination", d.name)
+	}
+}
+
+func (d *destination) publish(j *job) error {
+	d.p.mu.Lock()
+	initSegment, codecs := d.p.initSegment, d.p.codecs
+	d.p.mu.Unlock()
+
+	// destinations which join late still need the init segment
+	if !d.initSent && initSegment != "" {
+		if err := d.push(initSegment, d.p.conf.Segments.InitSegmentFilename, types.OutputTypeMP4); err != nil {
+			return err
+		}
+		if mpd, ok := d.playlist.(*dash.MPDWriter); ok {
+			mpd.SetCodecs(codecs)
+		}
+		d.initSent = true
+	}
+
+	if err := d.push(j.localPath, j.filename, d.p.conf.Segments.SegmentOutputType); err != nil {
+		return err
+	}
+	if err := d.playlist.Append(j.startTime, j.duration, j.filename); err != nil {
+		return err
+	}
+	if err := d.push(path.Join(d.localDir, d.playlistFilename), d.playlistFilename, d.p.conf.Segments.OutputType); err != nil {
+		return err
+	}
+
+	if !d.started {
+		d.started = true
+		d.p.onStarted(d.name)
+	}
+	return nil
+}
+
+// push sends a file, retrying up to the configured number of attempts
+func (d *destination) push(localPath, filename string, outputType types.OutputType) error {
+	var err error
+	for i := 0; i < d.p.conf.MaxAttempts; i++ {
+		if i > 0 {
+			time.Sleep(retryDelay * time.Duration(i))
+		}
+		if err = d.send(localPath, filename, outputType); err == nil {
+			return nil
+		}
+		logger.Debugw("ingest request failed", "destination", d.name, "filename", filename, "attempt", i+1, "error", err)
+	}
+	return errors.ErrUploadFailed(filename, err)
+}
+
+func (d *destination) send(localPath, filename string, outputType types.OutputType) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, d.p.conf.Method, d.endpoint.resolve(filename), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", string(outputType))
+	for k, v := range d.p.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range d.p.conf.HostHeaders[d.endpoint.url.Hostname()] {
+		req.Header.Set(k, v)
+	}
+	d.endpoint.setAuthorization(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return fmt.Errorf("ingest endpoint returned %s", resp.Status)
+	}
+}
+
+// fail stops sending to the destination. The callback runs in the background, since it removes the destination.
+func (d *destination) fail(err error) {
+	if d.failed.IsBroken() {
+		return
+	}
+	d.failed.Break()
+	logger.Warnw("ingest destination failed", err, "destination", d.name)
+	go d.p.onFailure(d.name, err)
+}
+
+// parseUrl converts a {hls|dash}+http(s) url to an endpoint. If the url names a playlist, it is returned
+// and files are sent next to it.
+func parseUrl(rawUrl string, outputType types.OutputType) (*endpoint, string, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, "", errors.ErrInvalidUrl(rawUrl, err.Error())
+	}
+
+	prefix := "hls+"
+	if outputType == types.OutputTypeDASH {
+		prefix = "dash+"
+	}
+	scheme, ok := strings.CutPrefix(parsed.Scheme, prefix)
+	if !ok || (scheme != "http" && scheme != "https") {
+		return nil, "", errors.ErrInvalidUrl(rawUrl, "invalid scheme")
+	}
+	parsed.Scheme = scheme
+
+	e := &endpoint{url: parsed}
+	if parsed.User != nil {
+		e.username = parsed.User.Username()
+		e.password, _ = parsed.User.Password()
+		parsed.User = nil
+	}
+
+	var playlistFilename string
+	ext := string(types.FileExtensionForOutputType[outputType])
+	if base := path.Base(parsed.Path); strings.HasSuffix(base, ext) && !e.appendsQuery() {
+		playlistFilename = base
+		parsed.Path = path.Dir(parsed.Path)
+		parsed.RawPath = ""
+	}
+
+	return e, playlistFilename, nil
+}
+
+func (e *endpoint) appendsQuery() bool {
+	return strings.HasSuffix(e.url.RawQuery, "=")
+}
+
+// resolve returns the url for a file
+func (e *endpoint) resolve(filename string) string {
+	u := *e.url
+	if e.appendsQuery() {
+		u.RawQuery += url.QueryEscape(filename)
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + filename
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+// setAuthorization uses basic auth for a username and password, or a bearer token for a username alone
+func (e *endpoint) setAuthorization(req *http.Request) {
+	switch {
+	case e.password != "":
+		req.SetBasicAuth(e.username, e.password)
+	case e.username != "":
+		req.Header.Set("Authorization", "Bearer "+e.username)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/sink/m3u8"
+	"github.com/livekit/protocol/logger"
+)
+
+// appendPart concatenates a low-latency part onto the segment in progress, which is closed
+// once it reaches the segment duration. Returns the closed segment, if any.
+func (s *SegmentSink) appendPart(update SegmentUpdate) (*SegmentUpdate, error) {
+	s.segmentLock.Lock()
+	startTime, ok := s.openSegmentsStartTime[update.filename]
+	segmentStartDate := s.startTime.Add(time.Duration(startTime - s.startRunningTime))
+	s.segmentLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no open segment with the name %s", update.filename)
+	}
+
+	if s.segmentFile == nil {
+		filename := s.SegmentFilename(s.segmentIndex, segmentStartDate)
+		f, err := os.Create(path.Join(s.LocalDir, filename))
+		if err != nil {
+			return nil, err
+		}
+		s.segmentFile = f
+		s.segmentFilename = filename
+		s.segmentStartTime = startTime
+	}
+
+	part, err := os.Open(path.Join(s.LocalDir, update.filename))
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	if _, err = io.Copy(s.segmentFile, part); err != nil {
+		return nil, err
+	}
+	s.segmentEndTime = update.endTime
+
+	// parts are cut close to the part duration, so allow the last one to end early
+	minDuration := (float64(s.SegmentDuration) - s.PartDuration/2) * float64(time.Second)
+	if float64(update.endTime-s.segmentStartTime) >= minDuration {
+		return s.closeSegment()
+	}
+	return nil, nil
+}
+
+// closeSegment closes the segment in progress, returning its update. It is listed in the playlists after its last part.
+func (s *SegmentSink) closeSegment() (*SegmentUpdate, error) {
+	if s.segmentFile == nil {
+		return nil, nil
+	}
+
+	if err := s.segmentFile.Close(); err != nil {
+		return nil, err
+	}
+	s.segmentFile = nil
+	s.segmentIndex++
+
+	s.segmentLock.Lock()
+	s.openSegmentsStartTime[s.segmentFilename] = s.segmentStartTime
+	s.segmentLock.Unlock()
+
+	localPath := path.Join(s.LocalDir, s.segmentFilename)
+	return &SegmentUpdate{
+		filename:       s.segmentFilename,
+		endTime:        s.segmentEndTime,
+		uploadComplete: make(chan struct{}),
+		journalID:      s.RecordPending(localPath, path.Join(s.StorageDir, s.segmentFilename), s.outputType, false),
+	}, nil
+}
+
+// publishPart lists an uploaded part in the live playlist, with the next part as a preload hint
+func (s *SegmentSink) publishPart(dateTime time.Time, duration float64, filename string) error {
+	s.playlistLock.Lock()
+	defer s.playlistLock.Unlock()
+
+	s.partCount++
+	if err := s.lowLatencyPlaylist.AppendPart(dateTime, duration, filename, s.PartFilename(s.partCount)); err != nil {
+		return err
+	}
+
+	s.livePlaylistUploader.update()
+	return nil
+}
+
+// partServer serves low-latency outputs from local storage, holding live playlist requests until
+// the requested part is available. It listens on a unix socket, and the service forwards requests to it.
+type partServer struct {
+	server *http.Server
+}
+
+func startPartServer(o *config.SegmentConfig, playlists map[string]*m3u8.LowLatencyPlaylistWriter) (*partServer, error) {
+	// files are copied to StorageDir by the local uploader
+	dir := o.StorageDir
+	if o.StorageConfig != nil {
+		dir = path.Join(o.StorageConfig.PathPrefix, dir)
+	}
+
+	if err := os.MkdirAll(path.Dir(o.PartServerSocket), 0755); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(o.PartServerSocket)
+	l, err := net.Listen("unix", o.PartServerSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &partServer{
+		server: &http.Server{Handler: m3u8.NewBlockingReloadHandler(dir, playlists)},
+	}
+	go func() {
+		logger.Debugw(fmt.Sprintf("serving low-latency playlists on address %s", l.Addr()))
+		if err := s.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorw("low-latency playlist server failed", err)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *partServer) close() {
+	_ = s.server.Close()
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package m3u8
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CueOut = "CUE-OUT"
+	CueIn  = "CUE-IN"
+)
+
+// DateRange is timed metadata, listed by EXT-X-DATERANGE before the next segment
+type DateRange struct {
+	ID              string
+	Class           string
+	StartDate       time.Time
+	Duration        float64           // seconds, 0 if unknown
+	PlannedDuration float64           // seconds, 0 if unknown
+	Cue             string            // CueOut or CueIn, also listed with a cue tag at the segment boundary
+	SCTE35          []byte            // splice_info_section
+	Attributes      map[string]string // client attributes, named X-<NAME>
+}
+
+// attributes returns the EXT-X-DATERANGE attribute list
+func (d *DateRange) attributes() string {
+	attrs := []string{fmt.Sprintf("ID=\"%s\"", d.ID)}
+	if d.Class != "" {
+		attrs = append(attrs, fmt.Sprintf("CLASS=\"%s\"", d.Class))
+	}
+	attrs = append(attrs, fmt.Sprintf("START-DATE=\"%s\"", d.StartDate.UTC().Format("2006-01-02T15:04:05.999Z07:00")))
+	if d.Duration > 0 {
+		attrs = append(attrs, fmt.Sprintf("DURATION=%s", formatDuration(d.Duration)))
+	}
+	if d.PlannedDuration > 0 {
+		attrs = append(attrs, fmt.Sprintf("PLANNED-DURATION=%s", formatDuration(d.PlannedDuration)))
+	}
+
+	names := make([]string, 0, len(d.Attributes))
+	for name := range d.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attrs = append(attrs, fmt.Sprintf("%s=\"%s\"", name, d.Attributes[name]))
+	}
+
+	if len(d.SCTE35) > 0 {
+		name := "SCTE35-CMD"
+		switch d.Cue {
+		case CueOut:
+			name = "SCTE35-OUT"
+		case CueIn:
+			name = "SCTE35-IN"
+		}
+		attrs = append(attrs, fmt.Sprintf("%s=0x%s", name, strings.ToUpper(hex.EncodeToString(d.SCTE35))))
+	}
+
+	return strings.Join(attrs, ",")
+}
+
+func (d *DateRange) tag() string {
+	var sb strings.Builder
+	sb.WriteString("#EXT-X-DATERANGE:")
+	sb.WriteString(d.attributes())
+	sb.WriteString("\n")
+
+	switch d.Cue {
+	case CueOut:
+		duration := d.Duration
+		if duration == 0 {
+			duration = d.PlannedDuration
+		}
+		if duration > 0 {
+			sb.WriteString(fmt.Sprintf("#EXT-X-CUE-OUT:DURATION=%s\n", formatDuration(duration)))
+		} else {
+			sb.WriteString("#EXT-X-CUE-OUT\n")
+		}
+	case CueIn:
+		sb.WriteString("#EXT-X-CUE-IN\n")
+	}
+
+	return sb.String()
+}
+
+func dateRangeTags(dateRanges []*DateRange) string {
+	var sb strings.Builder
+	for _, d := range dateRanges {
+		sb.WriteString(d.tag())
+	}
+	return sb.String()
+}
+
+// ID3 returns an ID3v2.4 tag for mpeg-ts timed metadata, holding the date range attributes in a TXXX frame
+func (d *DateRange) ID3() []byte {
+	// text encoding (utf-8), description, value
+	body := []byte{0x03}
+	body = append(body, "EXT-X-DATERANGE"...)
+	body = append(body, 0)
+	body = append(body, d.attributes()...)
+
+	frame := []byte("TXXX")
+	frame = append(frame, syncsafe(len(body))...)
+	frame = append(frame, 0, 0) // flags
+	frame = append(frame, body...)
+
+	tag := []byte{'I', 'D', '3', 0x04, 0x00, 0x00}
+	tag = append(tag, syncsafe(len(frame))...)
+	return append(tag, frame...)
+}
+
+// syncsafe encodes a size using 7 bits per byte
+func syncsafe(size int) []byte {
+	return []byte{
+		byte(size>>21) & 0x7f,
+		byte(size>>14) & 0x7f,
+		byte(size>>7) & 0x7f,
+		byte(size) & 0x7f,
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package m3u8
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	KeyMethodAES128 = "AES-128"
+
+	keyServerTimeout = time.Second * 10
+)
+
+// Key is listed by EXT-X-KEY before the segments it encrypts. No IV is listed,
+// so players use each segment's media sequence number.
+type Key struct {
+	Method string
+	URI    string
+}
+
+func (k *Key) equal(o *Key) bool {
+	if k == nil || o == nil {
+		return k == o
+	}
+	return *k == *o
+}
+
+func (k *Key) tag() string {
+	if k == nil {
+		return "#EXT-X-KEY:METHOD=NONE\n"
+	}
+	return fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=\"%s\"\n", k.Method, k.URI)
+}
+
+// KeyFunc returns the key with the given index, and the uri it is served from
+type KeyFunc func(index int) (key []byte, uri string, err error)
+
+// StaticKey always returns the same key
+func StaticKey(key []byte, uri string) KeyFunc {
+	return func(_ int) ([]byte, string, error) {
+		return key, uri, nil
+	}
+}
+
+type keyServerReq struct {
+	EgressID  string `json:"egress_id"`
+	Rendition string `json:"rendition,omitempty"`
+	KeyIndex  int    `json:"key_index"`
+}
+
+type keyServerResp struct {
+	Key string `json:"key"` // base64
+	URI string `json:"uri"`
+}
+
+// KeyServer requests each key from url, which responds with the key and the uri players fetch it from
+func KeyServer(url, egressID, rendition string) KeyFunc {
+	client := &http.Client{Timeout: keyServerTimeout}
+	return func(index int) ([]byte, string, error) {
+		reqBytes, err := json.Marshal(&keyServerReq{
+			EgressID:  egressID,
+			Rendition: rendition,
+			KeyIndex:  index,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(reqBytes))
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("key request failed: %s", resp.Status)
+		}
+
+		res := &keyServerResp{}
+		if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+			return nil, "", err
+		}
+		key, err := base64.StdEncoding.DecodeString(res.Key)
+		if err != nil {
+			return nil, "", err
+		}
+		if res.URI == "" {
+			return nil, "", fmt.Errorf("key server returned no uri")
+		}
+		return key, res.URI, nil
+	}
+}
+
+// SegmentEncryptor encrypts whole segments with AES-128, using a new key every rotation segments
+type SegmentEncryptor struct {
+	rotation int
+	getKey   KeyFunc
+
+	keyIndex int
+	block    cipher.Block
+	key      *Key
+}
+
+// NewSegmentEncryptor creates a SegmentEncryptor. Keys are never rotated if rotation is 0.
+func NewSegmentEncryptor(rotation int, getKey KeyFunc) *SegmentEncryptor {
+	return &SegmentEncryptor{
+		rotation: rotation,
+		getKey:   getKey,
+		keyIndex: -1,
+	}
+}
+
+// EncryptFile encrypts the segment with the given media sequence number in place, returning its key
+func (e *SegmentEncryptor) EncryptFile(filename string, mediaSequence int) (*Key, error) {
+	if err := e.rotate(mediaSequence); err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// pkcs7 padding
+	padding := aes.BlockSize - len(b)%aes.BlockSize
+	b = append(b, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(mediaSequence))
+	cipher.NewCBCEncrypter(e.block, iv).CryptBlocks(b, b)
+
+	if err = os.WriteFile(filename, b, 0644); err != nil {
+		return nil, err
+	}
+	return e.key, nil
+}
+
+func (e *SegmentEncryptor) rotate(mediaSequence int) error {
+	keyIndex := 0
+	if e.rotation > 0 {
+		keyIndex = mediaSequence / e.rotation
+	}
+	if keyIndex == e.keyIndex {
+		return nil
+	}
+
+	key, uri, err := e.getKey(keyIndex)
+	if err != nil {
+		return err
+	}
+	if len(key) != 16 {
+		return fmt.Errorf("invalid key length %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	e.keyIndex = keyIndex
+	e.block = block
+	e.key = &Key{
+		Method: KeyMethodAES128,
+		URI:    uri,
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package m3u8
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parts are listed for the segment in progress and the last partSegmentWindow segments,
+// keeping them within three target durations of the end of the playlist
+const partSegmentWindow = 2
+
+// LowLatencyPlaylistWriter writes a live playlist listing partial segments (LL-HLS).
+// The playlist can also be served by a BlockingReloadHandler.
+type LowLatencyPlaylistWriter struct {
+	basePlaylistWriter
+
+	partTarget     float64
+	window         Window
+	canBlockReload bool
+	onExpired      func(filename string)
+
+	mu            sync.Mutex
+	updated       chan struct{}
+	mediaSeq      int
+	segments      []*llSegment
+	parts         []*part // parts of the segment in progress
+	partsDateTime time.Time
+	preloadHint   string
+	dateRanges    []*DateRange // listed before the segment in progress
+	closed        bool
+	playlist      []byte
+}
+
+type llSegment struct {
+	dateTime   time.Time
+	duration   float64
+	filename   string
+	parts      []*part
+	dateRanges []*DateRange
+}
+
+type part struct {
+	duration float64
+	filename string
+}
+
+func NewLowLatencyPlaylistWriter(filename string, targetDuration int, partTarget float64, window Window, initSegment string, canBlockReload bool) (*LowLatencyPlaylistWriter, error) {
+	p := &LowLatencyPlaylistWriter{
+		basePlaylistWriter: basePlaylistWriter{
+			filename:       filename,
+			targetDuration: targetDuration,
+			initSegment:    initSegment,
+		},
+		partTarget:     partTarget,
+		window:         window,
+		canBlockReload: canBlockReload,
+		updated:        make(chan struct{}),
+	}
+
+	p.playlist = []byte(p.generatePlaylist())
+	return p, nil
+}
+
+// AppendPart lists a part of the segment in progress. nextPart is advertised as a preload hint.
+func (p *LowLatencyPlaylistWriter) AppendPart(dateTime time.Time, duration float64, filename, nextPart string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.parts) == 0 {
+		p.partsDateTime = dateTime
+	}
+	p.parts = append(p.parts, &part{
+		duration: duration,
+		filename: filename,
+	})
+	p.preloadHint = nextPart
+
+	return p.write()
+}
+
+// Append completes the segment in progress, which replaces its parts once they fall out of the part window
+func (p *LowLatencyPlaylistWriter) Append(dateTime time.Time, duration float64, filename string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.segments = append(p.segments, &llSegment{
+		dateTime:   dateTime,
+		duration:   duration,
+		filename:   filename,
+		parts:      p.parts,
+		dateRanges: p.dateRanges,
+	})
+	p.parts = nil
+	p.dateRanges = nil
+
+	var windowDuration float64
+	for _, s := range p.segments {
+		windowDuration += s.duration
+	}
+	for p.window.exceeded(len(p.segments), windowDuration) {
+		expired := p.segments[0]
+		p.segments = p.segments[1:]
+		windowDuration -= expired.duration
+		p.mediaSeq++
+		if p.onExpired != nil {
+			for _, pt := range expired.parts {
+				p.onExpired(pt.filename)
+			}
+			p.onExpired(expired.filename)
+		}
+	}
+
+	return p.write()
+}
+
+// AddDateRange lists timed metadata before the segment in progress
+func (p *LowLatencyPlaylistWriter) AddDateRange(d *DateRange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dateRanges = append(p.dateRanges, d)
+}
+
+// OnExpired sets a function called with each segment and part removed from the window
+func (p *LowLatencyPlaylistWriter) OnExpired(f func(filename string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onExpired = f
+}
+
+func (p *LowLatencyPlaylistWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.preloadHint = ""
+	return p.write()
+}
+
+// Playlist returns the current playlist
+func (p *LowLatencyPlaylistWriter) Playlist() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.playlist
+}
+
+// Wait blocks until the playlist contains segment msn, or part of segment msn if part is not negative.
+// Returns the playlist, or false if ctx is done first.
+func (p *LowLatencyPlaylistWriter) Wait(ctx context.Context, msn, part int) ([]byte, bool) {
+	for {
+		p.mu.Lock()
+		if p.closed || p.contains(msn, part) {
+			b := p.playlist
+			p.mu.Unlock()
+			return b, true
+		}
+		updated := p.updated
+		p.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (p *LowLatencyPlaylistWriter) contains(msn, part int) bool {
+	// media sequence number of the segment in progress
+	current := p.mediaSeq + len(p.segments)
+	if msn < current {
+		return true
+	}
+	return msn == current && part >= 0 && part < len(p.parts)
+}
+
+// nextMediaSequence returns the media sequence number of the segment in progress
+func (p *LowLatencyPlaylistWriter) nextMediaSequence() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.mediaSeq + len(p.segments)
+}
+
+func (p *LowLatencyPlaylistWriter) write() error {
+	p.playlist = []byte(p.generatePlaylist())
+
+	// wake blocked requests
+	close(p.updated)
+	p.updated = make(chan struct{})
+
+	return os.WriteFile(p.filename, p.playlist, 0644)
+}
+
+func (p *LowLatencyPlaylistWriter) generatePlaylist() string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:6\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", p.targetDuration))
+	sb.WriteString("#EXT-X-SERVER-CONTROL:")
+	if p.canBlockReload {
+		sb.WriteString("CAN-BLOCK-RELOAD=YES,")
+	}
+	sb.WriteString(fmt.Sprintf("PART-HOLD-BACK=%s\n", formatDuration(p.partTarget*3)))
+	sb.WriteString(fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%s\n", formatDuration(p.partTarget)))
+	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", p.mediaSeq))
+	sb.WriteString(p.createMap())
+
+	for i, s := range p.segments {
+		sb.WriteString(dateRangeTags(s.dateRanges))
+		sb.WriteString("#EXT-X-PROGRAM-DATE-TIME:")
+		sb.WriteString(s.dateTime.UTC().Format("2006-01-02T15:04:05.999Z07:00"))
+		sb.WriteString("\n")
+		if i >= len(p.segments)-partSegmentWindow {
+			writeParts(&sb, s.parts)
+		}
+		sb.WriteString("#EXTINF:")
+		sb.WriteString(formatDuration(s.duration))
+		sb.WriteString(",\n")
+		sb.WriteString(s.filename)
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString(dateRangeTags(p.dateRanges))
+	if len(p.parts) > 0 {
+		sb.WriteString("#EXT-X-PROGRAM-DATE-TIME:")
+		sb.WriteString(p.partsDateTime.UTC().Format("2006-01-02T15:04:05.999Z07:00"))
+		sb.WriteString("\n")
+		writeParts(&sb, p.parts)
+	}
+	if p.preloadHint != "" {
+		sb.WriteString(fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", p.preloadHint))
+	}
+	if p.closed {
+		sb.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return sb.String()
+}
+
+func writeParts(sb *strings.Builder, parts []*part) {
+	// keyframes are requested at every split, so each part is independent
+	for _, pt := range parts {
+		sb.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%s,URI=\"%s\",INDEPENDENT=YES\n", formatDuration(pt.duration), pt.filename))
+	}
+}
+
+func formatDuration(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 32)
+}
+
+// BlockingReloadHandler serves files from dir. Requests for the given live playlists support
+// blocking reload, using the _HLS_msn and _HLS_part query parameters.
+type BlockingReloadHandler struct {
+	files     http.Handler
+	playlists map[string]*LowLatencyPlaylistWriter
+}
+
+// NewBlockingReloadHandler serves dir, with playlists keyed by their path relative to dir
+func NewBlockingReloadHandler(dir string, playlists map[string]*LowLatencyPlaylistWriter) *BlockingReloadHandler {
+	return &BlockingReloadHandler{
+		files:     http.FileServer(http.Dir(dir)),
+		playlists: playlists,
+	}
+}
+
+func (h *BlockingReloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := h.playlists[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+
+	b, status := h.getPlaylist(r, playlist)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(b)
+}
+
+func (h *BlockingReloadHandler) getPlaylist(r *http.Request, playlist *LowLatencyPlaylistWriter) ([]byte, int) {
+	query := r.URL.Query()
+	if !query.Has("_HLS_msn") {
+		if query.Has("_HLS_part") {
+			return nil, http.StatusBadRequest
+		}
+		return playlist.Playlist(), http.StatusOK
+	}
+
+	msn, err := strconv.Atoi(query.Get("_HLS_msn"))
+	if err != nil || msn < 0 {
+		return nil, http.StatusBadRequest
+	}
+	part := -1
+	if query.Has("_HLS_part") {
+		if part, err = strconv.Atoi(query.Get("_HLS_part")); err != nil || part < 0 {
+			return nil, http.StatusBadRequest
+		}
+	}
+
+	// requests more than two segments ahead are rejected rather than held
+	if msn > playlist.nextMediaSequence()+2 {
+		return nil, http.StatusBadRequest
+	}
+
+	// hold for up to three target durations
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Duration(playlist.targetDuration)*time.Second)
+	defer cancel()
+
+	b, ok := playlist.Wait(ctx, msn, part)
+	if !ok {
+		return nil, http.StatusServiceUnavailable
+	}
+	return b, http.StatusOK
+}
@@ -16,6 +16,7 @@ package m3u8
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -26,6 +27,13 @@ import (
 
 type PlaylistType string
 
+// RecoveredComment is written before the end tag of playlists which were ended by the service after their
+// handler exited. Unlike playlists ended by their egress, these can still be resumed.
+const RecoveredComment = "# ended by recovery"
+
+// ErrPlaylistComplete is returned when resuming a playlist which was ended by its egress
+var ErrPlaylistComplete = errors.New("playlist is complete")
+
 const (
 	PlaylistTypeLive  PlaylistType = ""
 	PlaylistTypeEvent PlaylistType = "EVENT"
@@ -36,29 +44,70 @@ type PlaylistWriter interface {
 	Close() error
 }
 
+// Window limits the segments listed by a live playlist. At least one segment is always listed.
+type Window struct {
+	Segments int           // maximum segment count, 0 for no limit
+	Duration time.Duration // maximum total duration, 0 for no limit
+}
+
+func (w Window) exceeded(segments int, duration float64) bool {
+	if segments <= 1 {
+		return false
+	}
+	return (w.Segments > 0 && segments > w.Segments) ||
+		(w.Duration > 0 && duration > w.Duration.Seconds())
+}
+
 type basePlaylistWriter struct {
 	filename       string
 	targetDuration int
+	initSegment    string // fragmented mp4 init segment, referenced by EXT-X-MAP
 }
 
 type eventPlaylistWriter struct {
 	basePlaylistWriter
+
+	key           *Key
+	writtenKey    *Key
+	discontinuity bool         // set on resumed playlists, until the next segment is appended
+	dateRanges    []*DateRange // listed before the next segment
 }
 
 type livePlaylistWriter struct {
 	basePlaylistWriter
 
-	windowSize int
-	mediaSeq   int
+	window         Window
+	windowDuration float64
+	mediaSeq       int
+	discontinuity  bool
+	discontSeq     int // discontinuities removed from the window
+	onExpired      func(filename string)
+	key            *Key
+	dateRanges     []*DateRange // listed at the end of the playlist until the next segment is appended
 
 	livePlaylistHeader   string
 	livePlaylistSegments *list.List
 }
 
+type liveSegment struct {
+	entry    string
+	duration float64
+	filename string
+	key      *Key
+
+	discontinuity bool
+	dateRanges    []*DateRange
+}
+
 func (p *basePlaylistWriter) createHeader(plType PlaylistType) string {
 	var sb strings.Builder
 	sb.WriteString("#EXTM3U\n")
-	sb.WriteString("#EXT-X-VERSION:4\n")
+	if p.initSegment != "" {
+		// EXT-X-MAP requires version 6
+		sb.WriteString("#EXT-X-VERSION:6\n")
+	} else {
+		sb.WriteString("#EXT-X-VERSION:4\n")
+	}
 	if plType != PlaylistTypeLive {
 		sb.WriteString(fmt.Sprintf("#EXT-X-PLAYLIST-TYPE:%s\n", plType))
 	}
@@ -66,11 +115,19 @@ func (p *basePlaylistWriter) createHeader(plType PlaylistType) string {
 	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", p.targetDuration))
 	if plType != PlaylistTypeLive {
 		sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+		sb.WriteString(p.createMap())
 	}
 
 	return sb.String()
 }
 
+func (p *basePlaylistWriter) createMap() string {
+	if p.initSegment == "" {
+		return ""
+	}
+	return fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", p.initSegment)
+}
+
 func (p *basePlaylistWriter) createSegmentEntry(dateTime time.Time, duration float64, filename string) string {
 	var sb strings.Builder
 
@@ -85,11 +142,12 @@ func (p *basePlaylistWriter) createSegmentEntry(dateTime time.Time, duration flo
 	return sb.String()
 }
 
-func NewEventPlaylistWriter(filename string, targetDuration int) (PlaylistWriter, error) {
+func NewEventPlaylistWriter(filename string, targetDuration int, initSegment string) (PlaylistWriter, error) {
 	p := &eventPlaylistWriter{
 		basePlaylistWriter: basePlaylistWriter{
 			filename:       filename,
 			targetDuration: targetDuration,
+			initSegment:    initSegment,
 		},
 	}
 
@@ -114,10 +172,97 @@ func (p *eventPlaylistWriter) Append(dateTime time.Time, duration float64, filen
 	}
 	defer f.Close()
 
-	_, err = f.WriteString(p.createSegmentEntry(dateTime, duration, filename))
+	entry := dateRangeTags(p.dateRanges) + p.createSegmentEntry(dateTime, duration, filename)
+	p.dateRanges = nil
+	if !p.key.equal(p.writtenKey) {
+		entry = p.key.tag() + entry
+		p.writtenKey = p.key
+	}
+	if p.discontinuity {
+		entry = "#EXT-X-DISCONTINUITY\n" + entry
+		p.discontinuity = false
+	}
+
+	_, err = f.WriteString(entry)
 	return err
 }
 
+// SetKey sets the key listed for segments appended from now on
+func (p *eventPlaylistWriter) SetKey(key *Key) {
+	p.key = key
+}
+
+// AddDateRange lists timed metadata before the next segment
+func (p *eventPlaylistWriter) AddDateRange(d *DateRange) {
+	p.dateRanges = append(p.dateRanges, d)
+}
+
+// ResumeEventPlaylistWriter continues an event playlist written by a previous egress which did not finish.
+// An end tag written by recovery is removed, and the next segment is listed after a discontinuity.
+// Returns the number of segments already listed, or ErrPlaylistComplete if the previous egress finished.
+func ResumeEventPlaylistWriter(filename string, targetDuration int, initSegment string, existing []byte) (PlaylistWriter, int, error) {
+	p := &eventPlaylistWriter{
+		basePlaylistWriter: basePlaylistWriter{
+			filename:       filename,
+			targetDuration: targetDuration,
+			initSegment:    initSegment,
+		},
+		discontinuity: true,
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(existing)), "\n")
+	if lines[0] != "#EXTM3U" {
+		return nil, 0, fmt.Errorf("invalid playlist")
+	}
+
+	var sb strings.Builder
+	var segments int
+	for i, line := range lines {
+		switch {
+		case line == RecoveredComment:
+			continue
+		case line == "#EXT-X-ENDLIST":
+			if i == 0 || lines[i-1] != RecoveredComment {
+				return nil, 0, ErrPlaylistComplete
+			}
+			continue
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			if line != fmt.Sprintf("#EXT-X-TARGETDURATION:%d", targetDuration) {
+				return nil, 0, fmt.Errorf("playlist target duration does not match")
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			segments++
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			p.writtenKey = parseKey(line)
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+
+	if err := os.WriteFile(p.filename, []byte(sb.String()), 0644); err != nil {
+		return nil, 0, err
+	}
+	return p, segments, nil
+}
+
+// parseKey returns the key listed by an EXT-X-KEY tag, or nil for METHOD=NONE
+func parseKey(line string) *Key {
+	key := &Key{}
+	for _, attr := range strings.Split(strings.TrimPrefix(line, "#EXT-X-KEY:"), ",") {
+		name, value, _ := strings.Cut(attr, "=")
+		switch name {
+		case "METHOD":
+			key.Method = value
+		case "URI":
+			key.URI = strings.Trim(value, "\"")
+		}
+	}
+	if key.Method == "NONE" {
+		return nil
+	}
+	return key
+}
+
 // Close sliding playlist and make them fixed.
 func (p *eventPlaylistWriter) Close() error {
 	f, err := os.OpenFile(p.filename, os.O_WRONLY|os.O_APPEND, fs.ModeAppend)
@@ -126,17 +271,18 @@ func (p *eventPlaylistWriter) Close() error {
 	}
 	defer f.Close()
 
-	_, err = f.WriteString("#EXT-X-ENDLIST\n")
+	_, err = f.WriteString(dateRangeTags(p.dateRanges) + "#EXT-X-ENDLIST\n")
 	return err
 }
 
-func NewLivePlaylistWriter(filename string, targetDuration int, windowSize int) (PlaylistWriter, error) {
+func NewLivePlaylistWriter(filename string, targetDuration int, window Window, initSegment string) (PlaylistWriter, error) {
 	p := &livePlaylistWriter{
 		basePlaylistWriter: basePlaylistWriter{
 			filename:       filename,
 			targetDuration: targetDuration,
+			initSegment:    initSegment,
 		},
-		windowSize:           windowSize,
+		window:               window,
 		livePlaylistSegments: list.New(),
 	}
 
@@ -152,18 +298,56 @@ func (p *livePlaylistWriter) Append(dateTime time.Time, duration float64, filena
 	}
 	defer f.Close()
 
-	segmentStr := p.createSegmentEntry(dateTime, duration, filename)
-	p.livePlaylistSegments.PushBack(segmentStr)
-
-	for p.livePlaylistSegments.Len() > p.windowSize {
-		p.livePlaylistSegments.Remove(p.livePlaylistSegments.Front())
+	p.livePlaylistSegments.PushBack(&liveSegment{
+		entry:    p.createSegmentEntry(dateTime, duration, filename),
+		duration: duration,
+		filename: filename,
+		key:      p.key,
+
+		discontinuity: p.discontinuity,
+		dateRanges:    p.dateRanges,
+	})
+	p.windowDuration += duration
+	p.discontinuity = false
+	p.dateRanges = nil
+
+	for p.window.exceeded(p.livePlaylistSegments.Len(), p.windowDuration) {
+		expired := p.livePlaylistSegments.Remove(p.livePlaylistSegments.Front()).(*liveSegment)
+		p.windowDuration -= expired.duration
 		p.mediaSeq++
+		if expired.discontinuity {
+			p.discontSeq++
+		}
+		if p.onExpired != nil {
+			p.onExpired(expired.filename)
+		}
 	}
 
 	_, err = f.WriteString(p.generatePlaylist())
 	return err
 }
 
+// OnExpired sets a function called with each segment removed from the window
+func (p *livePlaylistWriter) OnExpired(f func(filename string)) {
+	p.onExpired = f
+}
+
+// Resume continues the media sequence of a previous egress, listing the next segment after a discontinuity
+func (p *livePlaylistWriter) Resume(mediaSequence int) {
+	p.mediaSeq = mediaSequence
+	p.discontinuity = true
+}
+
+// SetKey sets the key listed for segments appended from now on
+func (p *livePlaylistWriter) SetKey(key *Key) {
+	p.key = key
+}
+
+// AddDateRange lists timed metadata before the next segment. It is removed once that segment leaves the window.
+func (p *livePlaylistWriter) AddDateRange(d *DateRange) {
+	p.dateRanges = append(p.dateRanges, d)
+}
+
 func (p *livePlaylistWriter) Close() error {
 	f, err := os.Create(p.filename)
 	if err != nil {
@@ -184,10 +368,51 @@ func (p *livePlaylistWriter) generatePlaylist() string {
 	var sb strings.Builder
 	sb.WriteString(p.livePlaylistHeader)
 	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", p.mediaSeq))
+	if p.discontSeq > 0 {
+		sb.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.discontSeq))
+	}
+	sb.WriteString(p.createMap())
+	// the key is listed again whenever it changes, starting with the first segment in the window
+	var key *Key
 	for elem := p.livePlaylistSegments.Front(); elem != nil; elem = elem.Next() {
-		segmentStr := elem.Value.(string)
-		sb.WriteString(segmentStr)
+		segment := elem.Value.(*liveSegment)
+		if segment.discontinuity {
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if !segment.key.equal(key) {
+			sb.WriteString(segment.key.tag())
+			key = segment.key
+		}
+		sb.WriteString(dateRangeTags(segment.dateRanges))
+		sb.WriteString(segment.entry)
 	}
+	sb.WriteString(dateRangeTags(p.dateRanges))
 
 	return sb.String()
 }
+
+// Variant is a rendition listed in a master playlist
+type Variant struct {
+	Bandwidth int // bits per second
+	Width     int32
+	Height    int32
+	Codecs    string
+	Filename  string // media playlist, relative to the master playlist
+}
+
+func WriteMasterPlaylist(filename string, variants []*Variant) error {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:4\n")
+	for _, v := range variants {
+		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d", v.Bandwidth, v.Width, v.Height))
+		if v.Codecs != "" {
+			sb.WriteString(fmt.Sprintf(",CODECS=\"%s\"", v.Codecs))
+		}
+		sb.WriteString("\n")
+		sb.WriteString(v.Filename)
+		sb.WriteString("\n")
+	}
+
+	return os.WriteFile(filename, []byte(sb.String()), 0644)
+}
@@ -15,8 +15,16 @@
 package m3u8
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"testing"
 	"time"
 
@@ -26,7 +34,7 @@ import (
 func TestEventPlaylistWriter(t *testing.T) {
 	playlistName := "playlist.m3u8"
 
-	w, err := NewEventPlaylistWriter(playlistName, 6)
+	w, err := NewEventPlaylistWriter(playlistName, 6, "")
 	require.NoError(t, err)
 
 	t.Cleanup(func() { _ = os.Remove(playlistName) })
@@ -51,7 +59,7 @@ func TestEventPlaylistWriter(t *testing.T) {
 func TestLivePlaylistWriter(t *testing.T) {
 	playlistName := "playlist.m3u8"
 
-	w, err := NewLivePlaylistWriter(playlistName, 6, 3)
+	w, err := NewLivePlaylistWriter(playlistName, 6, Window{Segments: 3}, "")
 	require.NoError(t, err)
 
 	t.Cleanup(func() { _ = os.Remove(playlistName) })
@@ -80,6 +88,351 @@ func TestLivePlaylistWriter(t *testing.T) {
 	b, err = os.ReadFile(playlistName)
 	require.NoError(t, err)
 
-	expected = "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:04.814Z\n#EXTINF:5.994,\nplaylist_00001.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nplaylist_00002.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:22.796Z\n#EXTINF:5.994,\nplaylist_00003.ts\n#EXT-X-ENDLIST\n"
+	expected = "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nplaylist_00001.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nplaylist_00002.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:22.796Z\n#EXTINF:5.994,\nplaylist_00003.ts\n#EXT-X-ENDLIST\n"
 	require.Equal(t, expected, string(b))
 }
+
+func TestCMAFPlaylistWriter(t *testing.T) {
+	playlistName := "playlist.m3u8"
+	livePlaylistName := "live_playlist.m3u8"
+
+	w, err := NewEventPlaylistWriter(playlistName, 6, "playlist_init.mp4")
+	require.NoError(t, err)
+	lw, err := NewLivePlaylistWriter(livePlaylistName, 6, Window{Segments: 1}, "playlist_init.mp4")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = os.Remove(playlistName)
+		_ = os.Remove(livePlaylistName)
+	})
+
+	now := time.Unix(0, 1683154504814142000)
+	duration := 5.994
+
+	for i := 0; i < 2; i++ {
+		filename := fmt.Sprintf("playlist_0000%d.m4s", i)
+		require.NoError(t, w.Append(now, duration, filename))
+		require.NoError(t, lw.Append(now, duration, filename))
+		now = now.Add(time.Millisecond * 5994)
+	}
+
+	require.NoError(t, w.Close())
+	require.NoError(t, lw.Close())
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+
+	expected := "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-MAP:URI=\"playlist_init.mp4\"\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:04.814Z\n#EXTINF:5.994,\nplaylist_00000.m4s\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nplaylist_00001.m4s\n#EXT-X-ENDLIST\n"
+	require.Equal(t, expected, string(b))
+
+	b, err = os.ReadFile(livePlaylistName)
+	require.NoError(t, err)
+
+	expected = "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-MAP:URI=\"playlist_init.mp4\"\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nplaylist_00001.m4s\n#EXT-X-ENDLIST\n"
+	require.Equal(t, expected, string(b))
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	playlistName := "playlist.m3u8"
+
+	require.NoError(t, WriteMasterPlaylist(playlistName, []*Variant{
+		{Bandwidth: 4628000, Width: 1920, Height: 1080, Codecs: "avc1.640028,mp4a.40.2", Filename: "playlist_1080p.m3u8"},
+		{Bandwidth: 1128000, Width: 640, Height: 360, Codecs: "avc1.64001e,mp4a.40.2", Filename: "playlist_360p.m3u8"},
+	}))
+
+	t.Cleanup(func() { _ = os.Remove(playlistName) })
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+
+	expected := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-STREAM-INF:BANDWIDTH=4628000,RESOLUTION=1920x1080,CODECS=\"avc1.640028,mp4a.40.2\"\nplaylist_1080p.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=1128000,RESOLUTION=640x360,CODECS=\"avc1.64001e,mp4a.40.2\"\nplaylist_360p.m3u8\n"
+	require.Equal(t, expected, string(b))
+}
+
+func TestLowLatencyPlaylistWriter(t *testing.T) {
+	playlistName := "playlist.m3u8"
+
+	w, err := NewLowLatencyPlaylistWriter(playlistName, 2, 1, Window{Segments: 3}, "", true)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = os.Remove(playlistName) })
+
+	now := time.Unix(0, 1683154504814142000)
+	require.NoError(t, w.AppendPart(now, 1, "playlist_part00000.ts", "playlist_part00001.ts"))
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+
+	header := "#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-TARGETDURATION:2\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=3.000\n#EXT-X-PART-INF:PART-TARGET=1.000\n"
+	expected := header + "#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:04.814Z\n#EXT-X-PART:DURATION=1.000,URI=\"playlist_part00000.ts\",INDEPENDENT=YES\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"playlist_part00001.ts\"\n"
+	require.Equal(t, expected, string(b))
+
+	for i := 1; i < 8; i++ {
+		require.NoError(t, w.AppendPart(now.Add(time.Duration(i)*time.Second), 1, fmt.Sprintf("playlist_part0000%d.ts", i), fmt.Sprintf("playlist_part0000%d.ts", i+1)))
+		if i%2 == 1 {
+			require.NoError(t, w.Append(now.Add(time.Duration(i-1)*time.Second), 2, fmt.Sprintf("playlist_0000%d.ts", i/2)))
+		}
+	}
+	require.NoError(t, w.AppendPart(now.Add(8*time.Second), 1, "playlist_part00008.ts", "playlist_part00009.ts"))
+	require.NoError(t, w.Close())
+
+	b, err = os.ReadFile(playlistName)
+	require.NoError(t, err)
+
+	expected = header + "#EXT-X-MEDIA-SEQUENCE:1\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:06.814Z\n#EXTINF:2.000,\nplaylist_00001.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:08.814Z\n#EXT-X-PART:DURATION=1.000,URI=\"playlist_part00004.ts\",INDEPENDENT=YES\n#EXT-X-PART:DURATION=1.000,URI=\"playlist_part00005.ts\",INDEPENDENT=YES\n#EXTINF:2.000,\nplaylist_00002.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.814Z\n#EXT-X-PART:DURATION=1.000,URI=\"playlist_part00006.ts\",INDEPENDENT=YES\n#EXT-X-PART:DURATION=1.000,URI=\"playlist_part00007.ts\",INDEPENDENT=YES\n#EXTINF:2.000,\nplaylist_00003.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:12.814Z\n#EXT-X-PART:DURATION=1.000,URI=\"playlist_part00008.ts\",INDEPENDENT=YES\n" +
+		"#EXT-X-ENDLIST\n"
+	require.Equal(t, expected, string(b))
+}
+
+func TestBlockingReloadHandler(t *testing.T) {
+	playlistName := "playlist.m3u8"
+
+	w, err := NewLowLatencyPlaylistWriter(playlistName, 1, 0.5, Window{Segments: 3}, "", true)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = os.Remove(playlistName) })
+
+	h := NewBlockingReloadHandler(t.TempDir(), map[string]*LowLatencyPlaylistWriter{playlistName: w})
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+playlistName+query, nil))
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, get("").Code)
+	require.Equal(t, http.StatusBadRequest, get("?_HLS_part=0").Code)
+	require.Equal(t, http.StatusBadRequest, get("?_HLS_msn=3").Code)
+
+	// held until the part is listed
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- get("?_HLS_msn=0&_HLS_part=0")
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, w.AppendPart(time.Now(), 0.5, "playlist_part00000.ts", "playlist_part00001.ts"))
+
+	select {
+	case rec := <-done:
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "playlist_part00000.ts")
+	case <-time.After(time.Second):
+		t.Fatal("blocking reload not released")
+	}
+
+	// segment 0 is not complete, and the request times out after three target durations
+	require.Equal(t, http.StatusServiceUnavailable, get("?_HLS_msn=0").Code)
+}
+
+func TestLivePlaylistWindowDuration(t *testing.T) {
+	playlistName := "playlist.m3u8"
+
+	w, err := NewLivePlaylistWriter(playlistName, 6, Window{Duration: 15 * time.Second}, "")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = os.Remove(playlistName) })
+
+	var expired []string
+	w.(*livePlaylistWriter).OnExpired(func(filename string) {
+		expired = append(expired, filename)
+	})
+
+	now := time.Unix(0, 1683154504814142000)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, w.Append(now, 5, fmt.Sprintf("playlist_0000%d.ts", i)))
+		now = now.Add(time.Second * 5)
+	}
+
+	// 15s holds three segments
+	require.Equal(t, []string{"playlist_00000.ts", "playlist_00001.ts"}, expired)
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "#EXT-X-MEDIA-SEQUENCE:2\n")
+	require.NotContains(t, string(b), "playlist_00001.ts")
+	require.Contains(t, string(b), "playlist_00002.ts")
+}
+
+func TestSegmentEncryption(t *testing.T) {
+	dir := t.TempDir()
+	playlistName := path.Join(dir, "live.m3u8")
+
+	var requests []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &keyServerReq{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		require.Equal(t, "egress_ID", req.EgressID)
+		requests = append(requests, req.KeyIndex)
+
+		key := bytes.Repeat([]byte{byte(req.KeyIndex)}, 16)
+		_ = json.NewEncoder(w).Encode(&keyServerResp{
+			Key: base64.StdEncoding.EncodeToString(key),
+			URI: fmt.Sprintf("https://keys.example.com/%d", req.KeyIndex),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	w, err := NewLivePlaylistWriter(playlistName, 6, Window{Segments: 2}, "")
+	require.NoError(t, err)
+
+	e := NewSegmentEncryptor(2, KeyServer(server.URL, "egress_ID", ""))
+	now := time.Unix(0, 1683154504814142000)
+	for i := 0; i < 3; i++ {
+		filename := fmt.Sprintf("live_0000%d.ts", i)
+		plaintext := []byte(fmt.Sprintf("segment %d", i))
+		require.NoError(t, os.WriteFile(path.Join(dir, filename), plaintext, 0644))
+
+		key, err := e.EncryptFile(path.Join(dir, filename), i)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("https://keys.example.com/%d", i/2), key.URI)
+
+		// decrypt using the media sequence number as the iv
+		b, err := os.ReadFile(path.Join(dir, filename))
+		require.NoError(t, err)
+		block, err := aes.NewCipher(bytes.Repeat([]byte{byte(i / 2)}, 16))
+		require.NoError(t, err)
+		iv := make([]byte, aes.BlockSize)
+		iv[aes.BlockSize-1] = byte(i)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(b, b)
+		require.Equal(t, plaintext, b[:len(b)-int(b[len(b)-1])])
+
+		w.(interface{ SetKey(*Key) }).SetKey(key)
+		require.NoError(t, w.Append(now, 5.994, filename))
+		now = now.Add(time.Millisecond * 5994)
+	}
+	require.Equal(t, []int{0, 1}, requests)
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+
+	expected := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/0\"\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nlive_00001.ts\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/1\"\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nlive_00002.ts\n"
+	require.Equal(t, expected, string(b))
+}
+
+func TestResumePlaylistWriters(t *testing.T) {
+	dir := t.TempDir()
+	playlistName := path.Join(dir, "playlist.m3u8")
+	livePlaylistName := path.Join(dir, "live.m3u8")
+
+	w, err := NewEventPlaylistWriter(playlistName, 6, "")
+	require.NoError(t, err)
+
+	now := time.Unix(0, 1683154504814142000)
+	duration := 5.994
+	for i := 0; i < 2; i++ {
+		require.NoError(t, w.Append(now, duration, fmt.Sprintf("playlist_0000%d.ts", i)))
+		now = now.Add(time.Millisecond * 5994)
+	}
+	existing, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+
+	// playlists ended by their egress are not resumed, unlike those ended by recovery
+	require.NoError(t, w.Close())
+	complete, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+	_, _, err = ResumeEventPlaylistWriter(playlistName, 6, "", complete)
+	require.ErrorIs(t, err, ErrPlaylistComplete)
+	_, segments, err := ResumeEventPlaylistWriter(playlistName, 6, "", append(existing, RecoveredComment+"\n#EXT-X-ENDLIST\n"...))
+	require.NoError(t, err)
+	require.Equal(t, 2, segments)
+
+	_, _, err = ResumeEventPlaylistWriter(playlistName, 4, "", existing)
+	require.Error(t, err)
+
+	w, segments, err = ResumeEventPlaylistWriter(playlistName, 6, "", existing)
+	require.NoError(t, err)
+	require.Equal(t, 2, segments)
+
+	live, err := NewLivePlaylistWriter(livePlaylistName, 6, Window{Segments: 2}, "")
+	require.NoError(t, err)
+	live.(*livePlaylistWriter).Resume(segments)
+
+	for i := 2; i < 5; i++ {
+		filename := fmt.Sprintf("playlist_0000%d.ts", i)
+		require.NoError(t, w.Append(now, duration, filename))
+		require.NoError(t, live.Append(now, duration, filename))
+		now = now.Add(time.Millisecond * 5994)
+
+		if i == 3 {
+			b, err := os.ReadFile(livePlaylistName)
+			require.NoError(t, err)
+			expected := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:2\n#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nplaylist_00002.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:22.796Z\n#EXTINF:5.994,\nplaylist_00003.ts\n"
+			require.Equal(t, expected, string(b))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+	expected := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:04.814Z\n#EXTINF:5.994,\nplaylist_00000.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nplaylist_00001.ts\n#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nplaylist_00002.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:22.796Z\n#EXTINF:5.994,\nplaylist_00003.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:28.79Z\n#EXTINF:5.994,\nplaylist_00004.ts\n#EXT-X-ENDLIST\n"
+	require.Equal(t, expected, string(b))
+
+	b, err = os.ReadFile(livePlaylistName)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "#EXT-X-MEDIA-SEQUENCE:3\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n")
+	require.NotContains(t, string(b), "#EXT-X-DISCONTINUITY\n")
+}
+
+func TestDateRange(t *testing.T) {
+	dir := t.TempDir()
+	playlistName := path.Join(dir, "playlist.m3u8")
+	livePlaylistName := path.Join(dir, "live.m3u8")
+
+	w, err := NewEventPlaylistWriter(playlistName, 6, "")
+	require.NoError(t, err)
+	live, err := NewLivePlaylistWriter(livePlaylistName, 6, Window{Segments: 2}, "")
+	require.NoError(t, err)
+
+	now := time.Unix(0, 1683154504814142000)
+	duration := 5.994
+
+	cueOut := &DateRange{
+		ID:              "ad-1",
+		Class:           "com.example.ad",
+		StartDate:       now.Add(time.Second),
+		PlannedDuration: 30,
+		Cue:             CueOut,
+		SCTE35:          []byte{0xfc, 0x30, 0x11},
+		Attributes:      map[string]string{"X-CAMPAIGN": "spring", "X-AD-ID": "42"},
+	}
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			w.(*eventPlaylistWriter).AddDateRange(cueOut)
+			live.(*livePlaylistWriter).AddDateRange(cueOut)
+		}
+		filename := fmt.Sprintf("playlist_0000%d.ts", i)
+		require.NoError(t, w.Append(now, duration, filename))
+		require.NoError(t, live.Append(now, duration, filename))
+		now = now.Add(time.Millisecond * 5994)
+	}
+
+	cueIn := &DateRange{ID: "ad-1-end", StartDate: now, Cue: CueIn}
+	w.(*eventPlaylistWriter).AddDateRange(cueIn)
+	live.(*livePlaylistWriter).AddDateRange(cueIn)
+	require.NoError(t, w.Close())
+	require.NoError(t, live.Close())
+
+	dateRange := "#EXT-X-DATERANGE:ID=\"ad-1\",CLASS=\"com.example.ad\",START-DATE=\"2023-05-03T22:55:05.814Z\",PLANNED-DURATION=30.000,X-AD-ID=\"42\",X-CAMPAIGN=\"spring\",SCTE35-OUT=0xFC3011\n#EXT-X-CUE-OUT:DURATION=30.000\n"
+	end := "#EXT-X-DATERANGE:ID=\"ad-1-end\",START-DATE=\"2023-05-03T22:55:22.796Z\"\n#EXT-X-CUE-IN\n"
+
+	b, err := os.ReadFile(playlistName)
+	require.NoError(t, err)
+	expected := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:04.814Z\n#EXTINF:5.994,\nplaylist_00000.ts\n" + dateRange + "#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nplaylist_00001.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nplaylist_00002.ts\n" + end + "#EXT-X-ENDLIST\n"
+	require.Equal(t, expected, string(b))
+
+	b, err = os.ReadFile(livePlaylistName)
+	require.NoError(t, err)
+	expected = "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-ALLOW-CACHE:NO\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n" + dateRange + "#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:10.808Z\n#EXTINF:5.994,\nplaylist_00001.ts\n#EXT-X-PROGRAM-DATE-TIME:2023-05-03T22:55:16.802Z\n#EXTINF:5.994,\nplaylist_00002.ts\n" + end + "#EXT-X-ENDLIST\n"
+	require.Equal(t, expected, string(b))
+
+	tag := cueIn.ID3()
+	require.Equal(t, []byte("ID3\x04\x00\x00"), tag[:6])
+	require.Equal(t, []byte{0, 0, 0, byte(len(tag) - 10)}, tag[6:10])
+	require.Equal(t, []byte("TXXX"), tag[10:14])
+	require.Equal(t, []byte{0, 0, 0, byte(len(tag) - 20)}, tag[14:18])
+	require.Equal(t, "\x03EXT-X-DATERANGE\x00ID=\"ad-1-end\",START-DATE=\"2023-05-03T22:55:22.796Z\"", string(tag[20:]))
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+const playlistRetryInterval = time.Second * 5
+
+// playlistUploader decides when a playlist is uploaded after each update. Failed uploads are retried in
+// the background, and storage keeps the last uploaded version until one succeeds.
+type playlistUploader struct {
+	name            string
+	policy          *config.PlaylistUploadConfig // nil for the default policy
+	live            bool                         // live playlists are uploaded with every update
+	segmentDuration int
+	upload          func() error
+	lock            *sync.Mutex // held by callers, and by background uploads
+
+	updates    int // updates since the last upload
+	total      int
+	lastUpload time.Time
+	staleSince atomic.Int64 // oldest update not yet uploaded, in unix nanoseconds. 0 when up to date
+	timer      *time.Timer
+	closed     bool
+}
+
+func newPlaylistUploader(name string, o *config.SegmentConfig, live bool, upload func() error, lock *sync.Mutex) *playlistUploader {
+	return &playlistUploader{
+		name:            name,
+		policy:          o.PlaylistUpload,
+		live:            live,
+		segmentDuration: o.SegmentDuration,
+		upload:          upload,
+		lock:            lock,
+		lastUpload:      time.Now(),
+	}
+}
+
+// update is called with the lock held, after the local playlist has been written
+func (u *playlistUploader) update() {
+	now := time.Now()
+	u.updates++
+	u.total++
+	u.staleSince.CompareAndSwap(0, now.UnixNano())
+
+	due, wait := u.due(now)
+	switch {
+	case due:
+		u.tryUpload()
+	case wait > 0:
+		u.schedule(wait)
+	}
+}
+
+// due returns whether the playlist should be uploaded now, otherwise how long to wait before uploading it
+func (u *playlistUploader) due(now time.Time) (bool, time.Duration) {
+	if u.live || u.policy == nil {
+		return u.live || shouldUploadPlaylist(u.total, u.segmentDuration), 0
+	}
+
+	switch u.policy.Policy {
+	case config.PlaylistUploadSegments:
+		return u.updates >= u.policy.Segments, 0
+	case config.PlaylistUploadInterval:
+		elapsed := now.Sub(u.lastUpload)
+		return elapsed >= u.policy.Interval, u.policy.Interval - elapsed
+	default:
+		return true, 0
+	}
+}
+
+// Each segment adds about 100 bytes in the playlist, and long playlists can get very large.
+// Uploads every N segments, where N is the number of hours, with a minimum frequency of once per minute
+func shouldUploadPlaylist(segmentCount, segmentDuration int) bool {
+	segmentsPerHour := 3600 / segmentDuration
+	frequency := min(segmentCount/segmentsPerHour, segmentsPerHour/60)
+	return segmentCount < segmentsPerHour || segmentCount%frequency == 0
+}
+
+func (u *playlistUploader) tryUpload() {
+	if err := u.upload(); err != nil {
+		logger.Warnw("failed to upload playlist, retrying", err, "playlist", u.name)
+		u.schedule(playlistRetryInterval)
+		return
+	}
+
+	u.updates = 0
+	u.lastUpload = time.Now()
+	u.staleSince.Store(0)
+	if u.timer != nil {
+		u.timer.Stop()
+		u.timer = nil
+	}
+}
+
+// schedule uploads the playlist in the background, unless an upload is already scheduled
+func (u *playlistUploader) schedule(wait time.Duration) {
+	if u.timer != nil || u.closed {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(wait, func() {
+		u.lock.Lock()
+		defer u.lock.Unlock()
+
+		if u.timer != timer {
+			// stopped after firing
+			return
+		}
+		u.timer = nil
+		if !u.closed && u.staleSince.Load() != 0 {
+			u.tryUpload()
+		}
+	})
+	u.timer = timer
+}
+
+// flush is called with the lock held, once the playlist is complete. Background uploads are cancelled.
+func (u *playlistUploader) flush() error {
+	u.closed = true
+	if u.timer != nil {
+		u.timer.Stop()
+		u.timer = nil
+	}
+
+	if err := u.upload(); err != nil {
+		return err
+	}
+	u.staleSince.Store(0)
+	return nil
+}
+
+// staleness returns how long the uploaded playlist has been out of date, in seconds
+func (u *playlistUploader) staleness() float64 {
+	if u == nil {
+		return 0
+	}
+	staleSince := u.staleSince.Load()
+	if staleSince == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, staleSince)).Seconds()
+}
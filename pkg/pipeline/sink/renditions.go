@@ -0,0 +1,304 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/m3u8"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/egress/pkg/stats"
+	"github.com/livekit/egress/pkg/types"
+)
+
+// MultiRenditionSegmentSink runs a segment sink per rendition, and writes master playlists listing them
+type MultiRenditionSegmentSink struct {
+	*uploader.Uploader
+
+	*config.SegmentConfig
+	conf             *config.PipelineConfig
+	manifestPlaylist *config.Playlist
+	renditions       []*SegmentSink
+	partServer       *partServer
+
+	playlistJournalID     int64
+	livePlaylistJournalID int64
+
+	// mirror results of the latest master playlist uploads
+	playlistMirrors     []*config.MirrorUpload
+	livePlaylistMirrors []*config.MirrorUpload
+}
+
+func newMultiRenditionSegmentSink(u *uploader.Uploader, p *config.PipelineConfig, o *config.SegmentConfig, callbacks *gstreamer.Callbacks, monitor *stats.HandlerMonitor) (*MultiRenditionSegmentSink, error) {
+	s := &MultiRenditionSegmentSink{
+		Uploader:      u,
+		SegmentConfig: o,
+		conf:          p,
+	}
+
+	var variants, liveVariants []*m3u8.Variant
+	for _, r := range o.Renditions {
+		rs, err := newSegmentSink(u, p, r, callbacks, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.renditions = append(s.renditions, rs)
+
+		variant := &m3u8.Variant{
+			Bandwidth: renditionBandwidth(p, r.Rendition),
+			Width:     r.Rendition.Width,
+			Height:    r.Rendition.Height,
+			Codecs:    renditionCodecs(p, r.Rendition),
+			Filename:  r.PlaylistFilename,
+		}
+		variants = append(variants, variant)
+		if r.LivePlaylistFilename != "" {
+			liveVariant := *variant
+			liveVariant.Filename = r.LivePlaylistFilename
+			liveVariants = append(liveVariants, &liveVariant)
+		}
+	}
+
+	// master playlists do not change, so they are written once
+	if o.PlaylistFilename != "" {
+		if err := m3u8.WriteMasterPlaylist(path.Join(o.LocalDir, o.PlaylistFilename), variants); err != nil {
+			return nil, err
+		}
+	}
+	if o.LivePlaylistFilename != "" {
+		if err := m3u8.WriteMasterPlaylist(path.Join(o.LocalDir, o.LivePlaylistFilename), liveVariants); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Manifest != nil {
+		s.manifestPlaylist = p.Manifest.AddPlaylist(u.Encryption())
+		s.manifestPlaylist.Master = true
+	}
+
+	// master playlists are complete once written, so recovery uploads them as they are
+	if o.PlaylistFilename != "" {
+		s.playlistJournalID = u.RecordPending(
+			path.Join(o.LocalDir, o.PlaylistFilename), path.Join(o.StorageDir, o.PlaylistFilename), o.OutputType, false,
+		)
+	}
+	if o.LivePlaylistFilename != "" {
+		s.livePlaylistJournalID = u.RecordPending(
+			path.Join(o.LocalDir, o.LivePlaylistFilename), path.Join(o.StorageDir, o.LivePlaylistFilename), o.OutputType, false,
+		)
+	}
+
+	if monitor != nil {
+		monitor.RegisterPlaylistChannelSizeGauge(p.NodeID, p.ClusterID, p.Info.EgressId,
+			func() float64 {
+				var size int
+				for _, rs := range s.renditions {
+					size += len(rs.playlistUpdates)
+				}
+				return float64(size)
+			})
+		monitor.RegisterSegmentsChannelSizeGauge(p.NodeID, p.ClusterID, p.Info.EgressId,
+			func() float64 {
+				var size int
+				for _, rs := range s.renditions {
+					size += len(rs.closedSegments)
+				}
+				return float64(size)
+			})
+		monitor.RegisterPlaylistStalenessGauge(p.NodeID, p.ClusterID, p.Info.EgressId,
+			func() float64 {
+				var staleness float64
+				for _, rs := range s.renditions {
+					staleness = max(staleness, rs.playlistStaleness())
+				}
+				return staleness
+			})
+	}
+
+	return s, nil
+}
+
+// renditionBandwidth returns the peak bitrate used for the rendition in master playlists, in bits per second
+func renditionBandwidth(p *config.PipelineConfig, r *config.RenditionConfig) int {
+	bandwidth := int(r.VideoBitrate)
+	if p.AudioEnabled {
+		bandwidth += int(p.AudioBitrate)
+	}
+	return bandwidth * 1000
+}
+
+// renditionCodecs returns the RFC 6381 codecs string for the rendition, matching the level set on its encoder
+func renditionCodecs(p *config.PipelineConfig, r *config.RenditionConfig) string {
+	var profile string
+	switch p.VideoProfile {
+	case types.ProfileBaseline:
+		profile = "42e0"
+	case types.ProfileMain:
+		profile = "4d40"
+	default:
+		profile = "6400"
+	}
+
+	codecs := fmt.Sprintf("avc1.%s%02x", profile, config.H264Level(r.Width, r.Height, p.Framerate))
+	if p.AudioEnabled {
+		codecs += ",mp4a.40.2"
+	}
+	return codecs
+}
+
+func (s *MultiRenditionSegmentSink) Start() error {
+	if s.PartServerSocket != "" {
+		playlists := make(map[string]*m3u8.LowLatencyPlaylistWriter)
+		for _, rs := range s.renditions {
+			playlists[rs.LivePlaylistFilename] = rs.lowLatencyPlaylist
+		}
+		ps, err := startPartServer(s.SegmentConfig, playlists)
+		if err != nil {
+			return err
+		}
+		s.partServer = ps
+	}
+
+	for _, rs := range s.renditions {
+		if err := rs.Start(); err != nil {
+			return err
+		}
+	}
+
+	// ignore master playlist upload failures until close
+	_ = s.uploadMasterPlaylists()
+	return nil
+}
+
+func (s *MultiRenditionSegmentSink) uploadMasterPlaylists() error {
+	if s.PlaylistFilename != "" {
+		location, _, uploadInfo, err := s.Upload(
+			path.Join(s.LocalDir, s.PlaylistFilename), path.Join(s.StorageDir, s.PlaylistFilename), s.OutputType, false,
+		)
+		if err != nil {
+			return err
+		}
+		s.SegmentsInfo.PlaylistLocation = location
+		s.playlistMirrors = uploadInfo.Mirrors
+		if s.manifestPlaylist != nil {
+			s.manifestPlaylist.UpdateLocation(path.Join(s.StorageDir, s.PlaylistFilename), location)
+		}
+	}
+
+	if s.LivePlaylistFilename != "" {
+		location, _, uploadInfo, err := s.Upload(
+			path.Join(s.LocalDir, s.LivePlaylistFilename), path.Join(s.StorageDir, s.LivePlaylistFilename), s.OutputType, false,
+		)
+		if err != nil {
+			return err
+		}
+		s.SegmentsInfo.LivePlaylistLocation = location
+		s.livePlaylistMirrors = uploadInfo.Mirrors
+		// live only outputs list the live master in the manifest
+		if s.manifestPlaylist != nil && s.PlaylistFilename == "" {
+			s.manifestPlaylist.UpdateLocation(path.Join(s.StorageDir, s.LivePlaylistFilename), location)
+		}
+	}
+
+	return nil
+}
+
+func (s *MultiRenditionSegmentSink) UpdateStartDate(t time.Time) {
+	// renditions share a start date, so that program date times line up
+	for _, rs := range s.renditions {
+		rs.UpdateStartDate(t)
+	}
+}
+
+func (s *MultiRenditionSegmentSink) InsertMetadata(m *config.TimedMetadata) error {
+	for _, rs := range s.renditions {
+		if err := rs.InsertMetadata(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MultiRenditionSegmentSink) FragmentOpened(filepath string, startTime uint64) error {
+	rs, err := s.getRendition(filepath)
+	if err != nil {
+		return err
+	}
+	return rs.FragmentOpened(filepath, startTime)
+}
+
+func (s *MultiRenditionSegmentSink) FragmentClosed(filepath string, endTime uint64) error {
+	rs, err := s.getRendition(filepath)
+	if err != nil {
+		return err
+	}
+	return rs.FragmentClosed(filepath, endTime)
+}
+
+// getRendition matches the longest segment prefix, since rendition names may prefix one another
+func (s *MultiRenditionSegmentSink) getRendition(filepath string) (*SegmentSink, error) {
+	if !strings.HasPrefix(filepath, s.LocalDir) {
+		return nil, fmt.Errorf("invalid filepath")
+	}
+	filename := filepath[len(s.LocalDir)+1:]
+
+	var match *SegmentSink
+	for _, rs := range s.renditions {
+		if strings.HasPrefix(filename, rs.SegmentPrefix+"_") &&
+			(match == nil || len(rs.SegmentPrefix) > len(match.SegmentPrefix)) {
+			match = rs
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no rendition for segment %s", filename)
+	}
+	return match, nil
+}
+
+func (s *MultiRenditionSegmentSink) Close() error {
+	if s.partServer != nil {
+		defer s.partServer.close()
+	}
+
+	for _, rs := range s.renditions {
+		if err := rs.Close(); err != nil {
+			return err
+		}
+
+		rs.infoLock.Lock()
+		s.SegmentsInfo.SegmentCount += rs.SegmentsInfo.SegmentCount
+		s.SegmentsInfo.Size += rs.SegmentsInfo.Size
+		rs.infoLock.Unlock()
+	}
+
+	if err := s.uploadMasterPlaylists(); err != nil {
+		return err
+	}
+	s.RecordDone(s.playlistJournalID)
+	s.RecordDone(s.livePlaylistJournalID)
+	updateMirrorLocations(s.Uploader, s.MirrorInfos, s.playlistMirrors, s.livePlaylistMirrors)
+
+	return nil
+}
+
+func (s *MultiRenditionSegmentSink) UploadManifest(filepath string) (string, bool, error) {
+	// renditions share the output's storage and manifest settings
+	return s.renditions[0].UploadManifest(filepath)
+}
@@ -0,0 +1,571 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtsp
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frostbyte73/core"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils"
+)
+
+const (
+	defaultPort       = "554"
+	maxPendingPackets = 512
+	maxPortAttempts   = 10
+)
+
+// Server hosts a single RTSP stream for players to pull, carrying RTP packets with an MPEG-TS payload (RFC 2250).
+// Urls are of the form rtsp://({username}:{password}@){host}:{port}/{path}?mode=server, where host and port are
+// the address to listen on. With credentials, players must use basic auth.
+type Server struct {
+	path           string
+	authorization  string // expected Authorization header, empty without credentials
+	sessionTimeout time.Duration
+	listener       net.Listener
+
+	mu       sync.RWMutex
+	sessions map[string]*session
+
+	closed core.Fuse
+}
+
+type session struct {
+	id      string
+	conn    *conn
+	udp     *net.UDPConn // rtp, nil when interleaved with the rtsp connection
+	rtcp    *net.UDPConn // receives player reports on the port after udp
+	client  *net.UDPAddr
+	channel byte
+	playing bool
+
+	packets chan []byte
+	done    core.Fuse
+}
+
+type conn struct {
+	net.Conn
+	mu      sync.Mutex // guards writes, which are shared between responses and interleaved packets
+	timeout time.Duration
+}
+
+type request struct {
+	method  string
+	url     string
+	headers map[string]string
+}
+
+// NewServer starts listening for players. Connections are closed once idle for the session timeout.
+func NewServer(rawUrl string, sessionTimeout time.Duration) (*Server, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, errors.ErrInvalidUrl(rawUrl, err.Error())
+	}
+	if parsed.Scheme != "rtsp" {
+		return nil, errors.ErrInvalidUrl(rawUrl, "invalid scheme")
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(parsed.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		path:           strings.TrimSuffix(parsed.Path, "/"),
+		sessionTimeout: sessionTimeout,
+		listener:       listener,
+		sessions:       make(map[string]*session),
+	}
+	if parsed.User != nil {
+		password, _ := parsed.User.Password()
+		s.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(parsed.User.Username()+":"+password))
+	}
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) accept() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			if !s.closed.IsBroken() {
+				logger.Warnw("rtsp server failed to accept", err)
+			}
+			return
+		}
+		go s.handleConn(&conn{Conn: c, timeout: s.sessionTimeout})
+	}
+}
+
+func (s *Server) handleConn(c *conn) {
+	sessions := make(map[string]*session)
+	defer func() {
+		for _, sess := range sessions {
+			s.removeSession(sess)
+		}
+		_ = c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		// players keep sessions alive with requests or rtcp
+		if err := c.SetReadDeadline(time.Now().Add(s.sessionTimeout)); err != nil {
+			return
+		}
+		req, err := readRequest(r)
+		if err != nil {
+			if err != io.EOF && !s.closed.IsBroken() {
+				logger.Debugw("rtsp connection closed", "error", err)
+			}
+			return
+		}
+		if req == nil {
+			// interleaved rtcp from the player
+			continue
+		}
+
+		headers, status := s.handleRequest(c, req, sessions)
+		if err = c.writeResponse(req, status, headers); err != nil {
+			return
+		}
+		if req.method == "TEARDOWN" {
+			return
+		}
+	}
+}
+
+func (s *Server) handleRequest(c *conn, req *request, sessions map[string]*session) ([][2]string, int) {
+	if req.method != "OPTIONS" && !s.authorized(req) {
+		return [][2]string{{"WWW-Authenticate", `Basic realm="LiveKit Egress"`}}, 401
+	}
+
+	switch req.method {
+	case "OPTIONS":
+		return [][2]string{{"Public", "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER"}}, 200
+
+	case "GET_PARAMETER":
+		// keepalive
+		return nil, 200
+
+	case "DESCRIBE":
+		if !s.matchPath(req.url, false) {
+			return nil, 404
+		}
+		return [][2]string{
+			{"Content-Type", "application/sdp"},
+			{"Content-Base", strings.TrimSuffix(req.url, "/") + "/"},
+			{"", s.sdp(c)},
+		}, 200
+
+	case "SETUP":
+		if !s.matchPath(req.url, true) {
+			return nil, 404
+		}
+		if req.headers["session"] != "" {
+			// only one track is offered
+			return nil, 459
+		}
+
+		sess := &session{
+			id:      utils.NewGuid(""),
+			conn:    c,
+			packets: make(chan []byte, maxPendingPackets),
+		}
+		transport, err := sess.setupTransport(req.headers["transport"])
+		if err != nil {
+			logger.Debugw("unsupported rtsp transport", "transport", req.headers["transport"], "error", err)
+			return nil, 461
+		}
+		sessions[sess.id] = sess
+
+		s.mu.Lock()
+		s.sessions[sess.id] = sess
+		s.mu.Unlock()
+		go sess.write()
+
+		return [][2]string{
+			{"Transport", transport},
+			{"Session", fmt.Sprintf("%s;timeout=%d", sess.id, int(s.sessionTimeout.Seconds()))},
+		}, 200
+
+	case "PLAY":
+		sess, ok := sessions[sessionID(req)]
+		if !ok {
+			return nil, 454
+		}
+		s.mu.Lock()
+		sess.playing = true
+		s.mu.Unlock()
+		return [][2]string{
+			{"Session", sess.id},
+			{"Range", "npt=0.000-"},
+		}, 200
+
+	case "TEARDOWN":
+		sess, ok := sessions[sessionID(req)]
+		if !ok {
+			return nil, 454
+		}
+		delete(sessions, sess.id)
+		s.removeSession(sess)
+		return [][2]string{{"Session", sess.id}}, 200
+
+	default:
+		return nil, 405
+	}
+}
+
+func (s *Server) authorized(req *request) bool {
+	if s.authorization == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(req.headers["authorization"]), []byte(s.authorization)) == 1
+}
+
+// matchPath checks the request url against the served path, optionally allowing the track control path
+func (s *Server) matchPath(rawUrl string, track bool) bool {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	p := strings.TrimSuffix(parsed.Path, "/")
+	if track {
+		p = strings.TrimSuffix(strings.TrimSuffix(p, "trackID=0"), "/")
+	}
+	return p == s.path
+}
+
+func (s *Server) sdp(c *conn) string {
+	host, _, _ := net.SplitHostPort(c.LocalAddr().String())
+	return strings.Join([]string{
+		"v=0",
+		fmt.Sprintf("o=- 0 0 IN IP4 %s", host),
+		"s=LiveKit Egress",
+		"c=IN IP4 0.0.0.0",
+		"t=0 0",
+		"a=control:*",
+		"m=video 0 RTP/AVP 33",
+		"a=rtpmap:33 MP2T/90000",
+		"a=control:trackID=0",
+	}, "\r\n") + "\r\n"
+}
+
+// setupTransport picks interleaved tcp or unicast udp, as requested by the player
+func (sess *session) setupTransport(header string) (string, error) {
+	for _, option := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(option), ";")
+		switch params[0] {
+		case "RTP/AVP/TCP":
+			channel := 0
+			if v, ok := transportParam(params, "interleaved"); ok {
+				first, _, _ := strings.Cut(v, "-")
+				c, err := strconv.Atoi(first)
+				if err != nil || c < 0 || c > 254 {
+					continue
+				}
+				channel = c
+			}
+			sess.channel = byte(channel)
+			return fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1), nil
+
+		case "RTP/AVP", "RTP/AVP/UDP":
+			v, ok := transportParam(params, "client_port")
+			if !ok {
+				continue
+			}
+			first, _, _ := strings.Cut(v, "-")
+			port, err := strconv.Atoi(first)
+			if err != nil {
+				continue
+			}
+
+			rtp, rtcp, err := listenUDPPair()
+			if err != nil {
+				return "", err
+			}
+			host, _, _ := net.SplitHostPort(sess.conn.RemoteAddr().String())
+			sess.udp = rtp
+			sess.rtcp = rtcp
+			sess.client = &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+			go sess.readRTCP()
+
+			serverPort := rtp.LocalAddr().(*net.UDPAddr).Port
+			return fmt.Sprintf("RTP/AVP;unicast;client_port=%s;server_port=%d-%d", v, serverPort, serverPort+1), nil
+		}
+	}
+	return "", errors.New("no supported transport")
+}
+
+// listenUDPPair binds an even rtp port, and the rtcp port after it
+func listenUDPPair() (*net.UDPConn, *net.UDPConn, error) {
+	for i := 0; i < maxPortAttempts; i++ {
+		rtp, err := net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return nil, nil, err
+		}
+		port := rtp.LocalAddr().(*net.UDPAddr).Port
+		if port%2 == 0 {
+			if rtcp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1}); err == nil {
+				return rtp, rtcp, nil
+			}
+		}
+		_ = rtp.Close()
+	}
+	return nil, nil, errors.New("no rtp port pair available")
+}
+
+// readRTCP discards player reports, which keep the rtsp connection alive like requests do
+func (sess *session) readRTCP() {
+	buf := make([]byte, 1500)
+	for {
+		if _, err := sess.rtcp.Read(buf); err != nil {
+			return
+		}
+		_ = sess.conn.SetReadDeadline(time.Now().Add(sess.conn.timeout))
+	}
+}
+
+func (sess *session) closeUDP() {
+	if sess.udp != nil {
+		_ = sess.udp.Close()
+		_ = sess.rtcp.Close()
+	}
+}
+
+func transportParam(params []string, key string) (string, bool) {
+	for _, param := range params {
+		if k, v, ok := strings.Cut(param, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func sessionID(req *request) string {
+	id, _, _ := strings.Cut(req.headers["session"], ";")
+	return strings.TrimSpace(id)
+}
+
+// WriteRTP sends a packet to every playing session. Packets are dropped for players that fall behind.
+func (s *Server) WriteRTP(packet []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sess := range s.sessions {
+		if !sess.playing {
+			continue
+		}
+		select {
+		case sess.packets <- packet:
+		default:
+		}
+	}
+}
+
+func (sess *session) write() {
+	for {
+		select {
+		case <-sess.done.Watch():
+			return
+		case packet := <-sess.packets:
+			var err error
+			if sess.udp != nil {
+				_, err = sess.udp.WriteToUDP(packet, sess.client)
+			} else {
+				err = sess.conn.writeInterleaved(sess.channel, packet)
+			}
+			if err != nil {
+				logger.Debugw("failed to write rtsp packet", "error", err)
+			}
+		}
+	}
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+
+	sess.done.Break()
+	sess.closeUDP()
+}
+
+// Close stops listening and disconnects all players
+func (s *Server) Close() error {
+	if s.closed.IsBroken() {
+		return nil
+	}
+	s.closed.Break()
+
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.done.Break()
+		sess.closeUDP()
+		_ = sess.conn.Close()
+	}
+
+	return err
+}
+
+// readRequest returns nil for interleaved data, which is discarded
+func readRequest(r *bufio.Reader) (*request, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] == '$' {
+		header := make([]byte, 4)
+		if _, err = io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		size := int(header[2])<<8 | int(header[3])
+		_, err = r.Discard(size)
+		return nil, err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "RTSP/") {
+		return nil, errors.New("invalid rtsp request")
+	}
+
+	req := &request{
+		method:  parts[0],
+		url:     parts[1],
+		headers: make(map[string]string),
+	}
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			req.headers[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+	}
+
+	if length, _ := strconv.Atoi(req.headers["content-length"]); length > 0 {
+		if _, err = r.Discard(length); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+// writeResponse sends the status and headers. A header without a key is sent as the body.
+func (c *conn) writeResponse(req *request, status int, headers [][2]string) error {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", status, statusText(status))
+	_, _ = fmt.Fprintf(&b, "CSeq: %s\r\n", req.headers["cseq"])
+
+	var body string
+	for _, header := range headers {
+		if header[0] == "" {
+			body = header[1]
+			continue
+		}
+		_, _ = fmt.Fprintf(&b, "%s: %s\r\n", header[0], header[1])
+	}
+	if body != "" {
+		_, _ = fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.WriteString(body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// players which stop reading are disconnected
+	if err := c.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := c.Write([]byte(b.String()))
+	return err
+}
+
+func (c *conn) writeInterleaved(channel byte, packet []byte) error {
+	frame := make([]byte, 4+len(packet))
+	frame[0] = '$'
+	frame[1] = channel
+	frame[2] = byte(len(packet) >> 8)
+	frame[3] = byte(len(packet))
+	copy(frame[4:], packet)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// players which stop reading are disconnected
+	if err := c.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := c.Write(frame)
+	return err
+}
+
+func statusText(status int) string {
+	switch status {
+	case 200:
+		return "OK"
+	case 401:
+		return "Unauthorized"
+	case 404:
+		return "Not Found"
+	case 405:
+		return "Method Not Allowed"
+	case 454:
+		return "Session Not Found"
+	case 459:
+		return "Aggregate Operation Not Allowed"
+	case 461:
+		return "Unsupported Transport"
+	default:
+		return "Internal Server Error"
+	}
+}
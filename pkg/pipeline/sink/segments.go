@@ -15,7 +15,9 @@
 package sink
 
 import (
+	"encoding/base64"
 	"fmt"
+	"os"
 	"path"
 	"strings"
 	"sync"
@@ -26,17 +28,15 @@ import (
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/gstreamer"
+	"github.com/livekit/egress/pkg/pipeline/sink/dash"
 	"github.com/livekit/egress/pkg/pipeline/sink/m3u8"
 	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
 	"github.com/livekit/egress/pkg/stats"
 	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 )
 
-const (
-	defaultLivePlaylistWindow = 5
-)
-
 type SegmentSink struct {
 	*uploader.Uploader
 
@@ -45,10 +45,19 @@ type SegmentSink struct {
 	manifestPlaylist *config.Playlist
 	callbacks        *gstreamer.Callbacks
 
-	segmentCount int
 	playlist     m3u8.PlaylistWriter
 	livePlaylist m3u8.PlaylistWriter
 
+	// low-latency hls parts are listed in the live playlist, and concatenated into segments
+	lowLatencyPlaylist *m3u8.LowLatencyPlaylistWriter
+	partServer         *partServer
+	partCount          int
+	segmentFile        *os.File
+	segmentFilename    string
+	segmentIndex       int
+	segmentStartTime   uint64
+	segmentEndTime     uint64
+
 	segmentLock  sync.Mutex
 	infoLock     sync.Mutex
 	playlistLock sync.Mutex
@@ -62,36 +71,85 @@ type SegmentSink struct {
 	closedSegments  chan SegmentUpdate
 	playlistUpdates chan SegmentUpdate
 	done            core.Fuse
+
+	playlistJournalID     int64
+	livePlaylistJournalID int64
+
+	// uploads follow the playlist upload policy, and failures are retried in the background
+	playlistUploader     *playlistUploader
+	livePlaylistUploader *playlistUploader
+
+	// mirror results of the latest playlist uploads
+	playlistMirrors     []*config.MirrorUpload
+	livePlaylistMirrors []*config.MirrorUpload
+
+	initSegmentUploaded bool
+
+	// segments which left the live window are deleted, for live playlist only outputs
+	backupUploads map[string]bool // files uploaded to backup storage
+	deletes       sync.WaitGroup
+
+	// hls segments are encrypted before upload, using their media sequence number as the iv
+	encryptor     *m3u8.SegmentEncryptor
+	mediaSequence int
+	segmentKey    *m3u8.Key
+}
+
+// expiringPlaylistWriter is implemented by live playlists, which drop segments once they leave their window
+type expiringPlaylistWriter interface {
+	OnExpired(func(filename string))
+}
+
+// resumablePlaylistWriter is implemented by hls live playlists, which continue the media sequence of a resumed playlist
+type resumablePlaylistWriter interface {
+	Resume(mediaSequence int)
+}
+
+// keyedPlaylistWriter is implemented by hls playlists, which list segment encryption keys
+type keyedPlaylistWriter interface {
+	SetKey(key *m3u8.Key)
+}
+
+// dateRangeWriter is implemented by hls playlists, which list timed metadata
+type dateRangeWriter interface {
+	AddDateRange(d *m3u8.DateRange)
 }
 
 type SegmentUpdate struct {
 	endTime        uint64
 	filename       string
 	uploadComplete chan struct{}
+	journalID      int64
+	part           bool      // low-latency part, only listed in the live playlist
+	key            *m3u8.Key // encryption key, listed before the segment
 }
 
 func newSegmentSink(u *uploader.Uploader, p *config.PipelineConfig, o *config.SegmentConfig, callbacks *gstreamer.Callbacks, monitor *stats.HandlerMonitor) (*SegmentSink, error) {
-	playlistName := path.Join(o.LocalDir, o.PlaylistFilename)
-	playlist, err := m3u8.NewEventPlaylistWriter(playlistName, o.SegmentDuration)
-	if err != nil {
-		return nil, err
-	}
-
-	var livePlaylist m3u8.PlaylistWriter
-	if o.LivePlaylistFilename != "" {
-		playlistName = path.Join(o.LocalDir, o.LivePlaylistFilename)
-		livePlaylist, err = m3u8.NewLivePlaylistWriter(playlistName, o.SegmentDuration, defaultLivePlaylistWindow)
-		if err != nil {
+	var existing []byte
+	var err error
+	if o.Resume {
+		// a missing playlist starts a new one
+		existing, err = u.Download(path.Join(o.StorageDir, o.PlaylistFilename), o.OutputType)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return nil, err
 		}
 	}
 
-	outputType := o.OutputType
-	if outputType == types.OutputTypeHLS {
-		outputType = types.OutputTypeTS
+	playlist, livePlaylist, mediaSequence, err := newPlaylistWriters(p, o, existing)
+	if err != nil {
+		return nil, err
+	}
+	if mediaSequence > 0 {
+		logger.Infow("resuming playlist", "playlist", o.PlaylistFilename, "segments", mediaSequence)
+		o.FirstSegmentIndex = mediaSequence
 	}
 
-	maxPendingUploads := (p.MaxUploadQueue * 60) / o.SegmentDuration
+	// low-latency outputs queue an upload per part
+	fragmentDuration := float64(o.SegmentDuration)
+	if o.PartDuration > 0 {
+		fragmentDuration = o.PartDuration
+	}
+	maxPendingUploads := int(float64(p.MaxUploadQueue*60) / fragmentDuration)
 	s := &SegmentSink{
 		Uploader:              u,
 		SegmentConfig:         o,
@@ -99,35 +157,174 @@ func newSegmentSink(u *uploader.Uploader, p *config.PipelineConfig, o *config.Se
 		callbacks:             callbacks,
 		playlist:              playlist,
 		livePlaylist:          livePlaylist,
-		outputType:            outputType,
+		outputType:            o.SegmentOutputType,
 		openSegmentsStartTime: make(map[string]uint64),
 		closedSegments:        make(chan SegmentUpdate, maxPendingUploads),
 		playlistUpdates:       make(chan SegmentUpdate, maxPendingUploads),
+		mediaSequence:         mediaSequence,
+	}
+	if ll, ok := livePlaylist.(*m3u8.LowLatencyPlaylistWriter); ok {
+		s.lowLatencyPlaylist = ll
 	}
 
 	if p.Manifest != nil {
-		s.manifestPlaylist = p.Manifest.AddPlaylist()
+		s.manifestPlaylist = p.Manifest.AddPlaylist(u.Encryption())
+		if r := o.Rendition; r != nil {
+			s.manifestPlaylist.Rendition = &config.PlaylistRendition{
+				Name:      r.Name,
+				Width:     r.Width,
+				Height:    r.Height,
+				Bandwidth: renditionBandwidth(p, r),
+			}
+		}
+	}
+
+	if o.Encryption != nil {
+		if s.encryptor, err = newSegmentEncryptor(p, o); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.DeleteExpiredSegments {
+		if !u.CanDelete() {
+			return nil, errors.ErrNotSupported("delete_expired with this storage")
+		}
+		livePlaylist.(expiringPlaylistWriter).OnExpired(s.deleteExpired)
+		s.backupUploads = make(map[string]bool)
+	}
+
+	// playlists stay pending until their final upload on close
+	if playlist != nil {
+		s.playlistJournalID = u.RecordPending(
+			path.Join(o.LocalDir, o.PlaylistFilename), path.Join(o.StorageDir, o.PlaylistFilename), o.OutputType, true,
+		)
+		s.playlistUploader = newPlaylistUploader(o.PlaylistFilename, o, false, s.uploadPlaylist, &s.playlistLock)
+	}
+	if livePlaylist != nil {
+		s.livePlaylistJournalID = u.RecordPending(
+			path.Join(o.LocalDir, o.LivePlaylistFilename), path.Join(o.StorageDir, o.LivePlaylistFilename), o.OutputType, true,
+		)
+		s.livePlaylistUploader = newPlaylistUploader(o.LivePlaylistFilename, o, true, s.uploadLivePlaylist, &s.playlistLock)
 	}
 
 	// Register gauges that track the number of segments and playlist updates pending upload
-	monitor.RegisterPlaylistChannelSizeGauge(s.conf.NodeID, s.conf.ClusterID, s.conf.Info.EgressId,
-		func() float64 {
-			return float64(len(s.playlistUpdates))
-		})
-	monitor.RegisterSegmentsChannelSizeGauge(s.conf.NodeID, s.conf.ClusterID, s.conf.Info.EgressId,
-		func() float64 {
-			return float64(len(s.closedSegments))
-		})
+	// Renditions are registered together by the multi-rendition sink
+	if monitor != nil {
+		monitor.RegisterPlaylistChannelSizeGauge(s.conf.NodeID, s.conf.ClusterID, s.conf.Info.EgressId,
+			func() float64 {
+				return float64(len(s.playlistUpdates))
+			})
+		monitor.RegisterSegmentsChannelSizeGauge(s.conf.NodeID, s.conf.ClusterID, s.conf.Info.EgressId,
+			func() float64 {
+				return float64(len(s.closedSegments))
+			})
+		monitor.RegisterPlaylistStalenessGauge(s.conf.NodeID, s.conf.ClusterID, s.conf.Info.EgressId, s.playlistStaleness)
+	}
 
 	return s, nil
 }
 
+// newPlaylistWriters creates m3u8 playlists, or mpds for dash. Either playlist may be disabled.
+// If an existing playlist is given, it is resumed, and the number of segments it lists is returned.
+func newPlaylistWriters(p *config.PipelineConfig, o *config.SegmentConfig, existing []byte) (m3u8.PlaylistWriter, m3u8.PlaylistWriter, int, error) {
+	var playlist, livePlaylist m3u8.PlaylistWriter
+	var mediaSequence int
+	var err error
+
+	playlistName := path.Join(o.LocalDir, o.PlaylistFilename)
+	livePlaylistName := path.Join(o.LocalDir, o.LivePlaylistFilename)
+
+	if o.OutputType == types.OutputTypeDASH {
+		representation := dashRepresentation(p)
+		if o.PlaylistFilename != "" {
+			if playlist, err = dash.NewStaticMPDWriter(playlistName, o.SegmentDuration, o.InitSegmentFilename, representation); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		if o.LivePlaylistFilename != "" {
+			if livePlaylist, err = dash.NewDynamicMPDWriter(
+				livePlaylistName, o.SegmentDuration, o.InitSegmentFilename, o.LiveWindowSegments, o.LiveWindowDuration, representation,
+			); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		return playlist, livePlaylist, 0, nil
+	}
+
+	switch {
+	case o.PlaylistFilename == "":
+	case existing != nil:
+		playlist, mediaSequence, err = m3u8.ResumeEventPlaylistWriter(playlistName, o.SegmentDuration, o.InitSegmentFilename, existing)
+		if errors.Is(err, m3u8.ErrPlaylistComplete) {
+			// the previous egress finished, so its playlist is replaced as if resume was disabled
+			logger.Infow("existing playlist is complete, starting a new one", "playlist", o.PlaylistFilename)
+			playlist, err = m3u8.NewEventPlaylistWriter(playlistName, o.SegmentDuration, o.InitSegmentFilename)
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	default:
+		if playlist, err = m3u8.NewEventPlaylistWriter(playlistName, o.SegmentDuration, o.InitSegmentFilename); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	window := m3u8.Window{Segments: o.LiveWindowSegments, Duration: o.LiveWindowDuration}
+	switch {
+	case o.LivePlaylistFilename == "":
+	case o.PartDuration > 0:
+		if livePlaylist, err = m3u8.NewLowLatencyPlaylistWriter(
+			livePlaylistName, o.SegmentDuration, o.PartDuration, window, o.InitSegmentFilename, o.PartServerSocket != "",
+		); err != nil {
+			return nil, nil, 0, err
+		}
+	default:
+		if livePlaylist, err = m3u8.NewLivePlaylistWriter(livePlaylistName, o.SegmentDuration, window, o.InitSegmentFilename); err != nil {
+			return nil, nil, 0, err
+		}
+		if mediaSequence > 0 {
+			livePlaylist.(resumablePlaylistWriter).Resume(mediaSequence)
+		}
+	}
+	return playlist, livePlaylist, mediaSequence, nil
+}
+
+func dashRepresentation(p *config.PipelineConfig) dash.Representation {
+	representation := dash.Representation{MimeType: "audio/mp4"}
+	if p.AudioEnabled {
+		representation.Bandwidth += int(p.AudioBitrate) * 1000
+	}
+	if p.VideoEnabled {
+		representation.MimeType = "video/mp4"
+		representation.Bandwidth += int(p.VideoBitrate) * 1000
+	}
+	return representation
+}
+
 func (s *SegmentSink) Start() error {
+	// renditions are served together by the multi-rendition sink
+	if s.PartServerSocket != "" && s.Rendition == nil {
+		ps, err := startPartServer(s.SegmentConfig, map[string]*m3u8.LowLatencyPlaylistWriter{
+			s.LivePlaylistFilename: s.lowLatencyPlaylist,
+		})
+		if err != nil {
+			return err
+		}
+		s.partServer = ps
+	}
+
 	go func() {
 		defer close(s.playlistUpdates)
 		for update := range s.closedSegments {
 			s.handleClosedSegment(update)
 		}
+		// the last segment ends with the last part
+		if segment, err := s.closeSegment(); err != nil {
+			s.callbacks.OnError(err)
+		} else if segment != nil {
+			s.playlistUpdates <- *segment
+			s.upload(*segment)
+		}
 	}()
 
 	go func() {
@@ -144,9 +341,36 @@ func (s *SegmentSink) Start() error {
 }
 
 func (s *SegmentSink) handleClosedSegment(update SegmentUpdate) {
+	var err error
+	if s.InitSegmentFilename != "" {
+		err = s.handleInitSegment(path.Join(s.LocalDir, update.filename))
+	}
+
+	// parts are read before the playlist update, which releases their start time
+	var segment *SegmentUpdate
+	if err == nil && update.part {
+		segment, err = s.appendPart(update)
+	}
+	if err == nil && s.encryptor != nil {
+		update.key, err = s.encryptSegment(update.filename)
+	}
+
 	// keep playlist updates in order
 	s.playlistUpdates <- update
+	if err != nil {
+		s.callbacks.OnError(err)
+		close(update.uploadComplete)
+		return
+	}
+	s.upload(update)
+
+	if segment != nil {
+		s.playlistUpdates <- *segment
+		s.upload(*segment)
+	}
+}
 
+func (s *SegmentSink) upload(update SegmentUpdate) {
 	segmentLocalPath := path.Join(s.LocalDir, update.filename)
 	segmentStoragePath := path.Join(s.StorageDir, update.filename)
 
@@ -154,23 +378,117 @@ func (s *SegmentSink) handleClosedSegment(update SegmentUpdate) {
 	go func() {
 		defer close(update.uploadComplete)
 
-		location, size, err := s.Upload(segmentLocalPath, segmentStoragePath, s.outputType, true)
+		location, size, uploadInfo, err := s.Upload(segmentLocalPath, segmentStoragePath, s.outputType, true)
 		if err != nil {
 			s.callbacks.OnError(err)
 			return
 		}
+		s.RecordDone(update.journalID)
 
 		// lock segment info updates
 		s.infoLock.Lock()
+		defer s.infoLock.Unlock()
+		if s.backupUploads != nil && uploadInfo.Backup {
+			s.backupUploads[update.filename] = true
+		}
+		if update.part {
+			return
+		}
+
 		s.SegmentsInfo.SegmentCount++
 		s.SegmentsInfo.Size += size
 		if s.manifestPlaylist != nil {
-			s.manifestPlaylist.AddSegment(segmentStoragePath, location)
+			s.manifestPlaylist.AddSegment(segmentStoragePath, location, uploadInfo)
 		}
-		s.infoLock.Unlock()
 	}()
 }
 
+// handleInitSegment splits the header out of a fragmented mp4 segment. The first header is uploaded
+// as the init segment before any segments, since every segment depends on it.
+func (s *SegmentSink) handleInitSegment(segmentLocalPath string) error {
+	if s.initSegmentUploaded {
+		_, err := splitInitSegment(segmentLocalPath, "")
+		return err
+	}
+
+	initLocalPath := path.Join(s.LocalDir, s.InitSegmentFilename)
+	initStoragePath := path.Join(s.StorageDir, s.InitSegmentFilename)
+
+	header, err := splitInitSegment(segmentLocalPath, initLocalPath)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return errors.New("first segment is missing init segment")
+	}
+	s.setCodecs(dash.Codecs(header))
+
+	journalID := s.RecordPending(initLocalPath, initStoragePath, types.OutputTypeMP4, false)
+	location, size, uploadInfo, err := s.Upload(initLocalPath, initStoragePath, types.OutputTypeMP4, true)
+	if err != nil {
+		return err
+	}
+	s.RecordDone(journalID)
+	s.initSegmentUploaded = true
+
+	s.infoLock.Lock()
+	s.SegmentsInfo.Size += size
+	if s.manifestPlaylist != nil {
+		s.manifestPlaylist.SetInitSegment(initStoragePath, location, uploadInfo)
+	}
+	s.infoLock.Unlock()
+
+	return nil
+}
+
+func newSegmentEncryptor(p *config.PipelineConfig, o *config.SegmentConfig) (*m3u8.SegmentEncryptor, error) {
+	if o.Encryption.KeyServerUrl != "" {
+		var rendition string
+		if o.Rendition != nil {
+			rendition = o.Rendition.Name
+		}
+		return m3u8.NewSegmentEncryptor(
+			o.Encryption.RotationSegments, m3u8.KeyServer(o.Encryption.KeyServerUrl, p.Info.EgressId, rendition),
+		), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(o.Encryption.Key)
+	if err != nil {
+		return nil, err
+	}
+	return m3u8.NewSegmentEncryptor(0, m3u8.StaticKey(key, o.Encryption.KeyURI)), nil
+}
+
+// encryptSegment encrypts the next segment in place, recording each new key in the manifest
+func (s *SegmentSink) encryptSegment(filename string) (*m3u8.Key, error) {
+	key, err := s.encryptor.EncryptFile(path.Join(s.LocalDir, filename), s.mediaSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != s.segmentKey {
+		s.segmentKey = key
+		if s.manifestPlaylist != nil {
+			s.manifestPlaylist.AddKey(key.Method, key.URI, s.mediaSequence)
+		}
+	}
+	s.mediaSequence++
+
+	return key, nil
+}
+
+// setCodecs passes the codecs found in the init segment to mpd writers, which list them
+func (s *SegmentSink) setCodecs(codecs string) {
+	s.playlistLock.Lock()
+	defer s.playlistLock.Unlock()
+
+	for _, w := range []m3u8.PlaylistWriter{s.playlist, s.livePlaylist} {
+		if mpd, ok := w.(*dash.MPDWriter); ok {
+			mpd.SetCodecs(codecs)
+		}
+	}
+}
+
 func (s *SegmentSink) handlePlaylistUpdates(update SegmentUpdate) error {
 	s.segmentLock.Lock()
 	t, ok := s.openSegmentsStartTime[update.filename]
@@ -187,46 +505,52 @@ func (s *SegmentSink) handlePlaylistUpdates(update SegmentUpdate) error {
 	// do not update playlist until upload is complete
 	<-update.uploadComplete
 
+	if update.part {
+		return s.publishPart(segmentStartTime, duration, update.filename)
+	}
+
 	s.playlistLock.Lock()
 	defer s.playlistLock.Unlock()
 
-	if err := s.playlist.Append(segmentStartTime, duration, update.filename); err != nil {
-		return err
+	if update.key != nil {
+		for _, w := range []m3u8.PlaylistWriter{s.playlist, s.livePlaylist} {
+			if kw, ok := w.(keyedPlaylistWriter); ok {
+				kw.SetKey(update.key)
+			}
+		}
 	}
 
-	s.segmentCount++
-	if s.shouldUploadPlaylist() {
-		// ignore playlist upload failures until close
-		_ = s.uploadPlaylist()
+	if s.playlist != nil {
+		if err := s.playlist.Append(segmentStartTime, duration, update.filename); err != nil {
+			return err
+		}
+		s.playlistUploader.update()
 	}
 
 	if s.livePlaylist != nil {
 		if err := s.livePlaylist.Append(segmentStartTime, duration, update.filename); err != nil {
 			return err
 		}
-		// ignore playlist upload failures until close
-		_ = s.uploadLivePlaylist()
+		s.livePlaylistUploader.update()
 	}
 
 	return nil
 }
 
-// Each segment adds about 100 bytes in the playlist, and long playlists can get very large.
-// Uploads every N segments, where N is the number of hours, with a minimum frequency of once per minute
-func (s *SegmentSink) shouldUploadPlaylist() bool {
-	segmentsPerHour := 3600 / s.SegmentDuration
-	frequency := min(s.segmentCount/segmentsPerHour, segmentsPerHour/60)
-	return s.segmentCount < segmentsPerHour || s.segmentCount%frequency == 0
+// playlistStaleness returns how long the oldest playlist change has been waiting for upload, in seconds
+func (s *SegmentSink) playlistStaleness() float64 {
+	return max(s.playlistUploader.staleness(), s.livePlaylistUploader.staleness())
 }
 
 func (s *SegmentSink) uploadPlaylist() error {
 	playlistLocalPath := path.Join(s.LocalDir, s.PlaylistFilename)
 	playlistStoragePath := path.Join(s.StorageDir, s.PlaylistFilename)
-	playlistLocation, _, err := s.Upload(playlistLocalPath, playlistStoragePath, s.OutputType, false)
+	playlistLocation, _, uploadInfo, err := s.Upload(playlistLocalPath, playlistStoragePath, s.OutputType, false)
 	if err == nil {
 		s.SegmentsInfo.PlaylistLocation = playlistLocation
+		s.playlistMirrors = uploadInfo.Mirrors
 		if s.manifestPlaylist != nil {
-			s.manifestPlaylist.Location = playlistLocation
+			s.manifestPlaylist.UpdateLocation(playlistStoragePath, playlistLocation)
 		}
 	}
 	return err
@@ -235,13 +559,79 @@ func (s *SegmentSink) uploadPlaylist() error {
 func (s *SegmentSink) uploadLivePlaylist() error {
 	liveLocalPath := path.Join(s.LocalDir, s.LivePlaylistFilename)
 	liveStoragePath := path.Join(s.StorageDir, s.LivePlaylistFilename)
-	livePlaylistLocation, _, err := s.Upload(liveLocalPath, liveStoragePath, s.OutputType, false)
+	livePlaylistLocation, _, uploadInfo, err := s.Upload(liveLocalPath, liveStoragePath, s.OutputType, false)
 	if err == nil {
 		s.SegmentsInfo.LivePlaylistLocation = livePlaylistLocation
+		s.livePlaylistMirrors = uploadInfo.Mirrors
+		// live only outputs list the live playlist in the manifest
+		if s.manifestPlaylist != nil && s.playlist == nil {
+			s.manifestPlaylist.UpdateLocation(liveStoragePath, livePlaylistLocation)
+		}
 	}
 	return err
 }
 
+// deleteExpired removes a segment which left the live window from storage, in the background
+func (s *SegmentSink) deleteExpired(filename string) {
+	storagePath := path.Join(s.StorageDir, filename)
+
+	s.infoLock.Lock()
+	backup := s.backupUploads[filename]
+	delete(s.backupUploads, filename)
+	s.infoLock.Unlock()
+
+	s.deletes.Add(1)
+	go func() {
+		defer s.deletes.Done()
+
+		if err := s.Delete(storagePath, backup); err != nil {
+			logger.Warnw("failed to delete expired segment", err, "filename", storagePath)
+			return
+		}
+
+		if s.manifestPlaylist != nil {
+			s.manifestPlaylist.SetDeleted(storagePath, time.Now())
+		}
+	}()
+}
+
+// InsertMetadata lists timed metadata in each playlist, before the next segment
+func (s *SegmentSink) InsertMetadata(m *config.TimedMetadata) error {
+	if s.OutputType != types.OutputTypeHLS {
+		return errors.ErrNotSupported("timed metadata with dash")
+	}
+
+	d := newDateRange(m)
+
+	s.playlistLock.Lock()
+	defer s.playlistLock.Unlock()
+
+	for _, w := range []m3u8.PlaylistWriter{s.playlist, s.livePlaylist} {
+		if dw, ok := w.(dateRangeWriter); ok {
+			dw.AddDateRange(d)
+		}
+	}
+	return nil
+}
+
+func newDateRange(m *config.TimedMetadata) *m3u8.DateRange {
+	return &m3u8.DateRange{
+		ID:              m.ID,
+		Class:           m.Class,
+		StartDate:       m.StartDate,
+		Duration:        m.Duration,
+		PlannedDuration: m.PlannedDuration,
+		Cue:             m.Cue,
+		SCTE35:          m.SCTE35,
+		Attributes:      m.Attributes,
+	}
+}
+
+// ID3Tag returns timed metadata as an ID3 tag, for mpeg-ts segments
+func ID3Tag(m *config.TimedMetadata) []byte {
+	return newDateRange(m).ID3()
+}
+
 func (s *SegmentSink) UpdateStartDate(t time.Time) {
 	s.segmentLock.Lock()
 	defer s.segmentLock.Unlock()
@@ -278,13 +668,16 @@ func (s *SegmentSink) FragmentClosed(filepath string, endTime uint64) error {
 	}
 
 	filename := filepath[len(s.LocalDir)+1:]
-
-	select {
-	case s.closedSegments <- SegmentUpdate{
+	update := SegmentUpdate{
 		filename:       filename,
 		endTime:        endTime,
 		uploadComplete: make(chan struct{}),
-	}:
+		journalID:      s.RecordPending(filepath, path.Join(s.StorageDir, filename), s.outputType, false),
+		part:           s.PartDuration > 0,
+	}
+
+	select {
+	case s.closedSegments <- update:
 		return nil
 
 	default:
@@ -298,36 +691,64 @@ func (s *SegmentSink) Close() error {
 	// wait for pending jobs to finish
 	close(s.closedSegments)
 	<-s.done.Watch()
+	s.deletes.Wait()
+
+	if s.partServer != nil {
+		// release blocked requests with the final playlist first
+		defer s.partServer.close()
+	}
 
 	s.playlistLock.Lock()
 	defer s.playlistLock.Unlock()
 
-	if err := s.playlist.Close(); err != nil {
-		return err
-	}
-	if err := s.uploadPlaylist(); err != nil {
-		return err
+	if s.playlist != nil {
+		if err := s.playlist.Close(); err != nil {
+			return err
+		}
+		if err := s.playlistUploader.flush(); err != nil {
+			return err
+		}
+		s.RecordDone(s.playlistJournalID)
 	}
 
 	if s.livePlaylist != nil {
 		if err := s.livePlaylist.Close(); err != nil {
 			return err
 		}
-		if err := s.uploadLivePlaylist(); err != nil {
+		if err := s.livePlaylistUploader.flush(); err != nil {
 			return err
 		}
+		s.RecordDone(s.livePlaylistJournalID)
+	}
+
+	// renditions are listed by the master playlist, which is what mirrors report
+	if s.Rendition == nil {
+		updateMirrorLocations(s.Uploader, s.MirrorInfos, s.playlistMirrors, s.livePlaylistMirrors)
 	}
 
 	return nil
 }
 
+// updateMirrorLocations sets the playlist locations of each mirror, once their uploads have finished
+func updateMirrorLocations(u *uploader.Uploader, infos []*livekit.SegmentsInfo, playlist, livePlaylist []*config.MirrorUpload) {
+	u.Drain()
+	for i, info := range infos {
+		if i < len(playlist) {
+			info.PlaylistLocation = playlist[i].Location
+		}
+		if i < len(livePlaylist) {
+			info.LivePlaylistLocation = livePlaylist[i].Location
+		}
+	}
+}
+
 func (s *SegmentSink) UploadManifest(filepath string) (string, bool, error) {
 	if s.DisableManifest && !s.conf.Info.BackupStorageUsed {
 		return "", false, nil
 	}
 
 	storagePath := path.Join(s.StorageDir, path.Base(filepath))
-	location, _, err := s.Upload(filepath, storagePath, types.OutputTypeJSON, false)
+	location, _, _, err := s.Upload(filepath, storagePath, types.OutputTypeJSON, false)
 	if err != nil {
 		return "", false, err
 	}
@@ -15,6 +15,8 @@
 package sink
 
 import (
+	"time"
+
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/gstreamer"
 	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
@@ -28,7 +30,21 @@ type Sink interface {
 	UploadManifest(string) (string, bool, error)
 }
 
-func CreateSinks(p *config.PipelineConfig, callbacks *gstreamer.Callbacks, monitor *stats.HandlerMonitor) (map[types.EgressType][]Sink, error) {
+// SegmentedSink receives splitmuxsink fragment events
+type SegmentedSink interface {
+	Sink
+	UpdateStartDate(time.Time)
+	InsertMetadata(*config.TimedMetadata) error
+	FragmentOpened(filepath string, startTime uint64) error
+	FragmentClosed(filepath string, endTime uint64) error
+}
+
+func CreateSinks(
+	p *config.PipelineConfig,
+	callbacks *gstreamer.Callbacks,
+	monitor *stats.HandlerMonitor,
+	journal *uploader.Journal,
+) (map[types.EgressType][]Sink, error) {
 	sinks := make(map[types.EgressType][]Sink)
 	for egressType, c := range p.Outputs {
 		if len(c) == 0 {
@@ -41,28 +57,37 @@ func CreateSinks(p *config.PipelineConfig, callbacks *gstreamer.Callbacks, monit
 		case types.EgressTypeFile:
 			o := c[0].(*config.FileConfig)
 
-			u, err := uploader.New(o.StorageConfig, p.BackupConfig, monitor, p.Info)
+			u, err := uploader.New(o.StorageConfig, p.BackupConfig, o.MirrorConfigs, monitor, p.Info)
 			if err != nil {
 				return nil, err
 			}
+			u.SetJournal(journal, egressType)
 
 			s = newFileSink(u, p, o)
 
 		case types.EgressTypeSegments:
 			o := c[0].(*config.SegmentConfig)
 
-			u, err := uploader.New(o.StorageConfig, p.BackupConfig, monitor, p.Info)
+			u, err := uploader.New(o.StorageConfig, p.BackupConfig, o.MirrorConfigs, monitor, p.Info)
 			if err != nil {
 				return nil, err
 			}
+			u.SetJournal(journal, egressType)
 
-			s, err = newSegmentSink(u, p, o, callbacks, monitor)
+			if len(o.Renditions) > 0 {
+				s, err = newMultiRenditionSegmentSink(u, p, o, callbacks, monitor)
+			} else {
+				s, err = newSegmentSink(u, p, o, callbacks, monitor)
+			}
 			if err != nil {
 				return nil, err
 			}
 
 		case types.EgressTypeStream:
-			// no sink needed
+			// hls and dash streams are segmented once, and pushed by the ingest sink. Others need no sink
+			if o := c[0].(*config.StreamConfig); o.Ingest != nil {
+				s = newIngestSink(p, o, callbacks)
+			}
 
 		case types.EgressTypeWebsocket:
 			o := c[0].(*config.StreamConfig)
@@ -71,14 +96,26 @@ func CreateSinks(p *config.PipelineConfig, callbacks *gstreamer.Callbacks, monit
 			if err != nil {
 				return nil, err
 			}
+		case types.EgressTypeThumbnails:
+			o := c[0].(*config.ThumbnailConfig)
+
+			u, err := uploader.New(o.StorageConfig, p.BackupConfig, o.MirrorConfigs, monitor, p.Info)
+			if err != nil {
+				return nil, err
+			}
+			u.SetJournal(journal, egressType)
+
+			s = newThumbnailSink(u, p, o)
+
 		case types.EgressTypeImages:
 			for _, ci := range c {
 				o := ci.(*config.ImageConfig)
 
-				u, err := uploader.New(o.StorageConfig, p.BackupConfig, monitor, p.Info)
+				u, err := uploader.New(o.StorageConfig, p.BackupConfig, o.MirrorConfigs, monitor, p.Info)
 				if err != nil {
 					return nil, err
 				}
+				u.SetJournal(journal, egressType)
 
 				s, err = newImageSink(u, p, o, callbacks)
 				if err != nil {
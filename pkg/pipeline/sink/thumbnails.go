@@ -0,0 +1,290 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"go.uber.org/atomic"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/logger"
+)
+
+const thumbnailQuality = 85
+
+// ThumbnailSink composes captured frames into sprite sheets, and writes a WebVTT track with
+// #xywh fragments pointing into them once the recording ends. Failures are logged and listed in the
+// manifest, without failing the recording.
+type ThumbnailSink struct {
+	*uploader.Uploader
+
+	*config.ThumbnailConfig
+
+	sinkCallbacks *app.SinkCallbacks
+	manifest      *config.Thumbnails
+
+	initialized bool
+	startPTS    time.Duration
+	sheet       *image.RGBA
+	sheetIndex  int
+	tiles       int // on the current sheet
+	cues        []*thumbnailCue
+	err         error // thumbnails stop at the first failure
+
+	frames  chan *thumbnailFrame
+	dropped atomic.Int64
+	done    core.Fuse
+}
+
+type thumbnailFrame struct {
+	pts   time.Duration
+	image *image.RGBA
+}
+
+type thumbnailCue struct {
+	start time.Duration
+	end   time.Duration
+	sheet string
+	x     int
+	y     int
+}
+
+func newThumbnailSink(u *uploader.Uploader, p *config.PipelineConfig, o *config.ThumbnailConfig) *ThumbnailSink {
+	maxPendingFrames := max((p.MaxUploadQueue*60)/int(o.Interval), 1)
+	s := &ThumbnailSink{
+		Uploader:        u,
+		ThumbnailConfig: o,
+		frames:          make(chan *thumbnailFrame, maxPendingFrames),
+	}
+	if p.Manifest != nil {
+		s.manifest = p.Manifest.AddThumbnails(u.Encryption())
+	}
+
+	width, height := int(o.Width), int(o.Height)
+	s.sinkCallbacks = &app.SinkCallbacks{
+		NewSampleFunc: func(appSink *app.Sink) gst.FlowReturn {
+			sample := appSink.PullSample()
+			if sample == nil {
+				return gst.FlowOK
+			}
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowOK
+			}
+			pts := buffer.PresentationTimestamp().AsDuration()
+			if pts == nil {
+				return gst.FlowOK
+			}
+
+			// rgba frames, scaled by the bin
+			pix := buffer.Bytes()
+			if len(pix) < width*height*4 {
+				logger.Warnw("invalid thumbnail frame", nil, "size", len(pix))
+				return gst.FlowOK
+			}
+
+			// never block the video branch on uploads
+			select {
+			case s.frames <- &thumbnailFrame{
+				pts: *pts,
+				image: &image.RGBA{
+					Pix:    pix,
+					Stride: width * 4,
+					Rect:   image.Rect(0, 0, width, height),
+				},
+			}:
+			default:
+				if s.dropped.Inc() == 1 {
+					logger.Warnw("dropping thumbnail frames", nil)
+				}
+			}
+			return gst.FlowOK
+		},
+	}
+
+	return s
+}
+
+func (s *ThumbnailSink) SinkCallbacks() *app.SinkCallbacks {
+	return s.sinkCallbacks
+}
+
+func (s *ThumbnailSink) Start() error {
+	go func() {
+		defer s.done.Break()
+
+		for frame := range s.frames {
+			if s.err != nil {
+				// keep draining until closed
+				continue
+			}
+			if err := s.addFrame(frame); err != nil {
+				logger.Warnw("thumbnail handling failed", err)
+				s.err = err
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *ThumbnailSink) addFrame(frame *thumbnailFrame) error {
+	if !s.initialized {
+		s.startPTS = frame.pts
+		s.initialized = true
+	}
+	if s.sheet == nil {
+		s.sheet = image.NewRGBA(image.Rect(0, 0, int(s.Width)*s.Columns, int(s.Height)*s.Rows))
+	}
+
+	x := (s.tiles % s.Columns) * int(s.Width)
+	y := (s.tiles / s.Columns) * int(s.Height)
+	draw.Draw(s.sheet, image.Rect(x, y, x+int(s.Width), y+int(s.Height)), frame.image, image.Point{}, draw.Src)
+	s.tiles++
+
+	// each thumbnail is shown until the next one
+	start := frame.pts - s.startPTS
+	if len(s.cues) > 0 {
+		s.cues[len(s.cues)-1].end = start
+	}
+	s.cues = append(s.cues, &thumbnailCue{
+		start: start,
+		end:   start + time.Duration(s.Interval)*time.Second,
+		sheet: s.sheetFilename(),
+		x:     x,
+		y:     y,
+	})
+
+	if s.tiles == s.Columns*s.Rows {
+		return s.uploadSheet()
+	}
+	return nil
+}
+
+func (s *ThumbnailSink) sheetFilename() string {
+	return fmt.Sprintf("%s_%05d%s", s.Prefix, s.sheetIndex, types.FileExtensionForOutputType[s.OutputType])
+}
+
+func (s *ThumbnailSink) uploadSheet() error {
+	// the last sheet is cropped to the rows in use
+	rows := (s.tiles + s.Columns - 1) / s.Columns
+	sheet := s.sheet.SubImage(image.Rect(0, 0, int(s.Width)*s.Columns, int(s.Height)*rows))
+
+	filename := s.sheetFilename()
+	localPath := path.Join(s.LocalDir, filename)
+	storagePath := path.Join(s.StorageDir, filename)
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(f, sheet, &jpeg.Options{Quality: thumbnailQuality})
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	journalID := s.RecordPending(localPath, storagePath, s.OutputType, false)
+	location, _, uploadInfo, err := s.Upload(localPath, storagePath, s.OutputType, true)
+	if err != nil {
+		return err
+	}
+	s.RecordDone(journalID)
+
+	if s.manifest != nil {
+		s.manifest.AddSheet(storagePath, location, uploadInfo)
+	}
+
+	s.sheet = nil
+	s.sheetIndex++
+	s.tiles = 0
+	return nil
+}
+
+func (s *ThumbnailSink) uploadTrack() error {
+	localPath := path.Join(s.LocalDir, s.TrackFilename)
+	storagePath := path.Join(s.StorageDir, s.TrackFilename)
+
+	if err := os.WriteFile(localPath, writeThumbnailTrack(s.cues, int(s.Width), int(s.Height)), 0644); err != nil {
+		return err
+	}
+
+	journalID := s.RecordPending(localPath, storagePath, types.OutputTypeWebVTT, false)
+	location, _, uploadInfo, err := s.Upload(localPath, storagePath, types.OutputTypeWebVTT, true)
+	if err != nil {
+		return err
+	}
+	s.RecordDone(journalID)
+	logger.Infow("thumbnail track uploaded", "location", location)
+
+	if s.manifest != nil {
+		s.manifest.SetTrack(storagePath, location, uploadInfo)
+	}
+	return nil
+}
+
+// writeThumbnailTrack lists each thumbnail as a cue, with sheets relative to the track
+func writeThumbnailTrack(cues []*thumbnailCue, width, height int) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		_, _ = fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatCueTime(cue.start), formatCueTime(cue.end), cue.sheet, cue.x, cue.y, width, height)
+	}
+	return b.Bytes()
+}
+
+func formatCueTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func (s *ThumbnailSink) Close() error {
+	close(s.frames)
+	<-s.done.Watch()
+
+	if s.err == nil && s.tiles > 0 {
+		s.err = s.uploadSheet()
+	}
+	if s.err == nil && len(s.cues) > 0 {
+		s.err = s.uploadTrack()
+	}
+	if s.err != nil {
+		logger.Warnw("thumbnails incomplete", s.err)
+	}
+
+	if s.manifest != nil {
+		s.manifest.SetResult(s.dropped.Load(), s.err)
+	}
+	return nil
+}
+
+func (s *ThumbnailSink) UploadManifest(_ string) (string, bool, error) {
+	// uploaded with the recording
+	return "", false, nil
+}
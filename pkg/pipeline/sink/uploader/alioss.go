@@ -15,9 +15,13 @@
 package uploader
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path"
+	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 
@@ -27,46 +31,106 @@ import (
 )
 
 type AliOSSUploader struct {
-	conf                 *config.S3Config
-	prefix               string
-	generatePresignedUrl bool
+	conf   *config.S3Config
+	prefix string
 }
 
 func newAliOSSUploader(c *config.StorageConfig) (uploader, error) {
-	if c.GeneratePresignedUrl {
-		return nil, errors.ErrUploadFailed("AliOSS", fmt.Errorf("presigned URLs not supported"))
-	}
-
-	conf := c.AliOSS
 	return &AliOSSUploader{
-		conf:                 conf,
-		prefix:               c.PathPrefix,
-		generatePresignedUrl: c.GeneratePresignedUrl,
+		conf:   c.AliOSS,
+		prefix: c.PathPrefix,
 	}, nil
 }
 
-func (u *AliOSSUploader) upload(localFilepath, storageFilepath string, _ types.OutputType) (string, int64, error) {
+func (u *AliOSSUploader) upload(ctx context.Context, localFilepath, storageFilepath string, _ types.OutputType, d *digest) (string, int64, error) {
 	storageFilepath = path.Join(u.prefix, storageFilepath)
 
-	stat, err := os.Stat(localFilepath)
+	file, err := os.Open(localFilepath)
 	if err != nil {
 		return "", 0, errors.ErrUploadFailed("AliOSS", err)
 	}
+	defer func() {
+		_ = file.Close()
+	}()
 
-	client, err := oss.New(u.conf.Endpoint, u.conf.AccessKey, u.conf.Secret)
+	stat, err := file.Stat()
 	if err != nil {
 		return "", 0, errors.ErrUploadFailed("AliOSS", err)
 	}
 
-	bucket, err := client.Bucket(u.conf.Bucket)
+	bucket, err := u.bucket()
 	if err != nil {
 		return "", 0, errors.ErrUploadFailed("AliOSS", err)
 	}
 
-	err = bucket.PutObjectFromFile(storageFilepath, localFilepath)
+	var header http.Header
+	err = bucket.PutObject(storageFilepath, d.reader(file),
+		oss.WithContext(ctx),
+		oss.ContentLength(stat.Size()),
+		oss.GetResponseHeader(&header),
+	)
 	if err != nil {
 		return "", 0, errors.ErrUploadFailed("AliOSS", err)
 	}
+	if err = verifyETag(header.Get("ETag"), d.md5Sum()); err != nil {
+		return "", 0, errors.ErrUploadFailed("AliOSS", err)
+	}
 
 	return fmt.Sprintf("https://%s.%s/%s", u.conf.Bucket, u.conf.Endpoint, storageFilepath), stat.Size(), nil
 }
+
+func (u *AliOSSUploader) presign(_ context.Context, storageFilepath string, expiry time.Duration) (string, error) {
+	bucket, err := u.bucket()
+	if err != nil {
+		return "", errors.ErrUploadFailed("AliOSS", err)
+	}
+
+	location, err := bucket.SignURL(path.Join(u.prefix, storageFilepath), oss.HTTPGet, int64(expiry.Seconds()))
+	if err != nil {
+		return "", errors.ErrUploadFailed("AliOSS", err)
+	}
+	return location, nil
+}
+
+func (u *AliOSSUploader) delete(ctx context.Context, storageFilepath string) error {
+	bucket, err := u.bucket()
+	if err != nil {
+		return errors.ErrUploadFailed("AliOSS", err)
+	}
+
+	if err = bucket.DeleteObject(path.Join(u.prefix, storageFilepath), oss.WithContext(ctx)); err != nil {
+		return errors.ErrUploadFailed("AliOSS", err)
+	}
+	return nil
+}
+
+func (u *AliOSSUploader) download(ctx context.Context, storageFilepath string) ([]byte, error) {
+	bucket, err := u.bucket()
+	if err != nil {
+		return nil, errors.ErrUploadFailed("AliOSS", err)
+	}
+
+	r, err := bucket.GetObject(path.Join(u.prefix, storageFilepath), oss.WithContext(ctx))
+	if err != nil {
+		var serviceErr oss.ServiceError
+		if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound {
+			return nil, os.ErrNotExist
+		}
+		return nil, errors.ErrUploadFailed("AliOSS", err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.ErrUploadFailed("AliOSS", err)
+	}
+	return b, nil
+}
+
+func (u *AliOSSUploader) bucket() (*oss.Bucket, error) {
+	client, err := oss.New(u.conf.Endpoint, u.conf.AccessKey, u.conf.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return client.Bucket(u.conf.Bucket)
+}
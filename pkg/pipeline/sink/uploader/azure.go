@@ -17,9 +17,11 @@ package uploader
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path"
+	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
 
@@ -29,40 +31,108 @@ import (
 )
 
 type AzureUploader struct {
-	conf                 *config.AzureConfig
-	prefix               string
-	container            string
-	generatePresignedUrl bool
+	conf      *config.AzureConfig
+	prefix    string
+	container string
 }
 
 func newAzureUploader(c *config.StorageConfig) (uploader, error) {
-	if c.GeneratePresignedUrl {
-		return nil, errors.ErrUploadFailed("Azure", fmt.Errorf("presigned URLs not supported"))
-	}
-
 	conf := c.Azure
 	return &AzureUploader{
-		conf:                 conf,
-		prefix:               c.PathPrefix,
-		generatePresignedUrl: c.GeneratePresignedUrl,
-		container:            fmt.Sprintf("https://%s.blob.core.windows.net/%s", conf.AccountName, conf.ContainerName),
+		conf:      conf,
+		prefix:    c.PathPrefix,
+		container: fmt.Sprintf("https://%s.blob.core.windows.net/%s", conf.AccountName, conf.ContainerName),
 	}, nil
 }
 
-func (u *AzureUploader) upload(localFilepath, storageFilepath string, outputType types.OutputType) (string, int64, error) {
+func (u *AzureUploader) upload(ctx context.Context, localFilepath, storageFilepath string, outputType types.OutputType, d *digest) (string, int64, error) {
 	storageFilepath = path.Join(u.prefix, storageFilepath)
 
+	blobURL, err := u.blobURL(storageFilepath)
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed("Azure", err)
+	}
+
+	file, err := os.Open(localFilepath)
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed("Azure", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// blocks are read from the file in order, hashed, and uploaded in parallel
+	_, err = azblob.UploadStreamToBlockBlob(ctx, d.reader(file), blobURL, azblob.UploadStreamToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			ContentType: string(outputType),
+		},
+		BufferSize: 4 * 1024 * 1024,
+		MaxBuffers: 16,
+	})
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed("Azure", err)
+	}
+
+	// block blobs have no content md5 to compare, so verify the size azure received instead
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed("Azure", err)
+	}
+	if props.ContentLength() != d.size {
+		return "", 0, errors.ErrUploadFailed("Azure", fmt.Errorf("size mismatch: expected %d bytes, received %d", d.size, props.ContentLength()))
+	}
+
+	return fmt.Sprintf("%s/%s", u.container, storageFilepath), d.size, nil
+}
+
+func (u *AzureUploader) delete(ctx context.Context, storageFilepath string) error {
+	blobURL, err := u.blobURL(path.Join(u.prefix, storageFilepath))
+	if err != nil {
+		return errors.ErrUploadFailed("Azure", err)
+	}
+
+	if _, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{}); err != nil {
+		return errors.ErrUploadFailed("Azure", err)
+	}
+	return nil
+}
+
+func (u *AzureUploader) download(ctx context.Context, storageFilepath string) ([]byte, error) {
+	blobURL, err := u.blobURL(path.Join(u.prefix, storageFilepath))
+	if err != nil {
+		return nil, errors.ErrUploadFailed("Azure", err)
+	}
+
+	resp, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		var storageErr azblob.StorageError
+		if errors.As(err, &storageErr) && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, os.ErrNotExist
+		}
+		return nil, errors.ErrUploadFailed("Azure", err)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxRetries})
+	defer body.Close()
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, errors.ErrUploadFailed("Azure", err)
+	}
+	return b, nil
+}
+
+func (u *AzureUploader) blobURL(storageFilepath string) (azblob.BlockBlobURL, error) {
 	credential, err := azblob.NewSharedKeyCredential(
 		u.conf.AccountName,
 		u.conf.AccountKey,
 	)
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed("Azure", err)
+		return azblob.BlockBlobURL{}, err
 	}
 
 	azUrl, err := url.Parse(u.container)
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed("Azure", err)
+		return azblob.BlockBlobURL{}, err
 	}
 
 	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{
@@ -74,31 +144,31 @@ func (u *AzureUploader) upload(localFilepath, storageFilepath string, outputType
 		},
 	})
 	containerURL := azblob.NewContainerURL(*azUrl, pipeline)
-	blobURL := containerURL.NewBlockBlobURL(storageFilepath)
+	return containerURL.NewBlockBlobURL(storageFilepath), nil
+}
 
-	file, err := os.Open(localFilepath)
-	if err != nil {
-		return "", 0, errors.ErrUploadFailed("Azure", err)
-	}
-	defer func() {
-		_ = file.Close()
-	}()
+// presign returns a read-only service SAS url for the blob
+func (u *AzureUploader) presign(_ context.Context, storageFilepath string, expiry time.Duration) (string, error) {
+	storageFilepath = path.Join(u.prefix, storageFilepath)
 
-	stat, err := file.Stat()
+	credential, err := azblob.NewSharedKeyCredential(
+		u.conf.AccountName,
+		u.conf.AccountKey,
+	)
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed("Azure", err)
+		return "", errors.ErrUploadFailed("Azure", err)
 	}
 
-	// upload blocks in parallel for optimal performance
-	// it calls PutBlock/PutBlockList for files larger than 256 MBs and PutBlob for smaller files
-	_, err = azblob.UploadFileToBlockBlob(context.Background(), file, blobURL, azblob.UploadToBlockBlobOptions{
-		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: string(outputType)},
-		BlockSize:       4 * 1024 * 1024,
-		Parallelism:     16,
-	})
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		ExpiryTime:    time.Now().UTC().Add(expiry),
+		ContainerName: u.conf.ContainerName,
+		BlobName:      storageFilepath,
+		Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+	}.NewSASQueryParameters(credential)
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed("Azure", err)
+		return "", errors.ErrUploadFailed("Azure", err)
 	}
 
-	return fmt.Sprintf("%s/%s", u.container, storageFilepath), stat.Size(), nil
+	return fmt.Sprintf("%s/%s?%s", u.container, storageFilepath, sas.Encode()), nil
 }
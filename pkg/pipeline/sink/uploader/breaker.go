@@ -0,0 +1,167 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	defaultFailoverWindow      = time.Minute
+	defaultFailoverMinUploads  = 3
+	defaultFailoverFailureRate = 0.5
+	defaultFailoverCooldown    = time.Second * 30
+)
+
+var errPrimaryBypassed = errors.New("primary storage bypassed after repeated failures")
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // uploads go to the primary
+	breakerOpen                         // uploads go to the backup until the cooldown has passed
+	breakerHalfOpen                     // a single probe is being sent to the primary
+)
+
+// circuitBreaker decides whether an upload should be attempted on the primary
+type circuitBreaker struct {
+	window      time.Duration
+	minUploads  int
+	failureRate float64
+	cooldown    time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	openedAt time.Time
+	results  []breakerResult
+}
+
+type breakerResult struct {
+	at     time.Time
+	failed bool
+}
+
+func newCircuitBreaker(conf *config.FailoverConfig) *circuitBreaker {
+	b := &circuitBreaker{
+		window:      defaultFailoverWindow,
+		minUploads:  defaultFailoverMinUploads,
+		failureRate: defaultFailoverFailureRate,
+		cooldown:    defaultFailoverCooldown,
+		now:         time.Now,
+	}
+	if conf != nil {
+		if conf.Window > 0 {
+			b.window = conf.Window
+		}
+		if conf.MinUploads > 0 {
+			b.minUploads = conf.MinUploads
+		}
+		if conf.FailureRate > 0 {
+			b.failureRate = conf.FailureRate
+		}
+		if conf.Cooldown > 0 {
+			b.cooldown = conf.Cooldown
+		}
+	}
+	return b
+}
+
+// allow reports whether the next upload should try the primary. Once the cooldown has passed,
+// a single caller is allowed through as a probe, and must report its result.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		logger.Infow("probing primary storage")
+		b.state = breakerHalfOpen
+		return true
+	default:
+		return false
+	}
+}
+
+// cancel is called instead of record when an upload was interrupted, which says nothing about the primary.
+// An interrupted probe lets the next upload probe instead.
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			logger.Infow("primary storage probe failed")
+			b.state = breakerOpen
+			b.openedAt = now
+		} else {
+			logger.Infow("primary storage recovered")
+			b.state = breakerClosed
+			b.results = b.results[:0]
+		}
+		return
+	case breakerOpen:
+		// uploads started before the breaker opened
+		return
+	}
+
+	b.results = append(b.results, breakerResult{at: now, failed: failed})
+	cutoff := now.Add(-b.window)
+	i := 0
+	for i < len(b.results) && b.results[i].at.Before(cutoff) {
+		i++
+	}
+	b.results = b.results[i:]
+
+	if !failed || len(b.results) < b.minUploads {
+		return
+	}
+	failures := 0
+	for _, r := range b.results {
+		if r.failed {
+			failures++
+		}
+	}
+	if float64(failures)/float64(len(b.results)) >= b.failureRate {
+		logger.Infow("primary storage failing, switching to backup",
+			"failures", failures,
+			"uploads", len(b.results),
+		)
+		b.state = breakerOpen
+		b.openedAt = now
+		b.results = b.results[:0]
+	}
+}
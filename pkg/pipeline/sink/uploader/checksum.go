@@ -0,0 +1,83 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/livekit/egress/pkg/config"
+)
+
+// digest computes the checksums of an upload body while it is streamed, so that files are only read once.
+// Backends verify them against the server's ETag or checksum once the upload completes.
+type digest struct {
+	size   int64
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+func newDigest() *digest {
+	return &digest{
+		sha256: sha256.New(),
+		md5:    md5.New(),
+	}
+}
+
+// reader hashes everything read from r. Each call restarts the digest, so a retried attempt is hashed from the start.
+func (d *digest) reader(r io.Reader) io.Reader {
+	d.size = 0
+	d.sha256.Reset()
+	d.md5.Reset()
+	return io.TeeReader(r, d)
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	_, _ = d.sha256.Write(p)
+	_, _ = d.md5.Write(p)
+	return len(p), nil
+}
+
+func (d *digest) md5Sum() []byte {
+	return d.md5.Sum(nil)
+}
+
+func (d *digest) checksums() config.Checksums {
+	return config.Checksums{
+		SHA256: hex.EncodeToString(d.sha256.Sum(nil)),
+		MD5:    hex.EncodeToString(d.md5Sum()),
+	}
+}
+
+// verifyETag compares an ETag against an MD5. ETags which are not plain MD5s, such as those of
+// multipart or encrypted objects, cannot be verified and are ignored.
+func verifyETag(etag string, md5Sum []byte) error {
+	etag = strings.Trim(etag, `"`)
+	b, err := hex.DecodeString(etag)
+	if err != nil || len(b) != md5.Size {
+		return nil
+	}
+	if !bytes.Equal(b, md5Sum) {
+		return fmt.Errorf("checksum mismatch: expected md5 %x, received etag %s", md5Sum, etag)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+)
+
+const (
+	encryptionAlgorithm = "AES-256-GCM-CHUNKED"
+	encryptionChunkSize = 64 * 1024
+	encryptionMagic     = "LKE1"
+	noncePrefixSize     = 8
+
+	keySourceStatic = "static"
+	keySourceFile   = "file"
+	keySourceKMS    = "kms"
+
+	kmsTimeout = time.Second * 10
+)
+
+// Encrypted files have the following layout, so that they can be decrypted without the manifest:
+//
+//	magic "LKE1" | wrapped key length (uint16) | wrapped key | nonce prefix (8 bytes) | chunks
+//
+// Each chunk holds up to 64KiB of plaintext sealed with the data key. Its nonce is the prefix followed by
+// the chunk index (uint32), and its additional data marks whether it is the final chunk, so that
+// truncated or reordered files fail to decrypt.
+type encryptor struct {
+	aead       cipher.AEAD
+	wrappedKey []byte
+	info       *config.Encryption
+}
+
+type kmsEncryptReq struct {
+	KeyID     string `json:"key_id,omitempty"`
+	Plaintext string `json:"plaintext"` // base64
+}
+
+type kmsEncryptResp struct {
+	Ciphertext string `json:"ciphertext"` // base64
+}
+
+// newEncryptor generates a data key and wraps it using the configured key source
+func newEncryptor(conf *config.EncryptionConfig) (*encryptor, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	var keySource string
+	var wrappedKey []byte
+	var err error
+	switch {
+	case conf.Key != "" && conf.KeyFile == "" && conf.KMSUrl == "":
+		keySource = keySourceStatic
+		wrappedKey, err = wrapKey(conf.Key, dataKey)
+	case conf.KeyFile != "" && conf.Key == "" && conf.KMSUrl == "":
+		keySource = keySourceFile
+		var b []byte
+		if b, err = os.ReadFile(conf.KeyFile); err == nil {
+			wrappedKey, err = wrapKey(strings.TrimSpace(string(b)), dataKey)
+		}
+	case conf.KMSUrl != "" && conf.Key == "" && conf.KeyFile == "":
+		keySource = keySourceKMS
+		wrappedKey, err = wrapKeyKMS(conf, dataKey)
+	default:
+		return nil, errors.New("exactly one of key, key_file or kms_url is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptor{
+		aead:       aead,
+		wrappedKey: wrappedKey,
+		info: &config.Encryption{
+			Algorithm:  encryptionAlgorithm,
+			ChunkSize:  encryptionChunkSize,
+			KeySource:  keySource,
+			KeyID:      conf.KeyID,
+			WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		},
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey seals the data key with a local key, prepending the nonce
+func wrapKey(encodedKey string, dataKey []byte) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func wrapKeyKMS(conf *config.EncryptionConfig, dataKey []byte) ([]byte, error) {
+	reqBytes, err := json.Marshal(&kmsEncryptReq{
+		KeyID:     conf.KeyID,
+		Plaintext: base64.StdEncoding.EncodeToString(dataKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: kmsTimeout}
+	resp, err := client.Post(conf.KMSUrl, "application/json", bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kms request failed: %s", resp.Status)
+	}
+
+	res := &kmsEncryptResp{}
+	if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Ciphertext)
+}
+
+// encryptFile writes an encrypted copy of localFilepath next to it, returning the new path
+func (e *encryptor) encryptFile(localFilepath string) (string, error) {
+	in, err := os.Open(localFilepath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	encryptedFilepath := localFilepath + ".enc"
+	out, err := os.Create(encryptedFilepath)
+	if err != nil {
+		return "", err
+	}
+
+	if err = e.encrypt(in, out); err != nil {
+		_ = out.Close()
+		_ = os.Remove(encryptedFilepath)
+		return "", err
+	}
+	if err = out.Close(); err != nil {
+		_ = os.Remove(encryptedFilepath)
+		return "", err
+	}
+
+	return encryptedFilepath, nil
+}
+
+func (e *encryptor) encrypt(r io.Reader, w io.Writer) error {
+	header := make([]byte, 0, len(encryptionMagic)+2+len(e.wrappedKey)+noncePrefixSize)
+	header = append(header, encryptionMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(e.wrappedKey)))
+	header = append(header, e.wrappedKey...)
+
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(noncePrefix); err != nil {
+		return err
+	}
+	header = append(header, noncePrefix...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// peek past each chunk, so that the final chunk can be marked
+	br := bufio.NewReaderSize(r, encryptionChunkSize)
+	chunk := make([]byte, encryptionChunkSize)
+	nonce := make([]byte, e.aead.NonceSize())
+	copy(nonce, noncePrefix)
+	sealed := make([]byte, 0, encryptionChunkSize+e.aead.Overhead())
+
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(br, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := n < len(chunk)
+		if !final {
+			if _, err = br.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+		aad := []byte{0}
+		if final {
+			aad[0] = 1
+		}
+		sealed = e.aead.Seal(sealed[:0], nonce, chunk[:n], aad)
+		if _, err = w.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package uploader
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
@@ -23,10 +24,12 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/googleapis/gax-go/v2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
 	"google.golang.org/api/option"
 
 	"github.com/livekit/egress/pkg/config"
@@ -37,22 +40,17 @@ import (
 const storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
 
 type GCPUploader struct {
-	conf                 *config.GCPConfig
-	prefix               string
-	generatePresignedUrl bool
-	client               *storage.Client
+	conf      *config.GCPConfig
+	prefix    string
+	client    *storage.Client
+	jwtConfig *jwt.Config
 }
 
 func newGCPUploader(c *config.StorageConfig) (uploader, error) {
-	if c.GeneratePresignedUrl {
-		return nil, errors.ErrUploadFailed("GCP", fmt.Errorf("presigned URLs not supported"))
-	}
-
 	conf := c.GCP
 	u := &GCPUploader{
-		conf:                 conf,
-		prefix:               c.PathPrefix,
-		generatePresignedUrl: c.GeneratePresignedUrl,
+		conf:   conf,
+		prefix: c.PathPrefix,
 	}
 
 	var opts []option.ClientOption
@@ -62,6 +60,7 @@ func newGCPUploader(c *config.StorageConfig) (uploader, error) {
 			return nil, errors.ErrUploadFailed("GCP", err)
 		}
 		opts = append(opts, option.WithTokenSource(jwtConfig.TokenSource(context.Background())))
+		u.jwtConfig = jwtConfig
 	}
 
 	defaultTransport := http.DefaultTransport.(*http.Transport)
@@ -92,7 +91,7 @@ func newGCPUploader(c *config.StorageConfig) (uploader, error) {
 	return u, nil
 }
 
-func (u *GCPUploader) upload(localFilepath, storageFilepath string, _ types.OutputType) (string, int64, error) {
+func (u *GCPUploader) upload(ctx context.Context, localFilepath, storageFilepath string, _ types.OutputType, d *digest) (string, int64, error) {
 	storageFilepath = path.Join(u.prefix, storageFilepath)
 
 	file, err := os.Open(localFilepath)
@@ -103,11 +102,6 @@ func (u *GCPUploader) upload(localFilepath, storageFilepath string, _ types.Outp
 		_ = file.Close()
 	}()
 
-	stat, err := file.Stat()
-	if err != nil {
-		return "", 0, errors.ErrUploadFailed("GCP", err)
-	}
-
 	wc := u.client.Bucket(u.conf.Bucket).Object(storageFilepath).Retryer(
 		storage.WithBackoff(gax.Backoff{
 			Initial:    minDelay,
@@ -116,16 +110,62 @@ func (u *GCPUploader) upload(localFilepath, storageFilepath string, _ types.Outp
 		}),
 		storage.WithMaxAttempts(maxRetries),
 		storage.WithPolicy(storage.RetryAlways),
-	).NewWriter(context.Background())
+	).NewWriter(ctx)
 	wc.ChunkRetryDeadline = 0
 
-	if _, err = io.Copy(wc, file); err != nil {
+	if _, err = io.Copy(wc, d.reader(file)); err != nil {
 		return "", 0, errors.ErrUploadFailed("GCP", err)
 	}
 
 	if err = wc.Close(); err != nil {
 		return "", 0, errors.ErrUploadFailed("GCP", err)
 	}
+	if attrs := wc.Attrs(); attrs != nil && len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, d.md5Sum()) {
+		return "", 0, errors.ErrUploadFailed("GCP", fmt.Errorf("checksum mismatch: expected md5 %x, received %x", d.md5Sum(), attrs.MD5))
+	}
 
-	return fmt.Sprintf("https://%s.storage.googleapis.com/%s", u.conf.Bucket, storageFilepath), stat.Size(), nil
+	return fmt.Sprintf("https://%s.storage.googleapis.com/%s", u.conf.Bucket, storageFilepath), d.size, nil
+}
+
+// presign returns a V4 signed url. Without credentials json, the client's default credentials must be able to sign.
+func (u *GCPUploader) presign(_ context.Context, storageFilepath string, expiry time.Duration) (string, error) {
+	opts := &storage.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(expiry),
+		Scheme:  storage.SigningSchemeV4,
+	}
+	if u.jwtConfig != nil {
+		opts.GoogleAccessID = u.jwtConfig.Email
+		opts.PrivateKey = u.jwtConfig.PrivateKey
+	}
+
+	location, err := u.client.Bucket(u.conf.Bucket).SignedURL(path.Join(u.prefix, storageFilepath), opts)
+	if err != nil {
+		return "", errors.ErrUploadFailed("GCP", err)
+	}
+	return location, nil
+}
+
+func (u *GCPUploader) download(ctx context.Context, storageFilepath string) ([]byte, error) {
+	r, err := u.client.Bucket(u.conf.Bucket).Object(path.Join(u.prefix, storageFilepath)).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, os.ErrNotExist
+		}
+		return nil, errors.ErrUploadFailed("GCP", err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.ErrUploadFailed("GCP", err)
+	}
+	return b, nil
+}
+
+func (u *GCPUploader) delete(ctx context.Context, storageFilepath string) error {
+	if err := u.client.Bucket(u.conf.Bucket).Object(path.Join(u.prefix, storageFilepath)).Delete(ctx); err != nil {
+		return errors.ErrUploadFailed("GCP", err)
+	}
+	return nil
 }
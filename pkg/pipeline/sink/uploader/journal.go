@@ -0,0 +1,260 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+)
+
+const JournalFilename = "upload_journal.jsonl"
+
+// Journal is an append-only record of queued uploads, kept in the egress tmp dir.
+// If the handler exits before finishing its uploads, the service uses it to complete them.
+// A nil Journal records nothing.
+type Journal struct {
+	mu           sync.Mutex
+	file         *os.File
+	destinations int
+	uploads      int64
+}
+
+type journalRecord struct {
+	Info        json.RawMessage     `json:"info,omitempty"`
+	Destination *JournalDestination `json:"destination,omitempty"`
+	Upload      *JournalUpload      `json:"upload,omitempty"`
+	Done        int64               `json:"done,omitempty"`
+}
+
+// JournalDestination identifies storage by config hash, so that credentials are never written to disk.
+// Recovery resolves the hashes against the service config, which means storage supplied by the request
+// can only be recovered through the backup. Mirrors are not recovered.
+type JournalDestination struct {
+	ID         int              `json:"id"`
+	EgressType types.EgressType `json:"egress_type"`
+	Storage    string           `json:"storage"`
+	Backup     string           `json:"backup,omitempty"`
+}
+
+type JournalUpload struct {
+	ID              int64            `json:"id"`
+	Destination     int              `json:"destination"`
+	LocalFilepath   string           `json:"local_filepath"`
+	StorageFilepath string           `json:"storage_filepath"`
+	OutputType      types.OutputType `json:"output_type"`
+	Playlist        bool             `json:"playlist,omitempty"` // playlists are uploaded after all other files
+}
+
+// PendingUploads is the state recovered from a journal
+type PendingUploads struct {
+	Info         *livekit.EgressInfo
+	Destinations map[int]*JournalDestination
+	Uploads      []*JournalUpload
+}
+
+func OpenJournal(dir string, info *livekit.EgressInfo) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path.Join(dir, JournalFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{file: f}
+	j.SaveInfo(info)
+	return j, nil
+}
+
+// SaveInfo records the latest egress info, which is sent as the final update after recovery
+func (j *Journal) SaveInfo(info *livekit.EgressInfo) {
+	if j == nil {
+		return
+	}
+
+	b, err := protojson.Marshal(info)
+	if err != nil {
+		logger.Warnw("failed to marshal egress info", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.write(&journalRecord{Info: b})
+}
+
+func (j *Journal) addDestination(egressType types.EgressType, storage, backup *config.StorageConfig) int {
+	d := &JournalDestination{
+		EgressType: egressType,
+		Storage:    storageHash(storage),
+	}
+	if backup != nil {
+		d.Backup = storageHash(backup)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.destinations++
+	d.ID = j.destinations
+	j.write(&journalRecord{Destination: d})
+	return j.destinations
+}
+
+// Resolve returns the storage configs used by the destination, looked up in the service config.
+// If the primary cannot be found, the backup is used in its place.
+func (d *JournalDestination) Resolve(conf *config.BaseConfig) (*config.StorageConfig, *config.StorageConfig, error) {
+	var storage, backup *config.StorageConfig
+	if storageHash(conf.StorageConfig) == d.Storage {
+		storage = conf.StorageConfig
+	}
+	if d.Backup != "" && conf.BackupConfig != nil && storageHash(conf.BackupConfig) == d.Backup {
+		backup = conf.BackupConfig
+	}
+
+	switch {
+	case storage == nil && backup == nil:
+		return nil, nil, fmt.Errorf("storage not found in service config")
+	case storage == nil:
+		storage, backup = backup, nil
+	}
+
+	return storage, backup, nil
+}
+
+// storageHash identifies a storage config without revealing its credentials
+func storageHash(conf *config.StorageConfig) string {
+	b, _ := json.Marshal(conf)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+func (j *Journal) add(upload *JournalUpload) int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.uploads++
+	upload.ID = j.uploads
+	j.write(&journalRecord{Upload: upload})
+	return upload.ID
+}
+
+func (j *Journal) done(id int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.write(&journalRecord{Done: id})
+}
+
+// write must be called while holding the lock. Journal failures are logged, but never fail the egress.
+func (j *Journal) write(record *journalRecord) {
+	if j.file == nil {
+		return
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		logger.Warnw("failed to marshal journal record", err)
+		return
+	}
+	if _, err = j.file.Write(append(b, '\n')); err != nil {
+		logger.Warnw("failed to write journal record", err)
+		return
+	}
+	if err = j.file.Sync(); err != nil {
+		logger.Warnw("failed to sync journal", err)
+	}
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// ReadJournal returns the uploads which were queued but never completed, in the order they were queued.
+// A partially written final record, left by a crash mid-write, is ignored.
+func ReadJournal(dir string) (*PendingUploads, error) {
+	f, err := os.Open(path.Join(dir, JournalFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &PendingUploads{
+		Destinations: make(map[int]*JournalDestination),
+	}
+	var uploads []*JournalUpload
+	completed := make(map[int64]bool)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record := &journalRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), record); err != nil {
+			logger.Warnw("failed to read journal record", err)
+			break
+		}
+
+		switch {
+		case record.Info != nil:
+			info := &livekit.EgressInfo{}
+			if err = protojson.Unmarshal(record.Info, info); err != nil {
+				logger.Warnw("failed to unmarshal egress info", err)
+				continue
+			}
+			p.Info = info
+		case record.Destination != nil:
+			p.Destinations[record.Destination.ID] = record.Destination
+		case record.Upload != nil:
+			uploads = append(uploads, record.Upload)
+		case record.Done != 0:
+			completed[record.Done] = true
+		}
+	}
+
+	for _, upload := range uploads {
+		if !completed[upload.ID] {
+			p.Uploads = append(p.Uploads, upload)
+		}
+	}
+
+	return p, nil
+}
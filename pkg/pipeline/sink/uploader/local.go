@@ -15,6 +15,8 @@
 package uploader
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"path"
@@ -27,11 +29,11 @@ type localUploader struct {
 	prefix string
 }
 
-func newLocalUploader(c *config.StorageConfig) (*localUploader, error) {
+func newLocalUploader(c *config.StorageConfig) (uploader, error) {
 	return &localUploader{prefix: c.PathPrefix}, nil
 }
 
-func (u *localUploader) upload(localFilepath, storageFilepath string, _ types.OutputType) (string, int64, error) {
+func (u *localUploader) upload(_ context.Context, localFilepath, storageFilepath string, _ types.OutputType, d *digest) (string, int64, error) {
 	storageFilepath = path.Join(u.prefix, storageFilepath)
 
 	stat, err := os.Stat(localFilepath)
@@ -56,10 +58,24 @@ func (u *localUploader) upload(localFilepath, storageFilepath string, _ types.Ou
 	}
 	defer storage.Close()
 
-	_, err = io.Copy(storage, local)
+	n, err := io.Copy(storage, d.reader(local))
 	if err != nil {
 		return "", 0, err
 	}
+	if n != stat.Size() {
+		return "", 0, fmt.Errorf("size mismatch: expected %d bytes, wrote %d", stat.Size(), n)
+	}
 
 	return storageFilepath, stat.Size(), nil
 }
+
+func (u *localUploader) download(_ context.Context, storageFilepath string) ([]byte, error) {
+	return os.ReadFile(path.Join(u.prefix, storageFilepath))
+}
+
+func (u *localUploader) delete(_ context.Context, storageFilepath string) error {
+	if err := os.Remove(path.Join(u.prefix, storageFilepath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+type retryPolicy struct {
+	maxAttempts int
+	minDelay    time.Duration
+	maxDelay    time.Duration
+}
+
+// newRetryPolicy applies the package defaults to any unset values
+func newRetryPolicy(attempts int, initialDelay, delayLimit time.Duration) *retryPolicy {
+	r := &retryPolicy{
+		maxAttempts: attempts,
+		minDelay:    initialDelay,
+		maxDelay:    delayLimit,
+	}
+	if r.maxAttempts <= 0 {
+		r.maxAttempts = maxRetries
+	}
+	if r.minDelay <= 0 {
+		r.minDelay = minDelay
+	}
+	if r.maxDelay <= 0 {
+		r.maxDelay = maxDelay
+	}
+	r.maxDelay = max(r.maxDelay, r.minDelay)
+	return r
+}
+
+// do calls f until it succeeds, attempts are exhausted, or the context is done.
+// Attempts should use ctx, so that cancelling it also aborts the attempt in progress.
+func (r *retryPolicy) do(ctx context.Context, name string, f func() error) error {
+	delay := r.minDelay
+
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s interrupted after %d attempts: %w", name, attempt, err)
+		}
+		if attempt >= r.maxAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+		}
+
+		logger.Debugw("upload attempt failed, retrying", "request", name, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s interrupted after %d attempts: %w", name, attempt, err)
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, r.maxDelay)
+	}
+}
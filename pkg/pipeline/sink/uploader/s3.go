@@ -18,12 +18,14 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
@@ -31,6 +33,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go/logging"
 
 	"github.com/livekit/egress/pkg/config"
@@ -45,10 +48,9 @@ const (
 )
 
 type S3Uploader struct {
-	conf                 *config.S3Config
-	prefix               string
-	generatePresignedUrl bool
-	awsConf              *aws.Config
+	conf    *config.S3Config
+	prefix  string
+	awsConf *aws.Config
 }
 
 func newS3Uploader(c *config.StorageConfig) (uploader, error) {
@@ -111,10 +113,9 @@ func newS3Uploader(c *config.StorageConfig) (uploader, error) {
 	}
 
 	return &S3Uploader{
-		conf:                 conf,
-		prefix:               c.PathPrefix,
-		generatePresignedUrl: c.GeneratePresignedUrl,
-		awsConf:              &awsConf,
+		conf:    conf,
+		prefix:  c.PathPrefix,
+		awsConf: &awsConf,
 	}, nil
 }
 
@@ -136,8 +137,10 @@ func updateRegion(awsConf *aws.Config, bucket string) error {
 }
 
 func (u *S3Uploader) upload(
+	ctx context.Context,
 	localFilepath, storageFilepath string,
 	outputType types.OutputType,
+	d *digest,
 ) (string, int64, error) {
 
 	storageFilepath = path.Join(u.prefix, storageFilepath)
@@ -164,13 +167,18 @@ func (u *S3Uploader) upload(
 		o.UsePathStyle = u.conf.ForcePathStyle
 	})
 
+	// the upload manager buffers each part as it reads the body, so the file is hashed while it is sent
 	input := &s3.PutObjectInput{
-		Body:        file,
+		Body:        d.reader(file),
 		Bucket:      aws.String(u.conf.Bucket),
 		ContentType: aws.String(string(outputType)),
 		Key:         aws.String(storageFilepath),
 		Metadata:    u.conf.Metadata,
 	}
+	if u.conf.Endpoint == "" {
+		// s3 compatible services do not all support additional checksums
+		input.ChecksumAlgorithm = s3types.ChecksumAlgorithmSha256
+	}
 	if u.conf.Tagging != "" {
 		input.Tagging = &u.conf.Tagging
 	}
@@ -181,10 +189,16 @@ func (u *S3Uploader) upload(
 		input.ContentDisposition = &contentDisposition
 	}
 
-	if _, err = manager.NewUploader(client).Upload(context.Background(), input); err != nil {
+	out, err := manager.NewUploader(client).Upload(ctx, input)
+	if err != nil {
 		l.log()
 		return "", 0, errors.ErrUploadFailed("S3", err)
 	}
+	if out.UploadID == "" && out.ETag != nil {
+		if err = verifyETag(*out.ETag, d.md5Sum()); err != nil {
+			return "", 0, errors.ErrUploadFailed("S3", err)
+		}
+	}
 
 	endpoint := "s3.amazonaws.com"
 	if u.conf.Endpoint != "" {
@@ -198,19 +212,63 @@ func (u *S3Uploader) upload(
 		location = fmt.Sprintf("https://%s.%s/%s", u.conf.Bucket, endpoint, storageFilepath)
 	}
 
-	if !u.generatePresignedUrl {
-		return location, stat.Size(), nil
+	return location, stat.Size(), nil
+}
+
+func (u *S3Uploader) presign(ctx context.Context, storageFilepath string, expiry time.Duration) (string, error) {
+	client := s3.NewFromConfig(*u.awsConf, func(o *s3.Options) {
+		o.UsePathStyle = u.conf.ForcePathStyle
+	})
+
+	res, err := s3.NewPresignClient(client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.conf.Bucket),
+		Key:    aws.String(path.Join(u.prefix, storageFilepath)),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", errors.ErrUploadFailed("S3", err)
 	}
 
-	res, err := s3.NewPresignClient(client).PresignGetObject(context.Background(), &s3.GetObjectInput{
+	return res.URL, nil
+}
+
+func (u *S3Uploader) delete(ctx context.Context, storageFilepath string) error {
+	client := s3.NewFromConfig(*u.awsConf, func(o *s3.Options) {
+		o.UsePathStyle = u.conf.ForcePathStyle
+	})
+
+	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(u.conf.Bucket),
-		Key:    aws.String(storageFilepath),
+		Key:    aws.String(path.Join(u.prefix, storageFilepath)),
 	})
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed("S3", err)
+		return errors.ErrUploadFailed("S3", err)
+	}
+	return nil
+}
+
+func (u *S3Uploader) download(ctx context.Context, storageFilepath string) ([]byte, error) {
+	client := s3.NewFromConfig(*u.awsConf, func(o *s3.Options) {
+		o.UsePathStyle = u.conf.ForcePathStyle
+	})
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.conf.Bucket),
+		Key:    aws.String(path.Join(u.prefix, storageFilepath)),
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, os.ErrNotExist
+		}
+		return nil, errors.ErrUploadFailed("S3", err)
 	}
+	defer out.Body.Close()
 
-	return res.URL, stat.Size(), nil
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, errors.ErrUploadFailed("S3", err)
+	}
+	return b, nil
 }
 
 // s3Logger only logs aws messages on upload failure
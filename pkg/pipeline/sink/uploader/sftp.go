@@ -0,0 +1,290 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/types"
+)
+
+const sftpDialTimeout = time.Second * 10
+
+type SFTPUploader struct {
+	address   string
+	sshConfig *ssh.ClientConfig
+	prefix    string
+	retries   *retryPolicy
+
+	// the connection is shared between uploads, and replaced after a failure
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func newSFTPUploader(c *config.StorageConfig) (uploader, error) {
+	conf := c.SFTP
+	if conf.Address == "" {
+		return nil, fmt.Errorf("missing address")
+	}
+
+	address := conf.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	var auth []ssh.AuthMethod
+	if conf.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if conf.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(conf.PrivateKey), []byte(conf.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(conf.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if conf.Password != "" {
+		auth = append(auth, ssh.Password(conf.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("password or private key required")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case conf.HostKey != "":
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(conf.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key: %w", err)
+		}
+		hostKeyCallback = ssh.FixedHostKey(hostKey)
+	case conf.InsecureIgnoreHostKey:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("host key required")
+	}
+
+	return &SFTPUploader{
+		address: address,
+		sshConfig: &ssh.ClientConfig{
+			User:            conf.Username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         sftpDialTimeout,
+		},
+		prefix:  c.PathPrefix,
+		retries: newRetryPolicy(0, 0, 0),
+	}, nil
+}
+
+func (u *SFTPUploader) upload(ctx context.Context, localFilepath, storageFilepath string, _ types.OutputType, d *digest) (string, int64, error) {
+	storageFilepath = path.Join(u.prefix, storageFilepath)
+
+	var size int64
+	err := u.retries.do(ctx, "sftp upload", func() (err error) {
+		size, err = u.put(ctx, localFilepath, storageFilepath, d)
+		return err
+	})
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed("SFTP", err)
+	}
+
+	return u.location(storageFilepath), size, nil
+}
+
+func (u *SFTPUploader) put(ctx context.Context, localFilepath, storageFilepath string, d *digest) (int64, error) {
+	client, err := u.getClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := u.write(client, localFilepath, storageFilepath, d)
+	if err != nil {
+		// the connection may be broken, so the next attempt reconnects
+		u.closeClient(client)
+		return 0, err
+	}
+	return size, nil
+}
+
+func (u *SFTPUploader) write(client *sftp.Client, localFilepath, storageFilepath string, d *digest) (int64, error) {
+	if dir := path.Dir(storageFilepath); dir != "." && dir != "/" {
+		if err := client.MkdirAll(dir); err != nil {
+			return 0, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	local, err := os.Open(localFilepath)
+	if err != nil {
+		return 0, err
+	}
+	defer local.Close()
+
+	localStat, err := local.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := localStat.Size()
+
+	remote, err := client.OpenFile(storageFilepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := remote.ReadFromWithConcurrency(d.reader(local), 0)
+	if err != nil {
+		_ = remote.Close()
+		return 0, err
+	}
+	if err = remote.Close(); err != nil {
+		return 0, err
+	}
+	if n != size {
+		return 0, fmt.Errorf("size mismatch: expected %d bytes, wrote %d", size, n)
+	}
+
+	stat, err := client.Stat(storageFilepath)
+	if err != nil {
+		return 0, err
+	}
+	if stat.Size() != size {
+		return 0, fmt.Errorf("size mismatch: expected %d bytes, received %d", size, stat.Size())
+	}
+	return size, nil
+}
+
+func (u *SFTPUploader) delete(ctx context.Context, storageFilepath string) error {
+	storageFilepath = path.Join(u.prefix, storageFilepath)
+	err := u.retries.do(ctx, "sftp delete", func() error {
+		client, err := u.getClient(ctx)
+		if err != nil {
+			return err
+		}
+		if err = client.Remove(storageFilepath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			u.closeClient(client)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.ErrUploadFailed("SFTP", err)
+	}
+	return nil
+}
+
+func (u *SFTPUploader) download(ctx context.Context, storageFilepath string) ([]byte, error) {
+	storageFilepath = path.Join(u.prefix, storageFilepath)
+
+	var b []byte
+	var notFound bool
+	err := u.retries.do(ctx, "sftp download", func() error {
+		client, err := u.getClient(ctx)
+		if err != nil {
+			return err
+		}
+		f, err := client.Open(storageFilepath)
+		if errors.Is(err, os.ErrNotExist) {
+			notFound = true
+			return nil
+		}
+		if err != nil {
+			u.closeClient(client)
+			return err
+		}
+		defer f.Close()
+
+		b, err = io.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		return nil, errors.ErrUploadFailed("SFTP", err)
+	}
+	if notFound {
+		return nil, os.ErrNotExist
+	}
+	return b, nil
+}
+
+func (u *SFTPUploader) getClient(ctx context.Context) (*sftp.Client, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.client != nil {
+		return u.client, nil
+	}
+
+	dialer := &net.Dialer{Timeout: sftpDialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", u.address)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, u.address, u.sshConfig)
+	if err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+	conn := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	u.conn = conn
+	u.client = client
+	return client, nil
+}
+
+func (u *SFTPUploader) closeClient(client *sftp.Client) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// another upload may have replaced it already
+	if u.client != client {
+		return
+	}
+	_ = u.client.Close()
+	_ = u.conn.Close()
+	u.client = nil
+	u.conn = nil
+}
+
+// location returns an sftp url, where paths relative to the login directory are prefixed with ~
+func (u *SFTPUploader) location(storageFilepath string) string {
+	p := storageFilepath
+	if !strings.HasPrefix(p, "/") {
+		p = "/~/" + p
+	}
+	return (&url.URL{Scheme: "sftp", Host: u.address, Path: p}).String()
+}
@@ -2,16 +2,25 @@ package uploader
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
 	"encoding/json"
-	"github.com/livekit/egress/pkg/errors"
-	"github.com/livekit/egress/pkg/types"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/stats"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/psrpc"
 )
 
+const defaultPartConcurrency = 4
+
 type GetFileCredsReq struct {
 	FileSize int64  `json:"file_size"`
 	FilePath string `json:"file_path"`
@@ -21,21 +30,60 @@ type GetFileCredsReq struct {
 type GetFileCredsResp struct {
 	UploadURL      string `json:"upload_url"`
 	ReturnLocation string `json:"return_location"`
+
+	// set instead of UploadURL for multipart uploads
+	PartSize  int64    `json:"part_size,omitempty"`
+	PartURLs  []string `json:"part_urls,omitempty"`
+	CommitURL string   `json:"commit_url,omitempty"`
+}
+
+type GetReadURLReq struct {
+	FilePath  string `json:"file_path"`
+	ExpiresIn int64  `json:"expires_in"` // seconds
+}
+
+type GetReadURLResp struct {
+	URL string `json:"url"`
+}
+
+type CommitPartsReq struct {
+	Parts  []*UploadedPart `json:"parts"`
+	SHA256 string          `json:"sha256,omitempty"` // hex, of the whole file
+	MD5    string          `json:"md5,omitempty"`    // hex, of the whole file
+}
+
+type UploadedPart struct {
+	PartNumber int    `json:"part_number"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
 }
 
 type SilooUploader struct {
-	grahamAddress string
+	conf    *config.GrahamConfig
+	retries *retryPolicy
+	monitor *stats.HandlerMonitor
 }
 
-func NewSilooUploader(grahamAddress string) (*SilooUploader, error) {
-	silooUploader := &SilooUploader{
-		grahamAddress: grahamAddress,
+func newSilooUploader(c *config.StorageConfig) (uploader, error) {
+	conf := c.Graham
+	if conf.Address == "" {
+		return nil, errors.New("missing graham address")
+	}
+	if c.GeneratePresignedUrl && conf.PresignAddress == "" {
+		return nil, errors.New("presigned URLs require a graham presign address")
 	}
 
-	return silooUploader, nil
+	return &SilooUploader{
+		conf:    conf,
+		retries: newRetryPolicy(conf.MaxAttempts, conf.MinRetryDelay, conf.MaxRetryDelay),
+	}, nil
+}
+
+func (s *SilooUploader) setMonitor(monitor *stats.HandlerMonitor) {
+	s.monitor = monitor
 }
 
-func (s *SilooUploader) upload(localFilepath, storageFilepath string, outputType types.OutputType) (string, int64, error) {
+func (s *SilooUploader) upload(ctx context.Context, localFilepath, storageFilepath string, outputType types.OutputType, d *digest) (string, int64, error) {
 	fileStats, err := os.Stat(localFilepath)
 	if err != nil {
 		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to get file stats: "+err.Error()))
@@ -45,6 +93,12 @@ func (s *SilooUploader) upload(localFilepath, storageFilepath string, outputType
 		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("File is empty"))
 	}
 
+	if s.conf.UploadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.conf.UploadTimeout)
+		defer cancel()
+	}
+
 	req := &GetFileCredsReq{
 		FileSize: fileStats.Size(),
 		FilePath: storageFilepath,
@@ -55,79 +109,295 @@ func (s *SilooUploader) upload(localFilepath, storageFilepath string, outputType
 		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to marshal GetFileCredsReq"+err.Error()))
 	}
 
-	var resp *http.Response
-	for range 60 {
-		resp, err = http.Post(s.grahamAddress, "text/json", bytes.NewReader(reqBytes))
-		if err == nil && resp.StatusCode == 200 {
-			break
-		} else {
-			if err != nil {
-				log.Println("Failed to get url, retrying?: " + err.Error())
-			} else {
-				log.Println("Failed to get url, retrying?: status code: " + resp.Status)
-				err = errors.New("Failed to get url: " + resp.Status)
-			}
-		}
-		time.Sleep(time.Minute)
+	var respObj *GetFileCredsResp
+	err = s.retries.do(ctx, "graham request", func() error {
+		respObj, err = s.getFileCreds(ctx, reqBytes)
+		return err
+	})
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed(storageFilepath, err)
+	}
+
+	if len(respObj.PartURLs) > 0 {
+		err = s.putParts(ctx, respObj, localFilepath, fileStats.Size(), outputType, d)
+	} else {
+		err = s.retries.do(ctx, "siloo upload", func() error {
+			return s.putFile(ctx, respObj.UploadURL, localFilepath, fileStats.Size(), d)
+		})
 	}
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to send req to Graham: "+err.Error()))
+		return "", 0, errors.ErrUploadFailed(storageFilepath, err)
+	}
+
+	return respObj.ReturnLocation, fileStats.Size(), nil
+}
+
+func (s *SilooUploader) getFileCreds(ctx context.Context, reqBytes []byte) (*GetFileCredsResp, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.Address, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to read resp body: "+err.Error()))
+		return nil, errors.New("Failed to read resp body: " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get url: %s", resp.Status)
 	}
 
 	respObj := new(GetFileCredsResp)
-	err = json.Unmarshal(respBytes, respObj)
+	if err = json.Unmarshal(respBytes, respObj); err != nil {
+		return nil, errors.New("Failed to unmarshal response: " + err.Error() + ". response is: " + string(respBytes))
+	}
+
+	return respObj, nil
+}
+
+// presign requests a temporary read url from graham
+func (s *SilooUploader) presign(ctx context.Context, storageFilepath string, expiry time.Duration) (string, error) {
+	if s.conf.PresignAddress == "" {
+		return "", psrpc.NewErrorf(psrpc.Unimplemented, "presigned URLs require a graham presign address")
+	}
+
+	reqBytes, err := json.Marshal(&GetReadURLReq{
+		FilePath:  storageFilepath,
+		ExpiresIn: int64(expiry.Seconds()),
+	})
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to unmarshal response: "+err.Error()+". response is: "+string(respBytes)))
+		return "", err
 	}
 
-	var fileResp *http.Response
-	var file *os.File
-	var fileReq *http.Request
-	for range 60 {
-		if file != nil {
-			file.Close()
-		}
-		file, err = os.Open(localFilepath)
+	var location string
+	err = s.retries.do(ctx, "graham presign request", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.PresignAddress, bytes.NewReader(reqBytes))
 		if err != nil {
-			return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to open file: "+err.Error()))
+			return err
 		}
+		req.Header.Set("Content-Type", "application/json")
 
-		fileReq, err = http.NewRequest("PUT", respObj.UploadURL, file)
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
-			file.Close()
-			return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to create request: "+err.Error()))
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to get read url: %s", resp.Status)
+		}
+		res := &GetReadURLResp{}
+		if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+			return err
+		}
+		if res.URL == "" {
+			return errors.New("missing read url")
+		}
+		location = res.URL
+		return nil
+	})
+	if err != nil {
+		return "", errors.ErrUploadFailed(storageFilepath, err)
+	}
+	return location, nil
+}
+
+// putFile reopens the file on each attempt, so a failed attempt never leaves a partially read body behind
+func (s *SilooUploader) putFile(ctx context.Context, uploadURL, localFilepath string, size int64, d *digest) error {
+	file, err := os.Open(localFilepath)
+	if err != nil {
+		return errors.New("Failed to open file: " + err.Error())
+	}
+	defer file.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, d.reader(file))
+	if err != nil {
+		return errors.New("Failed to create request: " + err.Error())
+	}
+	req.ContentLength = size
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Non 200 status code " + resp.Status)
+	}
+	return verifyETag(resp.Header.Get("ETag"), d.md5Sum())
+}
+
+// putParts sends the file in fixed-size parts, retrying each part on its own so that only failed parts are resent.
+// Parts are read from the file in order and buffered, so that the whole file is hashed in a single read.
+func (s *SilooUploader) putParts(
+	ctx context.Context,
+	creds *GetFileCredsResp,
+	localFilepath string,
+	size int64,
+	outputType types.OutputType,
+	d *digest,
+) error {
+	if creds.CommitURL == "" {
+		return errors.New("missing commit url")
+	}
+
+	partSize := creds.PartSize
+	if partSize <= 0 {
+		partSize = (size + int64(len(creds.PartURLs)) - 1) / int64(len(creds.PartURLs))
+	}
+	count := int((size + partSize - 1) / partSize)
+	if count > len(creds.PartURLs) {
+		return fmt.Errorf("file requires %d parts, received %d part urls", count, len(creds.PartURLs))
+	}
+
+	file, err := os.Open(localFilepath)
+	if err != nil {
+		return errors.New("Failed to open file: " + err.Error())
+	}
+	defer file.Close()
+	body := d.reader(file)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := s.conf.PartConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPartConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var partErr error
+
+	parts := make([]*UploadedPart, count)
+	for i := range parts {
+		offset := int64(i) * partSize
+		part := &UploadedPart{
+			PartNumber: i + 1,
+			Size:       min(partSize, size-offset),
 		}
+		parts[i] = part
 
-		fileResp, err = http.DefaultClient.Do(fileReq)
-		if err == nil && fileResp.StatusCode == http.StatusOK {
-			defer fileResp.Body.Close()
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		buf := make([]byte, part.Size)
+		if _, err = io.ReadFull(body, buf); err != nil {
+			<-sem
+			errOnce.Do(func() {
+				partErr = err
+				cancel()
+			})
 			break
-		} else {
-			var errorText string
-			if err == nil {
-				errorText = "Non 200 status code " + fileResp.Status
-			} else {
-				errorText = err.Error()
-			}
-			log.Println("Failed to upload, retrying?: " + errorText)
 		}
-		time.Sleep(time.Minute)
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			err := s.retries.do(ctx, fmt.Sprintf("siloo part %d", part.PartNumber), func() error {
+				etag, err := s.putPart(ctx, creds.PartURLs[i], bytes.NewReader(buf), part.Size)
+				if err == nil {
+					sum := md5.Sum(buf)
+					err = verifyETag(etag, sum[:])
+				}
+				if err != nil {
+					if s.monitor != nil {
+						s.monitor.IncUploadPartFailure(string(outputType))
+					}
+					return err
+				}
+				if s.monitor != nil {
+					s.monitor.IncUploadPartSuccess(string(outputType), part.Size)
+				}
+				part.ETag = etag
+				return nil
+			})
+			if err != nil {
+				errOnce.Do(func() {
+					partErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if partErr != nil {
+		return partErr
+	}
+	if err = ctx.Err(); err != nil {
+		return err
 	}
+
+	checksums := d.checksums()
+	reqBytes, err := json.Marshal(&CommitPartsReq{
+		Parts:  parts,
+		SHA256: checksums.SHA256,
+		MD5:    checksums.MD5,
+	})
 	if err != nil {
-		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("Failed to upload to siloo: "+err.Error()))
+		return errors.New("Failed to marshal CommitPartsReq: " + err.Error())
 	}
-	if fileResp.StatusCode != http.StatusOK {
-		return "", 0, errors.ErrUploadFailed(storageFilepath, errors.New("File upload resp not OK: "+resp.Status))
+
+	return s.retries.do(ctx, "siloo commit", func() error {
+		return s.commitParts(ctx, creds.CommitURL, reqBytes)
+	})
+}
+
+func (s *SilooUploader) putPart(ctx context.Context, partURL string, body io.Reader, size int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, partURL, body)
+	if err != nil {
+		return "", errors.New("Failed to create request: " + err.Error())
 	}
-	if file != nil {
-		file.Close()
+	req.ContentLength = size
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
 
-	return respObj.ReturnLocation, fileStats.Size(), nil
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Non 200 status code " + resp.Status)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (s *SilooUploader) commitParts(ctx context.Context, commitURL string, reqBytes []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, commitURL, bytes.NewReader(reqBytes))
+	if err != nil {
+		return errors.New("Failed to create request: " + err.Error())
+	}
+	req.Header.Set("Content-Type", "text/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Failed to commit parts: " + resp.Status)
+	}
+	return nil
 }
@@ -15,11 +15,16 @@
 package uploader
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
 	"github.com/livekit/egress/pkg/stats"
 	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/livekit"
@@ -31,21 +36,119 @@ const (
 	maxRetries = 5
 	minDelay   = time.Millisecond * 100
 	maxDelay   = time.Second * 5
+
+	defaultPresignedUrlExpiry = time.Minute * 15
+
+	mirrorUploadTimeout        = time.Minute * 10
+	maxConcurrentMirrorUploads = 32
 )
 
 type uploader interface {
-	upload(string, string, types.OutputType) (string, int64, error)
+	upload(context.Context, string, string, types.OutputType, *digest) (string, int64, error)
+}
+
+// presigner is implemented by uploaders which can generate temporary read urls for uploaded files
+type presigner interface {
+	presign(ctx context.Context, storageFilepath string, expiry time.Duration) (string, error)
+}
+
+// deleter is implemented by uploaders which can remove uploaded files
+type deleter interface {
+	delete(ctx context.Context, storageFilepath string) error
+}
+
+// downloader is implemented by uploaders which can read back uploaded files.
+// Files which do not exist return os.ErrNotExist.
+type downloader interface {
+	download(ctx context.Context, storageFilepath string) ([]byte, error)
+}
+
+// monitoredUploader is implemented by uploaders which report progress beyond whole file uploads
+type monitoredUploader interface {
+	setMonitor(*stats.HandlerMonitor)
+}
+
+type backend struct {
+	name       string
+	configured func(*config.StorageConfig) bool
+	create     func(*config.StorageConfig) (uploader, error)
+}
+
+// backends are selected by whichever StorageConfig field is set. If none are set, files are stored locally.
+var backends = []backend{
+	{
+		name:       "S3",
+		configured: func(c *config.StorageConfig) bool { return c.S3 != nil },
+		create:     newS3Uploader,
+	},
+	{
+		name:       "GCP",
+		configured: func(c *config.StorageConfig) bool { return c.GCP != nil },
+		create:     newGCPUploader,
+	},
+	{
+		name:       "Azure",
+		configured: func(c *config.StorageConfig) bool { return c.Azure != nil },
+		create:     newAzureUploader,
+	},
+	{
+		name:       "AliOSS",
+		configured: func(c *config.StorageConfig) bool { return c.AliOSS != nil },
+		create:     newAliOSSUploader,
+	},
+	{
+		name:       "Siloo",
+		configured: func(c *config.StorageConfig) bool { return c.Graham != nil },
+		create:     newSilooUploader,
+	},
+	{
+		name:       "WebDAV",
+		configured: func(c *config.StorageConfig) bool { return c.WebDAV != nil },
+		create:     newWebDAVUploader,
+	},
+	{
+		name:       "SFTP",
+		configured: func(c *config.StorageConfig) bool { return c.SFTP != nil },
+		create:     newSFTPUploader,
+	},
+}
+
+// mirror receives a copy of every file, uploaded in the background
+type mirror struct {
+	uploader
+	conf   *config.StorageConfig
+	active chan struct{} // bounds uploads in progress. Further uploads wait for a slot
 }
 
 type Uploader struct {
-	primary       uploader
-	backup        uploader
-	primaryFailed bool
-	info          *livekit.EgressInfo
-	monitor       *stats.HandlerMonitor
+	primary uploader
+	backup  uploader
+	breaker *circuitBreaker
+	mirrors []*mirror
+	info    *livekit.EgressInfo
+	monitor *stats.HandlerMonitor
+
+	mu           sync.Mutex
+	interruptCtx context.Context
+	interrupt    context.CancelFunc
+	mirrorWG     sync.WaitGroup
+
+	conf          *config.StorageConfig
+	backupConf    *config.StorageConfig
+	journal       *Journal
+	destinationID int
+
+	encryptor *encryptor
 }
 
-func New(conf, backup *config.StorageConfig, monitor *stats.HandlerMonitor, info *livekit.EgressInfo) (*Uploader, error) {
+// New creates an uploader for a primary destination, with an optional backup used when it fails,
+// and mirrors which receive a copy of every file in the background
+func New(
+	conf, backup *config.StorageConfig,
+	mirrors []*config.StorageConfig,
+	monitor *stats.HandlerMonitor,
+	info *livekit.EgressInfo,
+) (*Uploader, error) {
 	p, err := getUploader(conf)
 	if err != nil {
 		return nil, err
@@ -55,6 +158,16 @@ func New(conf, backup *config.StorageConfig, monitor *stats.HandlerMonitor, info
 		primary: p,
 		monitor: monitor,
 		info:    info,
+		conf:    conf,
+	}
+	if conf != nil && conf.Encryption != nil {
+		if u.encryptor, err = newEncryptor(conf.Encryption); err != nil {
+			return nil, errors.ErrInvalidStorage("encryption", err)
+		}
+	}
+	u.interruptCtx, u.interrupt = context.WithCancel(context.Background())
+	if m, ok := p.(monitoredUploader); ok && monitor != nil {
+		m.setMonitor(monitor)
 	}
 
 	if backup != nil {
@@ -63,51 +176,339 @@ func New(conf, backup *config.StorageConfig, monitor *stats.HandlerMonitor, info
 			logger.Errorw("failed to create backup uploader", err)
 		} else {
 			u.backup = b
+			u.backupConf = backup
+			u.breaker = newCircuitBreaker(backup.Failover)
+			if m, ok := b.(monitoredUploader); ok && monitor != nil {
+				m.setMonitor(monitor)
+			}
+		}
+	}
+
+	for i, mirrorConf := range mirrors {
+		m, err := getUploader(mirrorConf)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %d: %w", i, err)
+		}
+		if mu, ok := m.(monitoredUploader); ok && monitor != nil {
+			mu.setMonitor(monitor)
 		}
+		u.mirrors = append(u.mirrors, &mirror{
+			uploader: m,
+			conf:     mirrorConf,
+			active:   make(chan struct{}, maxConcurrentMirrorUploads),
+		})
 	}
 
 	return u, nil
 }
 
 func getUploader(conf *config.StorageConfig) (uploader, error) {
-	if conf.Graham != nil {
-		return NewSilooUploader(conf.Graham.Address)
+	if conf == nil {
+		conf = &config.StorageConfig{}
+	}
+
+	var selected *backend
+	for i := range backends {
+		if !backends[i].configured(conf) {
+			continue
+		}
+		if selected != nil {
+			return nil, errors.ErrInvalidStorage(backends[i].name,
+				fmt.Errorf("cannot be combined with %s", selected.name))
+		}
+		selected = &backends[i]
+	}
+	if selected == nil {
+		if conf.GeneratePresignedUrl {
+			return nil, errors.ErrInvalidStorage("local", fmt.Errorf("presigned URLs not supported"))
+		}
+		return newLocalUploader(conf)
+	}
+
+	u, err := selected.create(conf)
+	if err != nil {
+		var psrpcErr psrpc.Error
+		if errors.As(err, &psrpcErr) && psrpcErr.Code() == psrpc.InvalidArgument {
+			return nil, err
+		}
+		return nil, errors.ErrInvalidStorage(selected.name, err)
+	}
+	if _, ok := u.(presigner); conf.GeneratePresignedUrl && !ok {
+		return nil, errors.ErrInvalidStorage(selected.name, fmt.Errorf("presigned URLs not supported"))
+	}
+
+	return u, nil
+}
+
+// SetJournal records pending uploads in j, so that they can be completed by the service if the handler exits first
+func (u *Uploader) SetJournal(j *Journal, egressType types.EgressType) {
+	if j == nil {
+		return
 	}
-	panic("no graham?")
+	u.journal = j
+	u.destinationID = j.addDestination(egressType, u.conf, u.backupConf)
 }
 
+// RecordPending journals a queued upload, returning an id to pass to RecordDone once it has been uploaded
+func (u *Uploader) RecordPending(localFilepath, storageFilepath string, outputType types.OutputType, playlist bool) int64 {
+	if u.journal == nil {
+		return 0
+	}
+
+	return u.journal.add(&JournalUpload{
+		Destination:     u.destinationID,
+		LocalFilepath:   localFilepath,
+		StorageFilepath: storageFilepath,
+		OutputType:      outputType,
+		Playlist:        playlist,
+	})
+}
+
+func (u *Uploader) RecordDone(id int64) {
+	if u.journal == nil || id == 0 {
+		return
+	}
+
+	u.journal.done(id)
+}
+
+// Encryption describes how files are encrypted before upload, or nil if they are not
+func (u *Uploader) Encryption() *config.Encryption {
+	if u.encryptor == nil {
+		return nil
+	}
+	return u.encryptor.info
+}
+
+// Interrupt aborts uploads in progress, including any attempt in flight, once the grace period has passed.
+// Uploads started afterwards are not affected.
+func (u *Uploader) Interrupt(grace time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if grace > 0 {
+		time.AfterFunc(grace, u.interrupt)
+	} else {
+		u.interrupt()
+	}
+	u.interruptCtx, u.interrupt = context.WithCancel(context.Background())
+}
+
+// Presign generates a temporary read url for a file previously uploaded to the primary or backup storage.
+// If expiry is zero, the configured expiry is used.
+func (u *Uploader) Presign(ctx context.Context, storageFilepath string, expiry time.Duration, backup bool) (string, error) {
+	up, conf := u.primary, u.conf
+	if backup {
+		if u.backup == nil {
+			return "", errors.ErrInvalidInput("backup")
+		}
+		up, conf = u.backup, u.backupConf
+	}
+
+	p, ok := up.(presigner)
+	if !ok {
+		return "", psrpc.NewErrorf(psrpc.Unimplemented, "presigned URLs not supported")
+	}
+	if expiry == 0 {
+		expiry = presignedUrlExpiry(conf)
+	}
+	return p.presign(ctx, storageFilepath, expiry)
+}
+
+// CanDelete returns true if files can be deleted from the primary and backup storage
+func (u *Uploader) CanDelete() bool {
+	if _, ok := u.primary.(deleter); !ok {
+		return false
+	}
+	if u.backup != nil {
+		if _, ok := u.backup.(deleter); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes a previously uploaded file from the primary, or the backup if it was uploaded there.
+// Mirrors are deleted from on a best effort basis, and only a primary or backup failure returns an error.
+func (u *Uploader) Delete(storageFilepath string, backup bool) error {
+	ctx := u.uploadContext()
+
+	for i, m := range u.mirrors {
+		d, ok := m.uploader.(deleter)
+		if !ok {
+			continue
+		}
+		if err := d.delete(ctx, storageFilepath); err != nil {
+			logger.Warnw("mirror delete failed", err, "filepath", storageFilepath, "mirror", i)
+		}
+	}
+
+	up := u.primary
+	if backup && u.backup != nil {
+		up = u.backup
+	}
+	d, ok := up.(deleter)
+	if !ok {
+		return psrpc.NewErrorf(psrpc.Unimplemented, "delete not supported")
+	}
+	return d.delete(ctx, storageFilepath)
+}
+
+// Download reads a previously uploaded file from the primary, falling back to the backup if it is not found there.
+// Returns os.ErrNotExist if neither has the file.
+func (u *Uploader) Download(storageFilepath string, outputType types.OutputType) ([]byte, error) {
+	if u.encryptor != nil && outputType != types.OutputTypeJSON {
+		return nil, psrpc.NewErrorf(psrpc.Unimplemented, "download not supported with encryption")
+	}
+	ctx := u.uploadContext()
+
+	var lastErr error
+	for _, up := range []uploader{u.primary, u.backup} {
+		if up == nil {
+			continue
+		}
+		d, ok := up.(downloader)
+		if !ok {
+			return nil, psrpc.NewErrorf(psrpc.Unimplemented, "download not supported")
+		}
+
+		b, err := d.download(ctx, storageFilepath)
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warnw("download failed", err, "filepath", storageFilepath)
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, os.ErrNotExist
+}
+
+// finishLocation replaces a location with a presigned url, if the destination is configured to generate them
+func finishLocation(ctx context.Context, up uploader, conf *config.StorageConfig, storageFilepath, location string) (string, error) {
+	if conf == nil || !conf.GeneratePresignedUrl {
+		return location, nil
+	}
+	return up.(presigner).presign(ctx, storageFilepath, presignedUrlExpiry(conf))
+}
+
+func presignedUrlExpiry(conf *config.StorageConfig) time.Duration {
+	if conf != nil && conf.PresignedUrlExpiry > 0 {
+		return conf.PresignedUrlExpiry
+	}
+	return defaultPresignedUrlExpiry
+}
+
+// uploadContext is cancelled by Interrupt
+func (u *Uploader) uploadContext() context.Context {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.interruptCtx
+}
+
+// Upload sends a file to the primary, falling back to the backup if it fails or has been failing recently.
+// Mirrors are uploaded to in the background, and only a primary failure returns an error.
+// Mirror results are filled in once Drain returns.
 func (u *Uploader) Upload(
 	localFilepath, storageFilepath string,
 	outputType types.OutputType,
 	deleteAfterUpload bool,
-) (string, int64, error) {
+) (string, int64, config.UploadInfo, error) {
+
+	// removed once every upload reading them has finished
+	var cleanup []string
 
-	var primaryErr error
-	if !u.primaryFailed {
+	// manifests are left unencrypted, since they hold the wrapped data keys
+	uploadFilepath := localFilepath
+	if u.encryptor != nil && outputType != types.OutputTypeJSON {
+		encryptedFilepath, err := u.encryptor.encryptFile(localFilepath)
+		if err != nil {
+			return "", 0, config.UploadInfo{}, errors.ErrUploadFailed(storageFilepath, err)
+		}
+		cleanup = append(cleanup, encryptedFilepath)
+		uploadFilepath = encryptedFilepath
+	}
+
+	mirrors, reading := u.uploadMirrors(uploadFilepath, storageFilepath, outputType)
+
+	d := newDigest()
+	location, size, backup, err := u.uploadPrimary(u.uploadContext(), uploadFilepath, storageFilepath, outputType, d)
+	if err == nil && deleteAfterUpload {
+		cleanup = append(cleanup, localFilepath)
+	}
+	if reading == nil {
+		removeFiles(cleanup)
+	} else {
+		u.mirrorWG.Add(1)
+		go func() {
+			defer u.mirrorWG.Done()
+			reading.Wait()
+			removeFiles(cleanup)
+		}()
+	}
+	if err != nil {
+		return "", 0, config.UploadInfo{}, err
+	}
+
+	return location, size, config.UploadInfo{
+		Checksums: d.checksums(),
+		Backup:    backup,
+		Mirrors:   mirrors,
+	}, nil
+}
+
+// Drain waits for mirror uploads in progress to finish, along with the cleanup of any files they were reading
+func (u *Uploader) Drain() {
+	u.mirrorWG.Wait()
+}
+
+func (u *Uploader) uploadPrimary(
+	ctx context.Context,
+	uploadFilepath, storageFilepath string,
+	outputType types.OutputType,
+	d *digest,
+) (string, int64, bool, error) {
+
+	// without a backup, the primary is always attempted
+	primaryErr := errPrimaryBypassed
+	if u.breaker == nil || u.breaker.allow() {
 		start := time.Now()
-		location, size, err := u.primary.upload(localFilepath, storageFilepath, outputType)
+		location, size, err := u.primary.upload(ctx, uploadFilepath, storageFilepath, outputType, d)
+		if err == nil {
+			location, err = finishLocation(ctx, u.primary, u.conf, storageFilepath, location)
+		}
 		elapsed := time.Since(start)
+		if u.breaker != nil {
+			if ctx.Err() != nil {
+				u.breaker.cancel()
+			} else {
+				u.breaker.record(err != nil)
+			}
+		}
 
 		if err == nil {
 			if u.monitor != nil {
 				u.monitor.IncUploadCountSuccess(string(outputType), float64(elapsed.Milliseconds()))
 			}
-			if deleteAfterUpload {
-				_ = os.Remove(localFilepath)
-			}
-			return location, size, nil
-		} else {
-			log.Println("Failed to upload totally: ", err.Error())
-			if u.monitor != nil {
-				u.monitor.IncUploadCountFailure(string(outputType), float64(elapsed.Milliseconds()))
-			}
-			u.primaryFailed = true
-			primaryErr = err
+			return location, size, false, nil
 		}
+
+		logger.Warnw("primary upload failed", err, "filepath", storageFilepath)
+		if u.monitor != nil {
+			u.monitor.IncUploadCountFailure(string(outputType), float64(elapsed.Milliseconds()))
+		}
+		primaryErr = err
 	}
 
 	if u.backup != nil {
-		location, size, backupErr := u.backup.upload(localFilepath, storageFilepath, outputType)
+		location, size, backupErr := u.backup.upload(ctx, uploadFilepath, storageFilepath, outputType, d)
+		if backupErr == nil {
+			location, backupErr = finishLocation(ctx, u.backup, u.backupConf, storageFilepath, location)
+		}
 		if backupErr == nil {
 			if u.info != nil {
 				u.info.SetBackupUsed()
@@ -115,15 +516,138 @@ func (u *Uploader) Upload(
 			if u.monitor != nil {
 				u.monitor.IncBackupStorageWrites(string(outputType))
 			}
-			if deleteAfterUpload {
-				_ = os.Remove(localFilepath)
-			}
-			return location, size, nil
+			return location, size, true, nil
 		}
 
-		return "", 0, psrpc.NewErrorf(psrpc.InvalidArgument,
+		return "", 0, false, psrpc.NewErrorf(psrpc.InvalidArgument,
 			"primary: %s\nbackup: %s", primaryErr.Error(), backupErr.Error())
 	}
 
-	return "", 0, primaryErr
+	return "", 0, false, primaryErr
+}
+
+// uploadMirrors queues an upload to each mirror. Playlists and manifests are rewritten in place, so mirrors
+// read a snapshot of them. Otherwise, the returned WaitGroup is done once the mirrors have finished reading the file.
+// Mirror uploads are aborted by Interrupt, like primary uploads.
+func (u *Uploader) uploadMirrors(uploadFilepath, storageFilepath string, outputType types.OutputType) ([]*config.MirrorUpload, *sync.WaitGroup) {
+	if len(u.mirrors) == 0 {
+		return nil, nil
+	}
+
+	results := make([]*config.MirrorUpload, len(u.mirrors))
+	for i := range results {
+		results[i] = &config.MirrorUpload{}
+	}
+
+	mirrorFilepath := uploadFilepath
+	snapshot := false
+	switch outputType {
+	case types.OutputTypeHLS, types.OutputTypeDASH, types.OutputTypeJSON:
+		var err error
+		if mirrorFilepath, err = copyFile(uploadFilepath); err != nil {
+			logger.Warnw("failed to snapshot file for mirrors", err, "filepath", storageFilepath)
+			for _, r := range results {
+				r.Error = err.Error()
+			}
+			return results, nil
+		}
+		snapshot = true
+	}
+
+	ctx := u.uploadContext()
+	reading := &sync.WaitGroup{}
+	for i, m := range u.mirrors {
+		reading.Add(1)
+		u.mirrorWG.Add(1)
+		go func(m *mirror, result *config.MirrorUpload) {
+			defer func() {
+				reading.Done()
+				u.mirrorWG.Done()
+			}()
+			u.uploadMirror(ctx, m, result, mirrorFilepath, storageFilepath, outputType)
+		}(m, results[i])
+	}
+
+	if snapshot {
+		u.mirrorWG.Add(1)
+		go func() {
+			defer u.mirrorWG.Done()
+			reading.Wait()
+			_ = os.Remove(mirrorFilepath)
+		}()
+		return results, nil
+	}
+	return results, reading
+}
+
+func (u *Uploader) uploadMirror(
+	ctx context.Context,
+	m *mirror,
+	result *config.MirrorUpload,
+	uploadFilepath, storageFilepath string,
+	outputType types.OutputType,
+) {
+	location, err := m.uploadWhenActive(ctx, uploadFilepath, storageFilepath, outputType)
+	if err != nil {
+		logger.Warnw("mirror upload failed", err, "filepath", storageFilepath)
+		if u.monitor != nil {
+			u.monitor.IncMirrorStorageWriteFailure(string(outputType))
+		}
+		result.Error = err.Error()
+		return
+	}
+
+	if u.monitor != nil {
+		u.monitor.IncMirrorStorageWriteSuccess(string(outputType))
+	}
+	result.Location = location
+}
+
+// uploadWhenActive waits for a slot, then uploads with a timeout
+func (m *mirror) uploadWhenActive(ctx context.Context, uploadFilepath, storageFilepath string, outputType types.OutputType) (string, error) {
+	select {
+	case m.active <- struct{}{}:
+		defer func() { <-m.active }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mirrorUploadTimeout)
+	defer cancel()
+
+	location, _, err := m.upload(ctx, uploadFilepath, storageFilepath, outputType, newDigest())
+	if err != nil {
+		return "", err
+	}
+	return finishLocation(ctx, m.uploader, m.conf, storageFilepath, location)
+}
+
+// copyFile copies a file to a temporary file in the same directory, returning its path
+func copyFile(filepath string) (string, error) {
+	src, err := os.Open(filepath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(path.Dir(filepath), path.Base(filepath)+".*")
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(dst, src); err == nil {
+		err = dst.Close()
+	} else {
+		_ = dst.Close()
+	}
+	if err != nil {
+		_ = os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+func removeFiles(files []string) {
+	for _, f := range files {
+		_ = os.Remove(f)
+	}
 }
@@ -1,16 +1,39 @@
 package uploader
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pkg/sftp"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
 
 	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/types"
 	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/psrpc"
 )
 
 func TestUploader(t *testing.T) {
@@ -39,13 +62,13 @@ func TestUploader(t *testing.T) {
 	}
 
 	info := &livekit.EgressInfo{}
-	u, err := New(primary, backup, nil, info)
+	u, err := New(primary, backup, nil, nil, info)
 	require.NoError(t, err)
 
 	filepath := "uploader_test.go"
 	storagePath := "uploader_test.go"
 
-	location, size, err := u.Upload(filepath, storagePath, "test/plain", false)
+	location, size, _, err := u.Upload(filepath, storagePath, "test/plain", false)
 	require.NoError(t, err)
 
 	require.NotZero(t, size)
@@ -62,3 +85,939 @@ func TestUploader(t *testing.T) {
 
 	require.True(t, strings.HasPrefix(string(b), "package uploader"))
 }
+
+func TestGetUploader(t *testing.T) {
+	u, err := getUploader(nil)
+	require.NoError(t, err)
+	require.IsType(t, &localUploader{}, u)
+
+	u, err = getUploader(&config.StorageConfig{PathPrefix: "prefix"})
+	require.NoError(t, err)
+	require.IsType(t, &localUploader{}, u)
+
+	u, err = getUploader(&config.StorageConfig{Graham: &config.GrahamConfig{Address: "http://localhost:8080"}})
+	require.NoError(t, err)
+	require.IsType(t, &SilooUploader{}, u)
+
+	for _, conf := range []*config.StorageConfig{
+		{Graham: &config.GrahamConfig{}},
+		{WebDAV: &config.WebDAVConfig{Url: "http://localhost"}, GeneratePresignedUrl: true},
+		{Graham: &config.GrahamConfig{Address: "http://localhost:8080"}, GeneratePresignedUrl: true},
+		{PathPrefix: "prefix", GeneratePresignedUrl: true},
+		{Azure: &config.AzureConfig{}, Graham: &config.GrahamConfig{Address: "http://localhost:8080"}},
+		{WebDAV: &config.WebDAVConfig{Url: "ftp://localhost"}},
+		{WebDAV: &config.WebDAVConfig{Url: "http://localhost", Username: "user", Token: "token"}},
+		{SFTP: &config.SFTPConfig{Address: "localhost", InsecureIgnoreHostKey: true}},
+		{SFTP: &config.SFTPConfig{Address: "localhost", Password: "password"}},
+	} {
+		_, err = getUploader(conf)
+		var psrpcErr psrpc.Error
+		require.True(t, errors.As(err, &psrpcErr))
+		require.Equal(t, psrpc.InvalidArgument, psrpcErr.Code())
+	}
+}
+
+func TestWebDAV(t *testing.T) {
+	dir := t.TempDir()
+	dav := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	var mkcols atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "MKCOL" {
+			mkcols.Add(1)
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := New(&config.StorageConfig{
+		PathPrefix: "recordings",
+		WebDAV: &config.WebDAVConfig{
+			Url:      server.URL + "/dav",
+			Username: "user",
+			Password: "password",
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile("uploader_test.go")
+	require.NoError(t, err)
+
+	for _, name := range []string{"room/a.go", "room/b.go"} {
+		location, size, _, err := u.Upload("uploader_test.go", name, "text/plain", false)
+		require.NoError(t, err)
+		require.Equal(t, server.URL+"/dav/recordings/"+name, location)
+		require.Equal(t, int64(len(data)), size)
+
+		uploaded, err := os.ReadFile(path.Join(dir, "recordings", name))
+		require.NoError(t, err)
+		require.Equal(t, data, uploaded)
+	}
+	require.Equal(t, int32(2), mkcols.Load())
+
+	downloaded, err := u.Download("room/a.go", "text/plain")
+	require.NoError(t, err)
+	require.Equal(t, data, downloaded)
+
+	require.True(t, u.CanDelete())
+	require.NoError(t, u.Delete("room/a.go", false))
+	_, err = os.Stat(path.Join(dir, "recordings", "room/a.go"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = u.Download("room/a.go", "text/plain")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	u, err = New(&config.StorageConfig{WebDAV: &config.WebDAVConfig{Url: server.URL + "/dav"}}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	u.primary.(*WebDAVUploader).retries = newRetryPolicy(1, 0, 0)
+	_, _, _, err = u.Upload("uploader_test.go", "c.go", "text/plain", false)
+	require.Error(t, err)
+}
+
+func TestSFTP(t *testing.T) {
+	dir := t.TempDir()
+	address, hostKey := startSFTPServer(t, dir, "password")
+
+	u, err := New(&config.StorageConfig{
+		PathPrefix: "recordings",
+		SFTP: &config.SFTPConfig{
+			Address:  address,
+			Username: "user",
+			Password: "password",
+			HostKey:  hostKey,
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile("uploader_test.go")
+	require.NoError(t, err)
+
+	for _, name := range []string{"room/a.go", "room/b.go"} {
+		location, size, _, err := u.Upload("uploader_test.go", name, "text/plain", false)
+		require.NoError(t, err)
+		require.Equal(t, "sftp://"+address+"/~/recordings/"+name, location)
+		require.Equal(t, int64(len(data)), size)
+
+		uploaded, err := os.ReadFile(path.Join(dir, "recordings", name))
+		require.NoError(t, err)
+		require.Equal(t, data, uploaded)
+	}
+
+	// wrong host key
+	signer := newTestSigner(t)
+	u, err = New(&config.StorageConfig{
+		SFTP: &config.SFTPConfig{
+			Address:  address,
+			Username: "user",
+			Password: "password",
+			HostKey:  string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	u.primary.(*SFTPUploader).retries = newRetryPolicy(1, 0, 0)
+	_, _, _, err = u.Upload("uploader_test.go", "c.go", "text/plain", false)
+	require.Error(t, err)
+}
+
+// startSFTPServer serves dir over sftp with password auth, returning its address and host key
+func startSFTPServer(t *testing.T, dir, password string) (string, string) {
+	signer := newTestSigner(t)
+	sshConfig := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != password {
+				return nil, errors.New("invalid password")
+			}
+			return nil, nil
+		},
+	}
+	sshConfig.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, sshConfig, dir)
+		}
+	}()
+
+	return l.Addr().String(), string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func serveSFTP(conn net.Conn, sshConfig *ssh.ServerConfig, dir string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, sshConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+				if err != nil {
+					return
+				}
+				_ = server.Serve()
+				_ = server.Close()
+				return
+			}
+		}()
+	}
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestSilooRetries(t *testing.T) {
+	var failures, puts atomic.Int32
+	var hang atomic.Bool
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/graham", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&GetFileCredsResp{
+			UploadURL:      server.URL + "/put",
+			ReturnLocation: "siloo://uploader_test.go",
+		})
+	})
+	mux.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
+		puts.Add(1)
+		if hang.Load() {
+			_, _ = io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+			return
+		}
+		if failures.Load() != 0 {
+			failures.Add(-1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.Copy(io.Discard, r.Body)
+	})
+
+	u, err := New(&config.StorageConfig{Graham: &config.GrahamConfig{
+		Address:       server.URL + "/graham",
+		MaxAttempts:   3,
+		MinRetryDelay: time.Millisecond,
+	}}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// succeeds on the last attempt
+	failures.Store(2)
+	location, size, _, err := u.Upload("uploader_test.go", "uploader_test.go", "text/plain", false)
+	require.NoError(t, err)
+	require.Equal(t, "siloo://uploader_test.go", location)
+	require.NotZero(t, size)
+	require.Equal(t, int32(3), puts.Load())
+
+	// gives up once attempts are exhausted
+	failures.Store(3)
+	_, _, _, err = u.Upload("uploader_test.go", "uploader_test.go", "text/plain", false)
+	require.Error(t, err)
+
+	u, err = New(&config.StorageConfig{Graham: &config.GrahamConfig{
+		Address:       server.URL + "/graham",
+		MaxAttempts:   1000,
+		MinRetryDelay: time.Millisecond * 10,
+		MaxRetryDelay: time.Millisecond * 10,
+	}}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// stops retrying when interrupted
+	failures.Store(1 << 30)
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := u.Upload("uploader_test.go", "uploader_test.go", "text/plain", false)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	u.Interrupt(0)
+
+	select {
+	case err = <-done:
+		require.Error(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("upload was not interrupted")
+	}
+
+	// aborts the attempt in progress when interrupted
+	failures.Store(0)
+	hang.Store(true)
+	go func() {
+		_, _, _, err := u.Upload("uploader_test.go", "uploader_test.go", "text/plain", false)
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	u.Interrupt(time.Millisecond * 50)
+
+	select {
+	case err = <-done:
+		require.Error(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("upload attempt was not aborted")
+	}
+}
+
+func TestPresign(t *testing.T) {
+	ctx := context.Background()
+
+	u, err := New(&config.StorageConfig{
+		PathPrefix: "prefix",
+		S3: &config.S3Config{
+			AccessKey:      "key",
+			Secret:         "secret",
+			Region:         "us-west-2",
+			Endpoint:       "http://localhost:9000",
+			Bucket:         "bucket",
+			ForcePathStyle: true,
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	location, err := u.Presign(ctx, "a.mp4", time.Hour, false)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location, "http://localhost:9000/bucket/prefix/a.mp4?"))
+	require.Contains(t, location, "X-Amz-Expires=3600")
+
+	u, err = New(&config.StorageConfig{
+		PresignedUrlExpiry: time.Minute,
+		Azure: &config.AzureConfig{
+			AccountName:   "account",
+			AccountKey:    base64.StdEncoding.EncodeToString([]byte("key")),
+			ContainerName: "container",
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	location, err = u.Presign(ctx, "a.mp4", 0, false)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location, "https://account.blob.core.windows.net/container/a.mp4?"))
+	require.Contains(t, location, "sp=r")
+	require.Contains(t, location, "sig=")
+
+	u, err = New(&config.StorageConfig{
+		AliOSS: &config.S3Config{
+			AccessKey: "key",
+			Secret:    "secret",
+			Endpoint:  "oss-cn-hangzhou.aliyuncs.com",
+			Bucket:    "bucket",
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	location, err = u.Presign(ctx, "a.mp4", time.Hour, false)
+	require.NoError(t, err)
+	require.Contains(t, location, "Signature=")
+
+	// siloo presigns through graham, and uploads return the presigned url when configured
+	var expiresIn atomic.Int64
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/graham", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&GetFileCredsResp{
+			UploadURL:      server.URL + "/put",
+			ReturnLocation: "siloo://a.mp4",
+		})
+	})
+	mux.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	})
+	mux.HandleFunc("/presign", func(w http.ResponseWriter, r *http.Request) {
+		req := &GetReadURLReq{}
+		_ = json.NewDecoder(r.Body).Decode(req)
+		expiresIn.Store(req.ExpiresIn)
+		_ = json.NewEncoder(w).Encode(&GetReadURLResp{URL: "https://siloo/" + req.FilePath + "?signed"})
+	})
+
+	u, err = New(&config.StorageConfig{
+		GeneratePresignedUrl: true,
+		Graham: &config.GrahamConfig{
+			Address:        server.URL + "/graham",
+			PresignAddress: server.URL + "/presign",
+		},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	location, _, _, err = u.Upload("uploader_test.go", "a.mp4", "video/mp4", false)
+	require.NoError(t, err)
+	require.Equal(t, "https://siloo/a.mp4?signed", location)
+	require.Equal(t, int64(defaultPresignedUrlExpiry.Seconds()), expiresIn.Load())
+
+	// local storage cannot presign
+	u, err = New(nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = u.Presign(ctx, "a.mp4", time.Hour, false)
+	require.Error(t, err)
+	_, err = u.Presign(ctx, "a.mp4", time.Hour, true)
+	require.Error(t, err)
+}
+
+func TestSilooMultipart(t *testing.T) {
+	data, err := os.ReadFile("uploader_test.go")
+	require.NoError(t, err)
+	partSize := int64(len(data)/3 + 1)
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	received := make(map[string][]byte)
+	var commit CommitPartsReq
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/graham", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&GetFileCredsResp{
+			ReturnLocation: "siloo://uploader_test.go",
+			PartSize:       partSize,
+			PartURLs:       []string{server.URL + "/part/1", server.URL + "/part/2", server.URL + "/part/3"},
+			CommitURL:      server.URL + "/commit",
+		})
+	})
+	mux.HandleFunc("/part/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts[r.URL.Path]++
+		if r.URL.Path == "/part/2" && attempts[r.URL.Path] == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received[r.URL.Path], _ = io.ReadAll(r.Body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(received[r.URL.Path])))
+	})
+	mux.HandleFunc("/commit", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&commit)
+	})
+
+	u, err := New(&config.StorageConfig{Graham: &config.GrahamConfig{
+		Address:       server.URL + "/graham",
+		MinRetryDelay: time.Millisecond,
+	}}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	location, size, _, err := u.Upload("uploader_test.go", "uploader_test.go", "text/plain", false)
+	require.NoError(t, err)
+	require.Equal(t, "siloo://uploader_test.go", location)
+	require.Equal(t, int64(len(data)), size)
+
+	// only the failed part is resent
+	require.Equal(t, map[string]int{"/part/1": 1, "/part/2": 2, "/part/3": 1}, attempts)
+
+	var joined []byte
+	require.Len(t, commit.Parts, 3)
+	for i, part := range commit.Parts {
+		p := fmt.Sprintf("/part/%d", i+1)
+		require.Equal(t, i+1, part.PartNumber)
+		require.Equal(t, fmt.Sprintf(`"%x"`, md5.Sum(received[p])), part.ETag)
+		require.Equal(t, int64(len(received[p])), part.Size)
+		joined = append(joined, received[p]...)
+	}
+	require.Equal(t, data, joined)
+
+	// checksums of the whole file are sent with the commit
+	sha := sha256.Sum256(data)
+	require.Equal(t, hex.EncodeToString(sha[:]), commit.SHA256)
+	require.Equal(t, fmt.Sprintf("%x", md5.Sum(data)), commit.MD5)
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := OpenJournal(dir, &livekit.EgressInfo{EgressId: "EG_journal"})
+	require.NoError(t, err)
+
+	storage := &config.StorageConfig{PathPrefix: "prefix", WebDAV: &config.WebDAVConfig{Url: "http://localhost", Password: "secret"}}
+	u, err := New(storage, nil, nil, nil, nil)
+	require.NoError(t, err)
+	u.SetJournal(j, types.EgressTypeSegments)
+
+	playlist := u.RecordPending("playlist.m3u8", "playlist.m3u8", types.OutputTypeHLS, true)
+	first := u.RecordPending("segment_0.ts", "segment_0.ts", types.OutputTypeTS, false)
+	u.RecordPending("segment_1.ts", "segment_1.ts", types.OutputTypeTS, false)
+	u.RecordDone(first)
+	j.SaveInfo(&livekit.EgressInfo{EgressId: "EG_journal", Status: livekit.EgressStatus_EGRESS_ACTIVE})
+	require.NoError(t, j.Close())
+
+	// simulate a crash mid-write
+	f, err := os.OpenFile(path.Join(dir, JournalFilename), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"done":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	pending, err := ReadJournal(dir)
+	require.NoError(t, err)
+	require.Equal(t, livekit.EgressStatus_EGRESS_ACTIVE, pending.Info.Status)
+	require.Len(t, pending.Destinations, 1)
+	require.Equal(t, types.EgressTypeSegments, pending.Destinations[1].EgressType)
+	b, err := os.ReadFile(path.Join(dir, JournalFilename))
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "secret")
+
+	// storage is resolved from the service config
+	resolved, _, err := pending.Destinations[1].Resolve(&config.BaseConfig{StorageConfig: storage})
+	require.NoError(t, err)
+	require.Equal(t, storage, resolved)
+	_, _, err = pending.Destinations[1].Resolve(&config.BaseConfig{StorageConfig: &config.StorageConfig{}})
+	require.Error(t, err)
+	require.Len(t, pending.Uploads, 2)
+	require.Equal(t, playlist, pending.Uploads[0].ID)
+	require.True(t, pending.Uploads[0].Playlist)
+	require.Equal(t, "segment_1.ts", pending.Uploads[1].StorageFilepath)
+
+	// egresses run without a journal if it can't be opened
+	var missing *Journal
+	u, err = New(storage, nil, nil, nil, nil)
+	require.NoError(t, err)
+	u.SetJournal(missing, types.EgressTypeSegments)
+	require.Zero(t, u.RecordPending("segment_2.ts", "segment_2.ts", types.OutputTypeTS, false))
+	missing.SaveInfo(&livekit.EgressInfo{EgressId: "EG_journal"})
+	require.NoError(t, missing.Close())
+}
+
+func TestChecksums(t *testing.T) {
+	data, err := os.ReadFile("uploader_test.go")
+	require.NoError(t, err)
+	sha := sha256.Sum256(data)
+	md := md5.Sum(data)
+
+	u, err := New(&config.StorageConfig{PathPrefix: t.TempDir()}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	location, size, uploadInfo, err := u.Upload("uploader_test.go", "copy.go", "text/plain", false)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	require.Equal(t, hex.EncodeToString(sha[:]), uploadInfo.SHA256)
+	require.Equal(t, hex.EncodeToString(md[:]), uploadInfo.MD5)
+
+	uploaded, err := os.ReadFile(location)
+	require.NoError(t, err)
+	require.Equal(t, data, uploaded)
+
+	require.NoError(t, verifyETag(fmt.Sprintf(`"%x"`, md), md[:]))
+	require.NoError(t, verifyETag(`"d41d8cd98f00b204e9800998ecf8427e-2"`, md[:]))
+	require.Error(t, verifyETag("d41d8cd98f00b204e9800998ecf8427e", md[:]))
+}
+
+type flakyUploader struct {
+	failing atomic.Bool
+	calls   atomic.Int32
+}
+
+func (f *flakyUploader) upload(_ context.Context, _, storageFilepath string, _ types.OutputType, d *digest) (string, int64, error) {
+	f.calls.Add(1)
+	if f.failing.Load() {
+		return "", 0, errors.New("unavailable")
+	}
+	return "primary/" + storageFilepath, d.size, nil
+}
+
+func TestFailover(t *testing.T) {
+	backupDir := t.TempDir()
+	info := &livekit.EgressInfo{}
+	u, err := New(nil, &config.StorageConfig{
+		PathPrefix: backupDir,
+		Failover: &config.FailoverConfig{
+			MinUploads: 4,
+			Cooldown:   time.Minute,
+		},
+	}, nil, nil, info)
+	require.NoError(t, err)
+
+	primary := &flakyUploader{}
+	u.primary = primary
+	now := time.Now()
+	u.breaker.now = func() time.Time { return now }
+
+	upload := func(name string) config.UploadInfo {
+		_, _, uploadInfo, err := u.Upload("uploader_test.go", name, "text/plain", false)
+		require.NoError(t, err)
+		return uploadInfo
+	}
+
+	// a single failure falls back for that file only
+	require.False(t, upload("1.go").Backup)
+	primary.failing.Store(true)
+	require.True(t, upload("2.go").Backup)
+	require.True(t, info.BackupStorageUsed)
+	primary.failing.Store(false)
+	require.False(t, upload("3.go").Backup)
+
+	// repeated failures open the breaker, and the primary is bypassed
+	primary.failing.Store(true)
+	require.True(t, upload("4.go").Backup)
+	calls := primary.calls.Load()
+	require.True(t, upload("5.go").Backup)
+	require.True(t, upload("6.go").Backup)
+	require.Equal(t, calls, primary.calls.Load())
+	_, err = os.Stat(path.Join(backupDir, "6.go"))
+	require.NoError(t, err)
+
+	// a failed probe after the cooldown keeps it open
+	now = now.Add(time.Minute)
+	require.True(t, upload("7.go").Backup)
+	require.Equal(t, calls+1, primary.calls.Load())
+	require.True(t, upload("8.go").Backup)
+	require.Equal(t, calls+1, primary.calls.Load())
+
+	// a successful probe closes it
+	now = now.Add(time.Minute)
+	primary.failing.Store(false)
+	require.False(t, upload("9.go").Backup)
+	require.False(t, upload("10.go").Backup)
+	require.Equal(t, calls+3, primary.calls.Load())
+}
+
+func TestFailoverInterrupted(t *testing.T) {
+	u, err := New(nil, &config.StorageConfig{
+		PathPrefix: t.TempDir(),
+		Failover:   &config.FailoverConfig{MinUploads: 1},
+	}, nil, nil, nil)
+	require.NoError(t, err)
+
+	// interrupted uploads are not counted as primary failures
+	primary := &blockingUploader{release: make(chan struct{})}
+	u.primary = primary
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _, _ = u.Upload("uploader_test.go", "copy.go", "text/plain", false)
+	}()
+	require.Eventually(t, func() bool {
+		return primary.started.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+	u.Interrupt(0)
+	<-done
+	require.Equal(t, breakerClosed, u.breaker.state)
+	require.Empty(t, u.breaker.results)
+
+	// an interrupted probe lets the next upload probe
+	u.breaker.state = breakerOpen
+	require.True(t, u.breaker.allow())
+	u.breaker.cancel()
+	require.True(t, u.breaker.allow())
+	require.Equal(t, breakerHalfOpen, u.breaker.state)
+}
+
+func TestFailoverWithoutBackup(t *testing.T) {
+	u, err := New(nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, u.breaker)
+
+	primary := &flakyUploader{}
+	primary.failing.Store(true)
+	u.primary = primary
+	for i := 0; i < 5; i++ {
+		_, _, _, err = u.Upload("uploader_test.go", "copy.go", "text/plain", false)
+		require.Error(t, err)
+	}
+	require.Equal(t, int32(5), primary.calls.Load())
+}
+
+func TestMirrors(t *testing.T) {
+	primaryDir, mirrorDir := t.TempDir(), t.TempDir()
+	u, err := New(&config.StorageConfig{PathPrefix: primaryDir}, nil, []*config.StorageConfig{
+		{PathPrefix: mirrorDir},
+		{PathPrefix: t.TempDir()},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	failing := &flakyUploader{}
+	failing.failing.Store(true)
+	u.mirrors[1].uploader = failing
+
+	location, _, uploadInfo, err := u.Upload("uploader_test.go", "copy.go", "text/plain", false)
+	require.NoError(t, err)
+	require.Equal(t, path.Join(primaryDir, "copy.go"), location)
+	u.Drain()
+	require.Len(t, uploadInfo.Mirrors, 2)
+	require.Equal(t, path.Join(mirrorDir, "copy.go"), uploadInfo.Mirrors[0].Location)
+	require.Empty(t, uploadInfo.Mirrors[0].Error)
+	require.Empty(t, uploadInfo.Mirrors[1].Location)
+	require.NotEmpty(t, uploadInfo.Mirrors[1].Error)
+
+	_, err = os.Stat(path.Join(mirrorDir, "copy.go"))
+	require.NoError(t, err)
+
+	// deletes reach the mirrors, and mirrors without delete support are skipped
+	require.NoError(t, u.Delete("copy.go", false))
+	for _, dir := range []string{primaryDir, mirrorDir} {
+		_, err = os.Stat(path.Join(dir, "copy.go"))
+		require.True(t, os.IsNotExist(err))
+	}
+
+	// a primary failure fails the upload, even if mirrors succeed
+	u.primary = failing
+	_, _, _, err = u.Upload("uploader_test.go", "copy.go", "text/plain", false)
+	require.Error(t, err)
+	u.Drain()
+}
+
+type blockingUploader struct {
+	release chan struct{}
+	started atomic.Int32
+}
+
+func (b *blockingUploader) upload(ctx context.Context, localFilepath, storageFilepath string, _ types.OutputType, d *digest) (string, int64, error) {
+	b.started.Add(1)
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return "", 0, ctx.Err()
+	}
+	f, err := os.Open(localFilepath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(io.Discard, d.reader(f)); err != nil {
+		return "", 0, err
+	}
+	return "mirror/" + storageFilepath, d.size, nil
+}
+
+func TestMirrorsDoNotBlockPrimary(t *testing.T) {
+	dir := t.TempDir()
+	playlist := path.Join(dir, "playlist.m3u8")
+	require.NoError(t, os.WriteFile(playlist, []byte("#EXTM3U\n"), 0644))
+
+	u, err := New(&config.StorageConfig{PathPrefix: t.TempDir()}, nil, []*config.StorageConfig{{}}, nil, nil)
+	require.NoError(t, err)
+	blocking := &blockingUploader{release: make(chan struct{})}
+	u.mirrors[0].uploader = blocking
+
+	// the primary upload returns while the mirror is still pending
+	_, _, uploadInfo, err := u.Upload(playlist, "playlist.m3u8", types.OutputTypeHLS, true)
+	require.NoError(t, err)
+	require.Len(t, uploadInfo.Mirrors, 1)
+
+	// the playlist can be rewritten or removed, since the mirror reads a snapshot
+	_, err = os.Stat(playlist)
+	require.True(t, os.IsNotExist(err))
+
+	close(blocking.release)
+	u.Drain()
+	require.Equal(t, "mirror/playlist.m3u8", uploadInfo.Mirrors[0].Location)
+	require.Empty(t, uploadInfo.Mirrors[0].Error)
+
+	// snapshots are cleaned up
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestMirrorQueue(t *testing.T) {
+	u, err := New(&config.StorageConfig{PathPrefix: t.TempDir()}, nil, []*config.StorageConfig{{}}, nil, nil)
+	require.NoError(t, err)
+	blocking := &blockingUploader{release: make(chan struct{})}
+	u.mirrors[0].uploader = blocking
+
+	// uploads beyond the concurrency limit are queued, not skipped
+	var results []config.UploadInfo
+	for i := 0; i < maxConcurrentMirrorUploads+2; i++ {
+		_, _, uploadInfo, err := u.Upload("uploader_test.go", fmt.Sprintf("copy_%d.go", i), "text/plain", false)
+		require.NoError(t, err)
+		results = append(results, uploadInfo)
+	}
+	close(blocking.release)
+	u.Drain()
+	for i, r := range results {
+		require.Equal(t, fmt.Sprintf("mirror/copy_%d.go", i), r.Mirrors[0].Location)
+	}
+
+	// interrupting aborts queued and in progress mirror uploads
+	u.mirrors[0].uploader = &blockingUploader{release: make(chan struct{})}
+	results = results[:0]
+	for i := 0; i < maxConcurrentMirrorUploads+2; i++ {
+		_, _, uploadInfo, err := u.Upload("uploader_test.go", fmt.Sprintf("copy_%d.go", i), "text/plain", false)
+		require.NoError(t, err)
+		results = append(results, uploadInfo)
+	}
+	u.Interrupt(0)
+	u.Drain()
+	for _, r := range results {
+		require.Empty(t, r.Mirrors[0].Location)
+		require.Equal(t, context.Canceled.Error(), r.Mirrors[0].Error)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	data, err := os.ReadFile("uploader_test.go")
+	require.NoError(t, err)
+
+	kek := make([]byte, 32)
+	kekEncoded := base64.StdEncoding.EncodeToString(kek)
+
+	// static key
+	dir := t.TempDir()
+	u, err := New(&config.StorageConfig{
+		PathPrefix: dir,
+		Encryption: &config.EncryptionConfig{Key: kekEncoded, KeyID: "test-key"},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	info := u.Encryption()
+	require.Equal(t, "static", info.KeySource)
+	require.Equal(t, "test-key", info.KeyID)
+
+	location, _, _, err := u.Upload("uploader_test.go", "encrypted.go", "text/plain", false)
+	require.NoError(t, err)
+	encrypted, err := os.ReadFile(location)
+	require.NoError(t, err)
+	require.Equal(t, data, decryptForTest(t, kek, info, encrypted))
+
+	// truncated files fail to decrypt
+	_, err = decryptFile(kek, encrypted[:len(encrypted)-10])
+	require.Error(t, err)
+
+	// chunk boundaries
+	for _, size := range []int{0, encryptionChunkSize, 2*encryptionChunkSize + 5} {
+		plain := bytes.Repeat([]byte{'a'}, size)
+		buf := &bytes.Buffer{}
+		require.NoError(t, u.encryptor.encrypt(bytes.NewReader(plain), buf))
+		decrypted, err := decryptFile(kek, buf.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, size, len(decrypted))
+
+		// dropping whole chunks is detected
+		if size > encryptionChunkSize {
+			_, err = decryptFile(kek, buf.Bytes()[:buf.Len()-5-u.encryptor.aead.Overhead()])
+			require.Error(t, err)
+		}
+	}
+
+	// manifests are not encrypted
+	location, _, _, err = u.Upload("uploader_test.go", "manifest.json", types.OutputTypeJSON, false)
+	require.NoError(t, err)
+	plain, err := os.ReadFile(location)
+	require.NoError(t, err)
+	require.Equal(t, data, plain)
+
+	// kms, which wraps keys with kek
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &kmsEncryptReq{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		require.Equal(t, "kms-key", req.KeyID)
+		wrapped, err := wrapKey(kekEncoded, mustDecode(t, req.Plaintext))
+		require.NoError(t, err)
+		_ = json.NewEncoder(w).Encode(&kmsEncryptResp{Ciphertext: base64.StdEncoding.EncodeToString(wrapped)})
+	}))
+	t.Cleanup(server.Close)
+
+	u, err = New(&config.StorageConfig{
+		PathPrefix: dir,
+		Encryption: &config.EncryptionConfig{KMSUrl: server.URL, KeyID: "kms-key"},
+	}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "kms", u.Encryption().KeySource)
+
+	location, _, _, err = u.Upload("uploader_test.go", "kms.go", "text/plain", false)
+	require.NoError(t, err)
+	encrypted, err = os.ReadFile(location)
+	require.NoError(t, err)
+	require.Equal(t, data, decryptForTest(t, kek, u.Encryption(), encrypted))
+
+	// exactly one key source
+	_, err = New(&config.StorageConfig{
+		Encryption: &config.EncryptionConfig{Key: kekEncoded, KMSUrl: server.URL},
+	}, nil, nil, nil, nil)
+	var psrpcErr psrpc.Error
+	require.True(t, errors.As(err, &psrpcErr))
+	require.Equal(t, psrpc.InvalidArgument, psrpcErr.Code())
+}
+
+func decryptForTest(t *testing.T, kek []byte, info *config.Encryption, encrypted []byte) []byte {
+	// the manifest and the file header hold the same wrapped key
+	keyLen := int(binary.BigEndian.Uint16(encrypted[len(encryptionMagic):]))
+	wrapped := encrypted[len(encryptionMagic)+2 : len(encryptionMagic)+2+keyLen]
+	require.Equal(t, info.WrappedKey, base64.StdEncoding.EncodeToString(wrapped))
+
+	plain, err := decryptFile(kek, encrypted)
+	require.NoError(t, err)
+	return plain
+}
+
+func decryptFile(kek, encrypted []byte) ([]byte, error) {
+	if !bytes.HasPrefix(encrypted, []byte(encryptionMagic)) {
+		return nil, errors.New("invalid magic")
+	}
+	encrypted = encrypted[len(encryptionMagic):]
+	keyLen := int(binary.BigEndian.Uint16(encrypted))
+	wrapped := encrypted[2 : 2+keyLen]
+	encrypted = encrypted[2+keyLen:]
+
+	kekGCM, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	ns := kekGCM.NonceSize()
+	dataKey, err := kekGCM.Open(nil, wrapped[:ns], wrapped[ns:], nil)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, encrypted[:noncePrefixSize])
+	encrypted = encrypted[noncePrefixSize:]
+
+	var plain []byte
+	sealedSize := encryptionChunkSize + aead.Overhead()
+	for index := uint32(0); ; index++ {
+		final := len(encrypted) <= sealedSize
+		n := min(sealedSize, len(encrypted))
+		binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+		aad := []byte{0}
+		if final {
+			aad[0] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, encrypted[:n], aad)
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, chunk...)
+		encrypted = encrypted[n:]
+		if final {
+			return plain, nil
+		}
+	}
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/types"
+)
+
+type WebDAVUploader struct {
+	conf    *config.WebDAVConfig
+	base    *url.URL
+	prefix  string
+	client  *http.Client
+	retries *retryPolicy
+
+	mu          sync.Mutex
+	collections map[string]bool // collections known to exist
+}
+
+func newWebDAVUploader(c *config.StorageConfig) (uploader, error) {
+	conf := c.WebDAV
+	base, err := url.Parse(conf.Url)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url %q", conf.Url)
+	}
+	if conf.Token != "" && (conf.Username != "" || conf.Password != "") {
+		return nil, fmt.Errorf("token cannot be combined with username and password")
+	}
+
+	return &WebDAVUploader{
+		conf:        conf,
+		base:        base,
+		prefix:      c.PathPrefix,
+		client:      &http.Client{},
+		retries:     newRetryPolicy(0, 0, 0),
+		collections: make(map[string]bool),
+	}, nil
+}
+
+func (u *WebDAVUploader) upload(ctx context.Context, localFilepath, storageFilepath string, outputType types.OutputType, d *digest) (string, int64, error) {
+	storageFilepath = path.Join(u.prefix, storageFilepath)
+	location := u.url(storageFilepath)
+
+	dir, _ := path.Split(storageFilepath)
+	if err := u.ensureCollection(ctx, dir); err != nil {
+		return "", 0, errors.ErrUploadFailed("WebDAV", err)
+	}
+
+	err := u.retries.do(ctx, "webdav upload", func() error {
+		return u.put(ctx, location, localFilepath, outputType, d)
+	})
+	if err != nil {
+		return "", 0, errors.ErrUploadFailed("WebDAV", err)
+	}
+
+	return location, d.size, nil
+}
+
+func (u *WebDAVUploader) put(ctx context.Context, location, localFilepath string, outputType types.OutputType, d *digest) error {
+	f, err := os.Open(localFilepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, location, d.reader(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", string(outputType))
+
+	resp, err := u.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return fmt.Errorf("put failed: %s", resp.Status)
+	}
+}
+
+func (u *WebDAVUploader) delete(ctx context.Context, storageFilepath string) error {
+	location := u.url(path.Join(u.prefix, storageFilepath))
+	err := u.retries.do(ctx, "webdav delete", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, location, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := u.do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+			return nil
+		default:
+			return fmt.Errorf("delete failed: %s", resp.Status)
+		}
+	})
+	if err != nil {
+		return errors.ErrUploadFailed("WebDAV", err)
+	}
+	return nil
+}
+
+func (u *WebDAVUploader) download(ctx context.Context, storageFilepath string) ([]byte, error) {
+	location := u.url(path.Join(u.prefix, storageFilepath))
+
+	var b []byte
+	var notFound bool
+	err := u.retries.do(ctx, "webdav download", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := u.do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			b, err = io.ReadAll(resp.Body)
+			return err
+		case http.StatusNotFound:
+			notFound = true
+			return nil
+		default:
+			return fmt.Errorf("download failed: %s", resp.Status)
+		}
+	})
+	if err != nil {
+		return nil, errors.ErrUploadFailed("WebDAV", err)
+	}
+	if notFound {
+		return nil, os.ErrNotExist
+	}
+	return b, nil
+}
+
+// ensureCollection creates each collection along dir which is not already known to exist.
+// WebDAV servers do not create intermediate collections on PUT.
+func (u *WebDAVUploader) ensureCollection(ctx context.Context, dir string) error {
+	var current string
+	for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+
+		u.mu.Lock()
+		exists := u.collections[current]
+		u.mu.Unlock()
+		if exists {
+			continue
+		}
+
+		err := u.retries.do(ctx, "webdav mkcol", func() error {
+			return u.mkcol(ctx, current)
+		})
+		if err != nil {
+			return err
+		}
+
+		u.mu.Lock()
+		u.collections[current] = true
+		u.mu.Unlock()
+	}
+	return nil
+}
+
+func (u *WebDAVUploader) mkcol(ctx context.Context, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, "MKCOL", u.url(dir)+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusMethodNotAllowed:
+		// already exists
+		return nil
+	default:
+		return fmt.Errorf("mkcol %s failed: %s", dir, resp.Status)
+	}
+}
+
+func (u *WebDAVUploader) do(req *http.Request) (*http.Response, error) {
+	switch {
+	case u.conf.Token != "":
+		req.Header.Set("Authorization", "Bearer "+u.conf.Token)
+	case u.conf.Username != "" || u.conf.Password != "":
+		req.SetBasicAuth(u.conf.Username, u.conf.Password)
+	}
+	return u.client.Do(req)
+}
+
+func (u *WebDAVUploader) url(storageFilepath string) string {
+	return u.base.JoinPath(storageFilepath).String()
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/frostbyte73/core"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	requestTimeout = time.Second * 10
+	streamID       = "livekit-egress"
+)
+
+// Client publishes encoded media to a WHIP endpoint (RFC 9725).
+// Urls are of the form whip(s)://({bearer_token}@){host}/{path}, sent as http(s) requests.
+type Client struct {
+	endpoint string
+	token    string
+	resource string // session url returned by the endpoint, deleted on close
+
+	pc    *webrtc.PeerConnection
+	audio *webrtc.TrackLocalStaticSample
+	video *webrtc.TrackLocalStaticSample
+
+	onConnected func()
+	onFailure   func(error)
+
+	connected core.Fuse
+	closed    core.Fuse
+}
+
+// NewClient creates a peer connection sending the enabled tracks. Connect negotiates the session.
+func NewClient(rawUrl string, audio, video bool, onConnected func(), onFailure func(error)) (*Client, error) {
+	endpoint, token, err := parseUrl(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		endpoint:    endpoint,
+		token:       token,
+		pc:          pc,
+		onConnected: onConnected,
+		onFailure:   onFailure,
+	}
+
+	if audio {
+		if c.audio, err = c.addTrack(webrtc.MimeTypeOpus, "audio"); err != nil {
+			_ = pc.Close()
+			return nil, err
+		}
+	}
+	if video {
+		if c.video, err = c.addTrack(webrtc.MimeTypeH264, "video"); err != nil {
+			_ = pc.Close()
+			return nil, err
+		}
+	}
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Debugw("whip connection state changed", "state", state.String())
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			if !c.connected.IsBroken() {
+				c.connected.Break()
+				c.onConnected()
+			}
+		case webrtc.PeerConnectionStateFailed:
+			c.fail(errors.New("whip connection failed"))
+		}
+	})
+
+	return c, nil
+}
+
+func parseUrl(rawUrl string) (string, string, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", "", errors.ErrInvalidUrl(rawUrl, err.Error())
+	}
+
+	switch parsed.Scheme {
+	case "whip":
+		parsed.Scheme = "http"
+	case "whips":
+		parsed.Scheme = "https"
+	default:
+		return "", "", errors.ErrInvalidUrl(rawUrl, "invalid scheme")
+	}
+
+	var token string
+	if parsed.User != nil {
+		token = parsed.User.Username()
+		parsed.User = nil
+	}
+	return parsed.String(), token, nil
+}
+
+func (c *Client) addTrack(mimeType, id string) (*webrtc.TrackLocalStaticSample, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeType}, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	transceiver, err := c.pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// rtcp needs to be read for interceptors such as nack to work
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := transceiver.Sender().Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	return track, nil
+}
+
+// Connect posts an offer to the endpoint, with all candidates gathered, and applies the answer
+func (c *Client) Connect(ctx context.Context) error {
+	offer, err := c.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(c.pc)
+	if err = c.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	select {
+	case <-gatherComplete:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewBufferString(c.pc.LocalDescription().SDP))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	c.setAuthorization(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	answer, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("whip endpoint returned %s", resp.Status)
+	}
+
+	if location := resp.Header.Get("Location"); location != "" {
+		if resource, err := resp.Request.URL.Parse(location); err == nil {
+			c.resource = resource.String()
+		}
+	}
+
+	return c.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  string(answer),
+	})
+}
+
+func (c *Client) setAuthorization(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// WriteAudio sends an opus packet. Samples are dropped until connected.
+func (c *Client) WriteAudio(data []byte, duration time.Duration) error {
+	if c.audio == nil {
+		return nil
+	}
+	return c.audio.WriteSample(media.Sample{Data: data, Duration: duration})
+}
+
+// WriteVideo sends an h264 access unit, in byte-stream format. Samples are dropped until connected.
+func (c *Client) WriteVideo(data []byte, duration time.Duration) error {
+	if c.video == nil {
+		return nil
+	}
+	return c.video.WriteSample(media.Sample{Data: data, Duration: duration})
+}
+
+func (c *Client) fail(err error) {
+	if c.closed.IsBroken() {
+		return
+	}
+	c.onFailure(err)
+}
+
+// Close ends the session, deleting it from the endpoint
+func (c *Client) Close() error {
+	if c.closed.IsBroken() {
+		return nil
+	}
+	c.closed.Break()
+
+	if c.resource != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.resource, nil)
+		if err == nil {
+			c.setAuthorization(req)
+			if resp, err := http.DefaultClient.Do(req); err != nil {
+				logger.Warnw("failed to delete whip session", err)
+			} else {
+				_ = resp.Body.Close()
+			}
+		}
+	}
+
+	return c.pc.Close()
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package whip
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUrl(t *testing.T) {
+	endpoint, token, err := parseUrl("whips://token@whip.example.com/live/room?id=1")
+	require.NoError(t, err)
+	require.Equal(t, "https://whip.example.com/live/room?id=1", endpoint)
+	require.Equal(t, "token", token)
+
+	endpoint, token, err = parseUrl("whip://localhost:8080/whip")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8080/whip", endpoint)
+	require.Empty(t, token)
+
+	_, _, err = parseUrl("rtmp://localhost/live/key")
+	require.Error(t, err)
+}
+
+func TestConnect(t *testing.T) {
+	var answerer *webrtc.PeerConnection
+	deleted := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			require.Equal(t, "application/sdp", r.Header.Get("Content-Type"))
+			offer, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(offer), "m=audio")
+			require.Contains(t, string(offer), "m=video")
+
+			answerer, err = webrtc.NewPeerConnection(webrtc.Configuration{})
+			require.NoError(t, err)
+			require.NoError(t, answerer.SetRemoteDescription(webrtc.SessionDescription{
+				Type: webrtc.SDPTypeOffer,
+				SDP:  string(offer),
+			}))
+			answer, err := answerer.CreateAnswer(nil)
+			require.NoError(t, err)
+			require.NoError(t, answerer.SetLocalDescription(answer))
+
+			w.Header().Set("Content-Type", "application/sdp")
+			w.Header().Set("Location", "/whip/session")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(answer.SDP))
+
+		case http.MethodDelete:
+			require.Equal(t, "/whip/session", r.URL.Path)
+			close(deleted)
+		}
+	}))
+	defer server.Close()
+
+	rawUrl := strings.Replace(server.URL, "http://", "whip://token@", 1) + "/whip"
+	c, err := NewClient(rawUrl, true, true, func() {}, func(error) {})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Connect(context.Background()))
+	require.Equal(t, server.URL+"/whip/session", c.resource)
+
+	require.NoError(t, c.Close())
+	<-deleted
+	_ = answerer.Close()
+
+	unauthorized, err := NewClient(strings.Replace(rawUrl, "token@", "", 1), true, false, func() {}, func(error) {})
+	require.NoError(t, err)
+	require.Error(t, unauthorized.Connect(context.Background()))
+	require.NoError(t, unauthorized.Close())
+}
@@ -16,8 +16,10 @@ package source
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -177,6 +179,10 @@ func (s *SDKSource) joinRoom() error {
 		cb.OnParticipantDisconnected = s.onParticipantDisconnected
 	}
 
+	if s.TimedMetadata != nil && s.TimedMetadata.DataTopic != "" && s.GetSegmentConfig() != nil {
+		cb.OnDataPacket = s.onDataPacket
+	}
+
 	logger.Debugw("connecting to room")
 	room, err := lksdk.ConnectToRoomWithToken(s.WsUrl, s.Token, cb, lksdk.WithAutoSubscribe(false))
 	if err != nil {
@@ -653,6 +659,25 @@ func (s *SDKSource) onTrackFinished(trackID string) {
 	}
 }
 
+// onDataPacket inserts timed metadata sent as json with the configured topic, by the server api or an allowed participant
+func (s *SDKSource) onDataPacket(data lksdk.DataPacket, params lksdk.DataReceiveParams) {
+	packet, ok := data.(*lksdk.UserDataPacket)
+	if !ok || packet.Topic != s.TimedMetadata.DataTopic {
+		return
+	}
+	if params.SenderIdentity != "" && !slices.Contains(s.TimedMetadata.AllowedIdentities, params.SenderIdentity) {
+		logger.Debugw("ignoring timed metadata", "sender", params.SenderIdentity)
+		return
+	}
+
+	m := &config.TimedMetadata{}
+	if err := json.Unmarshal(packet.Payload, m); err != nil {
+		logger.Warnw("invalid timed metadata", err, "sender", params.SenderIdentity)
+		return
+	}
+	s.callbacks.OnMetadata(m)
+}
+
 func (s *SDKSource) onParticipantDisconnected(rp *lksdk.RemoteParticipant) {
 	if rp.Identity() == s.Identity {
 		logger.Debugw("participant disconnected")
@@ -164,6 +164,7 @@ const (
 	elementGstRtmp2Sink    = "GstRtmp2Sink"
 	elementGstSplitMuxSink = "GstSplitMuxSink"
 	elementGstSrtSink      = "GstSRTSink"
+	elementGstRtspSink     = "GstRTSPClientSink"
 
 	msgStreamingNotNegotiated = "streaming stopped, reason not-negotiated (-4)"
 	msgMuxer                  = ":muxer"
@@ -173,8 +174,18 @@ const (
 func (c *Controller) handleMessageError(gErr *gst.GError) error {
 	element, name, message := parseDebugInfo(gErr)
 
+	// rtspclientsink is a bin, so most push failures come from its children
+	if sinkName, ok := parseAncestor(gErr, elementGstRtspSink); ok {
+		stream, err := c.streamBin.GetStream(strings.TrimPrefix(sinkName, "rtspclientsink_"))
+		if err != nil {
+			return err
+		}
+
+		return c.streamFailed(context.Background(), stream, gErr)
+	}
+
 	switch {
-	case element == elementGstRtmp2Sink:
+	case element == elementGstRtmp2Sink, element == elementGstSrtSink:
 		streamName := strings.Split(name, "_")[1]
 		stream, err := c.streamBin.GetStream(streamName)
 		if err != nil {
@@ -194,15 +205,6 @@ func (c *Controller) handleMessageError(gErr *gst.GError) error {
 		// remove sink
 		return c.streamFailed(context.Background(), stream, gErr)
 
-	case element == elementGstSrtSink:
-		streamName := strings.Split(name, "_")[1]
-		stream, err := c.streamBin.GetStream(streamName)
-		if err != nil {
-			return err
-		}
-
-		return c.streamFailed(context.Background(), stream, gErr)
-
 	case element == elementGstAppSrc:
 		if message == msgStreamingNotNegotiated {
 			// send eos to app src
@@ -268,7 +270,7 @@ func (c *Controller) handleMessageElement(msg *gst.Message) error {
 			}
 			logger.Debugw("received FirstSampleMetadata message", "startDate", startDate)
 
-			c.getSegmentSink().UpdateStartDate(startDate)
+			c.getSegmentedSink(msg.Source()).UpdateStartDate(startDate)
 
 		case msgFragmentOpened:
 			filepath, t, err := getSegmentParamsFromGstStructure(s)
@@ -277,7 +279,7 @@ func (c *Controller) handleMessageElement(msg *gst.Message) error {
 				return err
 			}
 
-			if err = c.getSegmentSink().FragmentOpened(filepath, t); err != nil {
+			if err = c.getSegmentedSink(msg.Source()).FragmentOpened(filepath, t); err != nil {
 				logger.Errorw("failed to register new segment with playlist writer", err, "location", filepath, "runningTime", t)
 				return err
 			}
@@ -292,7 +294,7 @@ func (c *Controller) handleMessageElement(msg *gst.Message) error {
 			// We need to dispatch to a queue to:
 			// 1. Avoid concurrent access to the SegmentsInfo structure
 			// 2. Ensure that playlists are uploaded in the same order they are enqueued to avoid an older playlist overwriting a newer one
-			if err = c.getSegmentSink().FragmentClosed(filepath, t); err != nil {
+			if err = c.getSegmentedSink(msg.Source()).FragmentClosed(filepath, t); err != nil {
 				logger.Errorw("failed to end segment with playlist writer", err, "runningTime", t)
 				return err
 			}
@@ -332,6 +334,24 @@ func parseDebugInfo(gErr *gst.GError) (element, name, message string) {
 	return
 }
 
+// parseAncestor walks up from the element which posted the error, returning the name of the first of the given type
+func parseAncestor(gErr *gst.GError, elementType string) (string, bool) {
+	debug := gErr.DebugString()
+	start := strings.Index(debug, "/GstPipeline:")
+	if start < 0 {
+		return "", false
+	}
+
+	path, _, _ := strings.Cut(debug[start+1:], ":\n")
+	segments := strings.Split(path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if t, name, ok := strings.Cut(segments[i], ":"); ok && t == elementType {
+			return name, true
+		}
+	}
+	return "", false
+}
+
 const (
 	fragmentLocation    = "location"
 	fragmentRunningTime = "running-time"
@@ -397,13 +417,21 @@ func getImageInformationFromGstStructure(s *gst.Structure) (string, uint64, erro
 
 }
 
-func (c *Controller) getSegmentSink() *sink.SegmentSink {
+// getSegmentedSink returns the sink for a splitmuxsink's messages
+func (c *Controller) getSegmentedSink(name string) sink.SegmentedSink {
+	if builder.IsIngestSegmentSink(name) {
+		return c.sinks[types.EgressTypeStream][0].(sink.SegmentedSink)
+	}
+	return c.getSegmentSink()
+}
+
+func (c *Controller) getSegmentSink() sink.SegmentedSink {
 	s := c.sinks[types.EgressTypeSegments]
 	if len(s) == 0 {
 		return nil
 	}
 
-	return s[0].(*sink.SegmentSink)
+	return s[0].(sink.SegmentedSink)
 }
 
 func (c *Controller) getImageSink(name string) *sink.ImageSink {
@@ -86,6 +86,18 @@ func NewServer(conf *config.ServiceConfig, bus psrpc.MessageBus, ioClient info.I
 		s.StartDebugHandlers(conf.DebugHandlerPort)
 	}
 
+	if conf.PresignPort > 0 {
+		s.StartPresignHandler(conf.PresignPort)
+	}
+
+	if conf.MetadataPort > 0 {
+		s.StartMetadataHandler(conf.MetadataPort)
+	}
+
+	if conf.LowLatencyHLS != nil && conf.LowLatencyHLS.Port > 0 {
+		s.StartPartServerProxy(conf.LowLatencyHLS.Port)
+	}
+
 	if conf.PrometheusPort > 0 {
 		s.promServer = &http.Server{
 			Addr:    fmt.Sprintf(":%d", conf.PrometheusPort),
@@ -150,6 +162,13 @@ func (s *Server) Run() error {
 		return err
 	}
 
+	// complete uploads left behind by handlers which did not exit cleanly
+	s.activeRequests.Inc()
+	go func() {
+		defer s.activeRequests.Dec()
+		s.recoverOrphanedEgresses()
+	}()
+
 	logger.Infow("service ready")
 	<-s.shutdown.Watch()
 	logger.Infow("draining")
@@ -0,0 +1,53 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/protocol/auth"
+)
+
+// canAuthorize returns true if the service has the api key needed to verify access tokens
+func (s *Server) canAuthorize() bool {
+	return s.conf.ApiKey != "" && s.conf.ApiSecret != ""
+}
+
+// authorize requires a bearer access token, signed with the service's api key, which grants roomRecord
+func (s *Server) authorize(r *http.Request) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return errors.New("missing access token")
+	}
+
+	v, err := auth.ParseAPIToken(token)
+	if err != nil {
+		return err
+	}
+	if v.APIKey() != s.conf.ApiKey {
+		return errors.New("invalid api key")
+	}
+
+	grants, err := v.Verify(s.conf.ApiSecret)
+	if err != nil {
+		return err
+	}
+	if grants.Video == nil || !grants.Video.RoomRecord {
+		return errors.New("missing roomRecord permission")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/protocol/logger"
+)
+
+// StartPartServerProxy serves low-latency hls outputs of every handler on this instance from one port.
+// Requests for /<egress_id>/<filename> are forwarded to the handler's part server.
+func (s *Server) StartPartServerProxy(port int) {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = "handler"
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				egressID := ctx.Value(egressIDKey{}).(string)
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", config.PartServerSocket(egressID))
+			},
+			DisableKeepAlives: true,
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, _ error) {
+			http.Error(w, "egress not found", http.StatusNotFound)
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// URL path format is "/<egress_id>/<filename>"
+		egressID, filename, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if !ok || egressID == "" || egressID == "." || egressID == ".." || filename == "" {
+			http.Error(w, "malformed url", http.StatusNotFound)
+			return
+		}
+		if _, err := s.GetGRPCClient(egressID); err != nil {
+			http.Error(w, "egress not found", http.StatusNotFound)
+			return
+		}
+
+		out := r.Clone(context.WithValue(r.Context(), egressIDKey{}, egressID))
+		out.URL.Path, out.URL.RawPath = "/"+filename, ""
+		proxy.ServeHTTP(w, out)
+	})
+
+	go func() {
+		addr := fmt.Sprintf(":%d", port)
+		logger.Debugw(fmt.Sprintf("starting low-latency playlist proxy on address %s", addr))
+		_ = http.ListenAndServe(addr, mux)
+	}()
+}
+
+type egressIDKey struct{}
@@ -0,0 +1,102 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/ipc"
+	"github.com/livekit/protocol/logger"
+	"github.com/livekit/psrpc"
+)
+
+func (s *Server) StartMetadataHandler(port int) {
+	if port == 0 {
+		logger.Debugw("metadata handler disabled")
+		return
+	}
+	if !s.canAuthorize() {
+		logger.Warnw("metadata handler disabled", nil, "reason", "api key and secret required")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/", s.handleMetadata)
+
+	go func() {
+		addr := fmt.Sprintf(":%d", port)
+		logger.Debugw(fmt.Sprintf("starting metadata handler on address %s", addr))
+		_ = http.ListenAndServe(addr, mux)
+	}()
+}
+
+// URL path format is "/metadata/<egress_id>", with a json TimedMetadata body and an access token granting roomRecord
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	egressID := strings.TrimPrefix(r.URL.Path, "/metadata/")
+	if egressID == "" || strings.Contains(egressID, "/") {
+		http.Error(w, "malformed url", http.StatusNotFound)
+		return
+	}
+
+	m := &config.TimedMetadata{}
+	if err := json.NewDecoder(r.Body).Decode(m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, err := s.GetGRPCClient(egressID)
+	if err != nil {
+		http.Error(w, "handler not found", http.StatusNotFound)
+		return
+	}
+
+	req := &ipc.InsertMetadataRequest{
+		Id:              m.ID,
+		Class:           m.Class,
+		Duration:        m.Duration,
+		PlannedDuration: m.PlannedDuration,
+		Cue:             m.Cue,
+		Scte35:          m.SCTE35,
+		Attributes:      m.Attributes,
+	}
+	if !m.StartDate.IsZero() {
+		req.StartDate = m.StartDate.UnixNano()
+	}
+	if _, err = c.InsertMetadata(r.Context(), req); err != nil {
+		code := http.StatusInternalServerError
+		var e psrpc.Error
+		if errors.As(err, &e) {
+			code = e.ToHttp()
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
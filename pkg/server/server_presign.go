@@ -0,0 +1,143 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	maxPresignFilenames = 10000
+	maxPresignExpiry    = time.Hour * 24 * 7
+)
+
+// PresignRequest re-signs files stored by this service's storage config, using the filenames found in
+// FileInfo, SegmentsInfo or the manifest. Requests using per-request credentials cannot be re-signed.
+// Only files listed by the egress manifest are signed, and requests need an access token granting roomRecord.
+type PresignRequest struct {
+	EgressID  string   `json:"egress_id"`
+	Manifest  string   `json:"manifest"` // storage path of the egress manifest, <egress_id>.json
+	Filenames []string `json:"filenames"`
+	Expiry    string   `json:"expiry,omitempty"` // e.g. 24h, defaults to the storage config, at most 7 days
+	Backup    bool     `json:"backup,omitempty"` // sign against backup storage, for files with backup set in the manifest
+}
+
+type PresignResponse struct {
+	Locations []*PresignedLocation `json:"locations"`
+}
+
+type PresignedLocation struct {
+	Filename string `json:"filename"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (s *Server) StartPresignHandler(port int) {
+	if port == 0 {
+		logger.Debugw("presign handler disabled")
+		return
+	}
+	if !s.canAuthorize() {
+		logger.Warnw("presign handler disabled", nil, "reason", "api key and secret required")
+		return
+	}
+
+	u, err := uploader.New(s.conf.StorageConfig, s.conf.BackupConfig, nil, nil, nil)
+	if err != nil {
+		logger.Errorw("presign handler disabled", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/presign", func(w http.ResponseWriter, r *http.Request) {
+		s.handlePresign(u, w, r)
+	})
+
+	go func() {
+		addr := fmt.Sprintf(":%d", port)
+		logger.Debugw(fmt.Sprintf("starting presign handler on address %s", addr))
+		_ = http.ListenAndServe(addr, mux)
+	}()
+}
+
+func (s *Server) handlePresign(u *uploader.Uploader, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	req := &PresignRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.EgressID == "" || path.Base(req.Manifest) != req.EgressID+".json" {
+		http.Error(w, "invalid manifest", http.StatusBadRequest)
+		return
+	}
+	if len(req.Filenames) == 0 || len(req.Filenames) > maxPresignFilenames {
+		http.Error(w, "invalid filenames", http.StatusBadRequest)
+		return
+	}
+
+	var expiry time.Duration
+	if req.Expiry != "" {
+		var err error
+		if expiry, err = time.ParseDuration(req.Expiry); err != nil || expiry <= 0 || expiry > maxPresignExpiry {
+			http.Error(w, "invalid expiry", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// only files written by the egress can be signed
+	b, err := u.Download(req.Manifest, types.OutputTypeJSON)
+	if err != nil {
+		http.Error(w, "manifest not found", http.StatusNotFound)
+		return
+	}
+	manifest := &config.Manifest{}
+	if err = json.Unmarshal(b, manifest); err != nil || manifest.EgressID != req.EgressID {
+		http.Error(w, "manifest not found", http.StatusNotFound)
+		return
+	}
+	filenames := manifest.Filenames()
+
+	res := &PresignResponse{}
+	for _, filename := range req.Filenames {
+		l := &PresignedLocation{Filename: filename}
+		if !filenames[filename] {
+			l.Error = "not found in manifest"
+		} else if l.Location, err = u.Presign(r.Context(), filename, expiry, req.Backup); err != nil {
+			l.Error = err.Error()
+		}
+		res.Locations = append(res.Locations, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
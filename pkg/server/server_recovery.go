@@ -0,0 +1,167 @@
+// Copyright 2024 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+
+	"github.com/livekit/egress/pkg/config"
+	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/sink/m3u8"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
+	"github.com/livekit/egress/pkg/types"
+	"github.com/livekit/protocol/logger"
+)
+
+// recoverOrphanedEgresses finds egress tmp dirs left behind by handlers which did not exit cleanly,
+// and completes their pending uploads.
+func (s *Server) recoverOrphanedEgresses() {
+	entries, err := os.ReadDir(config.TmpDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnw("failed to read tmp dir", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || s.AlreadyExists(entry.Name()) {
+			continue
+		}
+		dir := path.Join(config.TmpDir, entry.Name())
+		if _, err = os.Stat(path.Join(dir, uploader.JournalFilename)); err != nil {
+			continue
+		}
+		s.recoverEgress(dir)
+	}
+}
+
+// recoverEgress uploads everything still pending in the journal, playlists last, then sends a final egress update.
+// Returns false if the journal could not be read, in which case no update is sent.
+func (s *Server) recoverEgress(dir string) bool {
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	pending, err := uploader.ReadJournal(dir)
+	if err != nil {
+		logger.Warnw("failed to read upload journal", err, "dir", dir)
+		return false
+	}
+	if pending.Info == nil {
+		return false
+	}
+
+	info := pending.Info
+	logger.Infow("recovering egress", "egressID", info.EgressId, "pendingUploads", len(pending.Uploads))
+
+	uploaders := make(map[int]*uploader.Uploader)
+	recovered := 0
+	upload := func(u *uploader.JournalUpload) {
+		d := pending.Destinations[u.Destination]
+		if d == nil {
+			return
+		}
+		if _, err := os.Stat(u.LocalFilepath); err != nil {
+			logger.Warnw("pending upload missing", err, "egressID", info.EgressId, "filepath", u.LocalFilepath)
+			return
+		}
+
+		up := uploaders[d.ID]
+		if up == nil {
+			storage, backup, err := d.Resolve(&s.conf.BaseConfig)
+			if err != nil {
+				logger.Warnw("failed to resolve storage", err, "egressID", info.EgressId)
+				return
+			}
+			if up, err = uploader.New(storage, backup, nil, nil, info); err != nil {
+				logger.Warnw("failed to create uploader", err, "egressID", info.EgressId)
+				return
+			}
+			uploaders[d.ID] = up
+		}
+
+		location, size, _, err := up.Upload(u.LocalFilepath, u.StorageFilepath, u.OutputType, false)
+		if err != nil {
+			logger.Warnw("failed to recover upload", err, "egressID", info.EgressId, "filepath", u.StorageFilepath)
+			return
+		}
+		recovered++
+
+		switch d.EgressType {
+		case types.EgressTypeFile:
+			for _, f := range info.FileResults {
+				if f.Filename == u.StorageFilepath {
+					f.Location = location
+					f.Size = size
+				}
+			}
+		case types.EgressTypeSegments:
+			for _, sr := range info.SegmentResults {
+				switch u.StorageFilepath {
+				case sr.PlaylistName:
+					sr.PlaylistLocation = location
+				case sr.LivePlaylistName:
+					sr.LivePlaylistLocation = location
+				}
+			}
+		}
+	}
+
+	var playlists []*uploader.JournalUpload
+	for _, u := range pending.Uploads {
+		if u.Playlist {
+			playlists = append(playlists, u)
+		} else {
+			upload(u)
+		}
+	}
+	for _, u := range playlists {
+		// dash mpds are uploaded as last written, since they have no end tag to append
+		if u.OutputType == types.OutputTypeHLS {
+			if err = endPlaylist(u.LocalFilepath); err != nil {
+				logger.Warnw("failed to end playlist", err, "egressID", info.EgressId)
+			}
+		}
+		upload(u)
+	}
+
+	info.SetFailed(errors.ErrHandlerExited(recovered, len(pending.Uploads)))
+	if err = s.ioClient.UpdateEgress(context.Background(), info); err != nil {
+		logger.Errorw("failed to update egress", err, "egressID", info.EgressId)
+	}
+	return true
+}
+
+// endPlaylist marks an hls playlist as complete, since the handler exited before closing it
+func endPlaylist(filepath string) error {
+	b, err := os.ReadFile(filepath)
+	if err != nil || bytes.Contains(b, []byte("#EXT-X-ENDLIST")) {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// marked so that a restarted egress can still resume the playlist
+	_, err = f.WriteString(m3u8.RecoveredComment + "\n#EXT-X-ENDLIST\n")
+	return err
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/livekit/egress/pkg/config"
 	"github.com/livekit/egress/pkg/errors"
+	"github.com/livekit/egress/pkg/pipeline/sink/uploader"
 	"github.com/livekit/protocol/egress"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
@@ -144,17 +145,17 @@ func (s *Server) launchProcess(req *rpc.StartEgressRequest, info *livekit.Egress
 }
 
 func (s *Server) processEnded(req *rpc.StartEgressRequest, info *livekit.EgressInfo, err error) {
+	var recoveryDir string
 	if err != nil {
 		// should only happen if process failed catashrophically
-		now := time.Now().UnixNano()
-		info.UpdatedAt = now
-		info.EndedAt = now
-		info.Status = livekit.EgressStatus_EGRESS_FAILED
-		info.Error = "internal error"
-		info.ErrorCode = int32(http.StatusInternalServerError)
-		_ = s.ioClient.UpdateEgress(context.Background(), info)
-
 		logger.Errorw("process failed", err)
+
+		dir := path.Join(config.TmpDir, info.EgressId)
+		if _, statErr := os.Stat(path.Join(dir, uploader.JournalFilename)); statErr == nil {
+			recoveryDir = dir
+		} else {
+			s.sendProcessFailed(info)
+		}
 	}
 
 	avgCPU, maxCPU, maxMemory := s.monitor.EgressEnded(req)
@@ -169,6 +170,26 @@ func (s *Server) processEnded(req *rpc.StartEgressRequest, info *livekit.EgressI
 
 	s.ProcessFinished(info.EgressId)
 	s.activeRequests.Dec()
+
+	if recoveryDir != "" {
+		// pending uploads are recovered once the egress no longer counts towards capacity,
+		// and the recovered egress info is sent as the only final update
+		go func() {
+			if !s.recoverEgress(recoveryDir) {
+				s.sendProcessFailed(info)
+			}
+		}()
+	}
+}
+
+func (s *Server) sendProcessFailed(info *livekit.EgressInfo) {
+	now := time.Now().UnixNano()
+	info.UpdatedAt = now
+	info.EndedAt = now
+	info.Status = livekit.EgressStatus_EGRESS_FAILED
+	info.Error = "internal error"
+	info.ErrorCode = int32(http.StatusInternalServerError)
+	_ = s.ioClient.UpdateEgress(context.Background(), info)
 }
 
 func (s *Server) StartEgressAffinity(_ context.Context, req *rpc.StartEgressRequest) float32 {
@@ -22,6 +22,12 @@ type HandlerMonitor struct {
 	uploadsCounter      *prometheus.CounterVec
 	uploadsResponseTime *prometheus.HistogramVec
 	backupCounter       *prometheus.CounterVec
+	partsCounter        *prometheus.CounterVec
+	partBytesCounter    *prometheus.CounterVec
+	mirrorCounter       *prometheus.CounterVec
+	streamBytesAcked    *prometheus.GaugeVec
+	streamBitrate       *prometheus.GaugeVec
+	streamReconnects    *prometheus.GaugeVec
 }
 
 func NewHandlerMonitor(nodeId string, clusterId string, egressId string) *HandlerMonitor {
@@ -54,7 +60,58 @@ func NewHandlerMonitor(nodeId string, clusterId string, egressId string) *Handle
 		ConstLabels: constantLabels,
 	}, []string{"output_type"})
 
-	prometheus.MustRegister(m.uploadsCounter, m.uploadsResponseTime, m.backupCounter)
+	m.partsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "pipeline_upload_parts",
+		Help:        "Number of multipart upload parts per pipeline with type and status labels",
+		ConstLabels: constantLabels,
+	}, []string{"type", "status"})
+
+	m.partBytesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "pipeline_upload_part_bytes",
+		Help:        "Number of bytes sent in successful multipart upload parts",
+		ConstLabels: constantLabels,
+	}, []string{"type"})
+
+	m.mirrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "mirror_storage_writes",
+		Help:        "number of writes to mirror storage locations by output type and status",
+		ConstLabels: constantLabels,
+	}, []string{"output_type", "status"})
+
+	m.streamBytesAcked = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "stream_bytes_acked",
+		Help:        "bytes acknowledged by the rtmp server, or sent to the srt peer, since a stream last connected",
+		ConstLabels: constantLabels,
+	}, []string{"url"})
+
+	m.streamBitrate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "stream_bitrate_bps",
+		Help:        "bits per second delivered by an rtmp or srt stream, over the last health interval",
+		ConstLabels: constantLabels,
+	}, []string{"url"})
+
+	m.streamReconnects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "livekit",
+		Subsystem:   "egress",
+		Name:        "stream_reconnects",
+		Help:        "number of times an rtmp or srt stream has been reconnected",
+		ConstLabels: constantLabels,
+	}, []string{"url"})
+
+	prometheus.MustRegister(
+		m.uploadsCounter, m.uploadsResponseTime, m.backupCounter, m.partsCounter, m.partBytesCounter, m.mirrorCounter,
+		m.streamBytesAcked, m.streamBitrate, m.streamReconnects,
+	)
 
 	return m
 }
@@ -71,10 +128,41 @@ func (m *HandlerMonitor) IncUploadCountFailure(uploadType string, elapsed float6
 	m.uploadsResponseTime.With(labels).Observe(elapsed)
 }
 
+func (m *HandlerMonitor) IncUploadPartSuccess(uploadType string, size int64) {
+	m.partsCounter.With(prometheus.Labels{"type": uploadType, "status": "success"}).Add(1)
+	m.partBytesCounter.With(prometheus.Labels{"type": uploadType}).Add(float64(size))
+}
+
+func (m *HandlerMonitor) IncUploadPartFailure(uploadType string) {
+	m.partsCounter.With(prometheus.Labels{"type": uploadType, "status": "failure"}).Add(1)
+}
+
 func (m *HandlerMonitor) IncBackupStorageWrites(outputType string) {
 	m.backupCounter.With(prometheus.Labels{"output_type": outputType}).Add(1)
 }
 
+func (m *HandlerMonitor) IncMirrorStorageWriteSuccess(outputType string) {
+	m.mirrorCounter.With(prometheus.Labels{"output_type": outputType, "status": "success"}).Add(1)
+}
+
+func (m *HandlerMonitor) IncMirrorStorageWriteFailure(outputType string) {
+	m.mirrorCounter.With(prometheus.Labels{"output_type": outputType, "status": "failure"}).Add(1)
+}
+
+func (m *HandlerMonitor) UpdateStreamHealth(url string, bytesAcked, bitrate uint64, reconnects int) {
+	labels := prometheus.Labels{"url": url}
+	m.streamBytesAcked.With(labels).Set(float64(bytesAcked))
+	m.streamBitrate.With(labels).Set(float64(bitrate))
+	m.streamReconnects.With(labels).Set(float64(reconnects))
+}
+
+func (m *HandlerMonitor) RemoveStreamHealth(url string) {
+	labels := prometheus.Labels{"url": url}
+	m.streamBytesAcked.Delete(labels)
+	m.streamBitrate.Delete(labels)
+	m.streamReconnects.Delete(labels)
+}
+
 func (m *HandlerMonitor) RegisterSegmentsChannelSizeGauge(nodeId string, clusterId string, egressId string, channelSizeFunction func() float64) {
 	segmentsUploadsGauge := prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
@@ -98,3 +186,15 @@ func (m *HandlerMonitor) RegisterPlaylistChannelSizeGauge(nodeId string, cluster
 		}, channelSizeFunction)
 	prometheus.MustRegister(playlistUploadsGauge)
 }
+
+func (m *HandlerMonitor) RegisterPlaylistStalenessGauge(nodeId string, clusterId string, egressId string, stalenessFunction func() float64) {
+	playlistStalenessGauge := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace:   "livekit",
+			Subsystem:   "egress",
+			Name:        "playlist_staleness_seconds",
+			Help:        "seconds since the oldest playlist change which has not been uploaded",
+			ConstLabels: prometheus.Labels{"node_id": nodeId, "cluster_id": clusterId, "egress_id": egressId},
+		}, stalenessFunction)
+	prometheus.MustRegister(playlistStalenessGauge)
+}
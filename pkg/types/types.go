@@ -21,6 +21,7 @@ type MimeType string
 type Profile string
 type OutputType string
 type FileExtension string
+type SegmentProtocol string
 
 const (
 	// request types
@@ -35,11 +36,12 @@ const (
 	SourceTypeSDK SourceType = "sdk"
 
 	// egress types
-	EgressTypeStream    EgressType = "stream"
-	EgressTypeWebsocket EgressType = "websocket"
-	EgressTypeFile      EgressType = "file"
-	EgressTypeSegments  EgressType = "segments"
-	EgressTypeImages    EgressType = "images"
+	EgressTypeStream     EgressType = "stream"
+	EgressTypeWebsocket  EgressType = "websocket"
+	EgressTypeFile       EgressType = "file"
+	EgressTypeSegments   EgressType = "segments"
+	EgressTypeImages     EgressType = "images"
+	EgressTypeThumbnails EgressType = "thumbnails"
 
 	// input types
 	MimeTypeAAC      MimeType = "audio/aac"
@@ -63,12 +65,17 @@ const (
 	OutputTypeIVF         OutputType = "video/x-ivf"
 	OutputTypeMP4         OutputType = "video/mp4"
 	OutputTypeTS          OutputType = "video/mp2t"
+	OutputTypeM4S         OutputType = "video/iso.segment"
 	OutputTypeWebM        OutputType = "video/webm"
 	OutputTypeJPEG        OutputType = "image/jpeg"
 	OutputTypeRTMP        OutputType = "rtmp"
 	OutputTypeSRT         OutputType = "srt"
+	OutputTypeWHIP        OutputType = "whip"
+	OutputTypeRTSP        OutputType = "rtsp"
 	OutputTypeHLS         OutputType = "application/x-mpegurl"
+	OutputTypeDASH        OutputType = "application/dash+xml"
 	OutputTypeJSON        OutputType = "application/json"
+	OutputTypeWebVTT      OutputType = "text/vtt"
 	OutputTypeBlob        OutputType = "application/octet-stream"
 
 	// file extensions
@@ -77,9 +84,17 @@ const (
 	FileExtensionIVF  = ".ivf"
 	FileExtensionMP4  = ".mp4"
 	FileExtensionTS   = ".ts"
+	FileExtensionM4S  = ".m4s"
 	FileExtensionWebM = ".webm"
 	FileExtensionM3U8 = ".m3u8"
+	FileExtensionMPD  = ".mpd"
 	FileExtensionJPEG = ".jpeg"
+	FileExtensionVTT  = ".vtt"
+
+	// segment protocols
+	SegmentProtocolHLS  SegmentProtocol = "hls"  // mpeg-ts segments
+	SegmentProtocolCMAF SegmentProtocol = "cmaf" // fragmented mp4 segments with an init segment
+	SegmentProtocolDASH SegmentProtocol = "dash" // cmaf segments with an mpd instead of an m3u8 playlist
 )
 
 var (
@@ -91,7 +106,10 @@ var (
 		OutputTypeWebM: MimeTypeOpus,
 		OutputTypeRTMP: MimeTypeAAC,
 		OutputTypeSRT:  MimeTypeAAC,
+		OutputTypeWHIP: MimeTypeOpus,
+		OutputTypeRTSP: MimeTypeAAC,
 		OutputTypeHLS:  MimeTypeAAC,
+		OutputTypeDASH: MimeTypeAAC,
 	}
 
 	DefaultVideoCodecs = map[OutputType]MimeType{
@@ -101,7 +119,10 @@ var (
 		OutputTypeWebM: MimeTypeVP8,
 		OutputTypeRTMP: MimeTypeH264,
 		OutputTypeSRT:  MimeTypeH264,
+		OutputTypeWHIP: MimeTypeH264,
+		OutputTypeRTSP: MimeTypeH264,
 		OutputTypeHLS:  MimeTypeH264,
+		OutputTypeDASH: MimeTypeH264,
 	}
 
 	FileExtensions = map[FileExtension]struct{}{
@@ -110,20 +131,25 @@ var (
 		FileExtensionIVF:  {},
 		FileExtensionMP4:  {},
 		FileExtensionTS:   {},
+		FileExtensionM4S:  {},
 		FileExtensionWebM: {},
 		FileExtensionM3U8: {},
+		FileExtensionMPD:  {},
 		FileExtensionJPEG: {},
 	}
 
 	FileExtensionForOutputType = map[OutputType]FileExtension{
-		OutputTypeRaw:  FileExtensionRaw,
-		OutputTypeOGG:  FileExtensionOGG,
-		OutputTypeIVF:  FileExtensionIVF,
-		OutputTypeMP4:  FileExtensionMP4,
-		OutputTypeTS:   FileExtensionTS,
-		OutputTypeWebM: FileExtensionWebM,
-		OutputTypeHLS:  FileExtensionM3U8,
-		OutputTypeJPEG: FileExtensionJPEG,
+		OutputTypeRaw:    FileExtensionRaw,
+		OutputTypeOGG:    FileExtensionOGG,
+		OutputTypeIVF:    FileExtensionIVF,
+		OutputTypeMP4:    FileExtensionMP4,
+		OutputTypeTS:     FileExtensionTS,
+		OutputTypeM4S:    FileExtensionM4S,
+		OutputTypeWebM:   FileExtensionWebM,
+		OutputTypeHLS:    FileExtensionM3U8,
+		OutputTypeDASH:   FileExtensionMPD,
+		OutputTypeJPEG:   FileExtensionJPEG,
+		OutputTypeWebVTT: FileExtensionVTT,
 	}
 
 	CodecCompatibility = map[OutputType]map[MimeType]bool{
@@ -160,10 +186,22 @@ var (
 			MimeTypeAAC:  true,
 			MimeTypeH264: true,
 		},
+		OutputTypeWHIP: {
+			MimeTypeOpus: true,
+			MimeTypeH264: true,
+		},
+		OutputTypeRTSP: {
+			MimeTypeAAC:  true,
+			MimeTypeH264: true,
+		},
 		OutputTypeHLS: {
 			MimeTypeAAC:  true,
 			MimeTypeH264: true,
 		},
+		OutputTypeDASH: {
+			MimeTypeAAC:  true,
+			MimeTypeH264: true,
+		},
 		OutputTypeUnknownFile: {
 			MimeTypeAAC:  true,
 			MimeTypeOpus: true,
@@ -202,13 +240,21 @@ var (
 	}
 
 	StreamOutputTypes = map[string]OutputType{
-		"rtmp":   OutputTypeRTMP,
-		"rtmps":  OutputTypeRTMP,
-		"mux":    OutputTypeRTMP,
-		"twitch": OutputTypeRTMP,
-		"srt":    OutputTypeSRT,
-		"ws":     OutputTypeRaw,
-		"wss":    OutputTypeRaw,
+		"rtmp":       OutputTypeRTMP,
+		"rtmps":      OutputTypeRTMP,
+		"mux":        OutputTypeRTMP,
+		"twitch":     OutputTypeRTMP,
+		"srt":        OutputTypeSRT,
+		"whip":       OutputTypeWHIP,
+		"whips":      OutputTypeWHIP,
+		"rtsp":       OutputTypeRTSP,
+		"rtsps":      OutputTypeRTSP,
+		"hls+http":   OutputTypeHLS,
+		"hls+https":  OutputTypeHLS,
+		"dash+http":  OutputTypeDASH,
+		"dash+https": OutputTypeDASH,
+		"ws":         OutputTypeRaw,
+		"wss":        OutputTypeRaw,
 	}
 )
 
@@ -18,6 +18,8 @@ package test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -54,6 +56,14 @@ func loadManifest(t *testing.T, c *config.StorageConfig, localFilepath, storageF
 	return m
 }
 
+func requireChecksum(t *testing.T, localFilepath, expected string) {
+	b, err := os.ReadFile(localFilepath)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(b)
+	require.Equal(t, expected, hex.EncodeToString(sum[:]))
+}
+
 func download(t *testing.T, c *config.StorageConfig, localFilepath, storageFilepath string, delete bool) {
 	if c != nil {
 		if c.S3 != nil {
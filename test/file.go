@@ -273,6 +273,8 @@ func (r *Runner) verifyFile(t *testing.T, p *config.PipelineConfig, res *livekit
 	manifestStorage := path.Join(path.Dir(storagePath), res.EgressId+".json")
 	manifest := loadManifest(t, p.GetFileConfig().StorageConfig, manifestLocal, manifestStorage)
 	require.NotNil(t, manifest)
+	require.Len(t, manifest.Files, 1)
+	requireChecksum(t, localPath, manifest.Files[0].SHA256)
 
 	// verify
 	verify(t, localPath, p, res, types.EgressTypeFile, r.Muting, r.sourceFramerate, false)
@@ -63,8 +63,17 @@ var (
 	srtReadUrl1         = fmt.Sprintf("srt://localhost:8890?streamid=read:%s", streamKey1)
 	srtPublishUrl2      = fmt.Sprintf("srt://localhost:8890?streamid=publish:%s&pkt_size=1316", streamKey2)
 	srtReadUrl2         = fmt.Sprintf("srt://localhost:8890?streamid=read:%s", streamKey2)
+	srtPublishRedacted1 = redactSrtUrl(8890, "publish:"+streamKey1)
+	srtPublishRedacted2 = redactSrtUrl(8890, "publish:"+streamKey2)
+	badSrtUrl1Redacted  = redactSrtUrl(8891, "publish:wrongport")
+	badSrtUrl2Redacted  = redactSrtUrl(8891, "publish:badstream")
 )
 
+// redactSrtUrl returns a publish url as listed in stream info, with its stream id redacted
+func redactSrtUrl(port int, streamID string) string {
+	return fmt.Sprintf("srt://localhost:%d?streamid=%s&pkt_size=1316", port, utils.RedactIdentifier(streamID))
+}
+
 // [[publish, redacted, verification]]
 var streamUrls = map[types.OutputType][][]string{
 	types.OutputTypeRTMP: {
@@ -74,10 +83,10 @@ var streamUrls = map[types.OutputType][][]string{
 		{badRtmpUrl2, badRtmpUrl2Redacted, ""},
 	},
 	types.OutputTypeSRT: {
-		{srtPublishUrl1, srtPublishUrl1, srtReadUrl1},
-		{badSrtUrl1, badSrtUrl1, ""},
-		{srtPublishUrl2, srtPublishUrl2, srtReadUrl2},
-		{badSrtUrl2, badSrtUrl2, ""},
+		{srtPublishUrl1, srtPublishRedacted1, srtReadUrl1},
+		{badSrtUrl1, badSrtUrl1Redacted, ""},
+		{srtPublishUrl2, srtPublishRedacted2, srtReadUrl2},
+		{badSrtUrl2, badSrtUrl2Redacted, ""},
 	},
 }
 